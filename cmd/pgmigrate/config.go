@@ -0,0 +1,149 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+)
+
+var (
+	ErrUnsupportedConfigField = errors.New("unsupported config field type")
+	ErrRequiredConfigField    = errors.New("required environment variable not set")
+)
+
+// config wraps postgres.PostgresConfig with debug flag, required by DBConfigService...
+type config struct {
+	*postgres.PostgresConfig
+
+	isDebug bool
+}
+
+func (c *config) IsDebug() bool {
+	return c.isDebug
+}
+
+// loadConfig fills PostgresConfig from same POSTGRESQL_* environment variables which used by services,
+// by envconfig, default and required field tags...
+func loadConfig(isDebug bool) (*config, error) {
+	pgConfig := &postgres.PostgresConfig{}
+
+	err := loadEnv(pgConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pgConfig.Prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	return &config{
+		PostgresConfig: pgConfig,
+		isDebug:        isDebug,
+	}, nil
+}
+
+// loadEnv fills fields of struct with envconfig tag from environment variables. Default tag value used
+// if variable not set, ErrRequiredConfigField returned for not set variable of field with required:"true" tag...
+func loadEnv(dst interface{}) error {
+	cfgValue := reflect.ValueOf(dst).Elem()
+	cfgType := cfgValue.Type()
+
+	for i := 0; i < cfgType.NumField(); i++ {
+		field := cfgType.Field(i)
+
+		envName, hasEnvName := field.Tag.Lookup("envconfig")
+		if !hasEnvName {
+			continue
+		}
+
+		value, isSet := os.LookupEnv(envName)
+		if !isSet {
+			value, isSet = field.Tag.Lookup("default")
+		}
+
+		if !isSet {
+			if field.Tag.Get("required") == "true" {
+				return fmt.Errorf("%w: %s", ErrRequiredConfigField, envName)
+			}
+
+			continue
+		}
+
+		err := setField(cfgValue.Field(i), value)
+		if err != nil {
+			return fmt.Errorf("invalid value of %s environment variable: %w", envName, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	//nolint:exhaustive // only types of PostgresConfig fields supported
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+
+		field.SetBool(boolValue)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		uintValue, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		field.SetUint(uintValue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		intValue, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+
+		field.SetInt(intValue)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedConfigField, field.Type().String())
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+)
+
+// unsetEnv unsets environment variables, previous values restored on test cleanup...
+func unsetEnv(t *testing.T, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		t.Setenv(name, "")
+
+		err := os.Unsetenv(name)
+		if err != nil {
+			t.Fatalf("unable to unset %s environment variable: %v", name, err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	unsetEnv(t, "POSTGRESQL_SSL_MODE", "POSTGRESQL_CONNECTION_RETRY_TIMEOUT", "POSTGRESQL_MAX_OPEN_CONNECTIONS",
+		"POSTGRESQL_CONNECTION_RETRY_COUNT", "POSTGRESQL_DRIVER_NAME", "POSTGRESQL_PASSWORD")
+
+	t.Setenv("POSTGRESQL_SERVICE_HOST", "db.local")
+	t.Setenv("POSTGRESQL_SERVICE_PORT", "6432")
+	t.Setenv("POSTGRESQL_DATABASE_NAME", "wallets")
+	t.Setenv("POSTGRESQL_USERNAME", "wallet")
+	t.Setenv("POSTGRESQL_MAX_IDLE_CONNECTIONS", "2")
+
+	cfg, err := loadConfig(true)
+	if err != nil {
+		t.Fatalf("loadConfig returned error: %v", err)
+	}
+
+	want := postgres.PostgresConfig{
+		DBHost:              "db.local",
+		DBName:              "wallets",
+		DBUsername:          "wallet",
+		DBPassword:          "",
+		DBSSLMode:           "prefer",
+		DBConnectTimeOut:    5000,
+		DBPort:              6432,
+		DBMaxOpenConns:      8,
+		DBMaxIdleConns:      2,
+		DBConnectRetryCount: 0,
+		DBDriverName:        postgres.DefaultDriverName,
+	}
+
+	if !reflect.DeepEqual(*cfg.PostgresConfig, want) {
+		t.Errorf("config = %+v, want %+v", *cfg.PostgresConfig, want)
+	}
+
+	if !cfg.IsDebug() {
+		t.Error("debug flag not set")
+	}
+}
+
+func TestLoadConfigInvalidValue(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value string
+	}{
+		{name: "not a number", value: "many"},
+		{name: "negative", value: "-1"},
+		{name: "overflow", value: strconv.Itoa(256)},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Setenv("POSTGRESQL_MAX_OPEN_CONNECTIONS", testCase.value)
+
+			_, err := loadConfig(false)
+			if !errors.Is(err, strconv.ErrSyntax) && !errors.Is(err, strconv.ErrRange) {
+				t.Errorf("loadConfig error = %v, want parse error", err)
+			}
+		})
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	type testConfig struct {
+		Name     string `envconfig:"PGMIGRATE_TEST_NAME" required:"true"`
+		IsDryRun bool   `envconfig:"PGMIGRATE_TEST_DRY_RUN" default:"true"`
+		Offset   int16  `envconfig:"PGMIGRATE_TEST_OFFSET" default:"-5"`
+		Skipped  string
+	}
+
+	testCases := []struct {
+		name string
+		env  map[string]string
+		want testConfig
+		err  error
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"PGMIGRATE_TEST_NAME": "wallets"},
+			want: testConfig{Name: "wallets", IsDryRun: true, Offset: -5, Skipped: ""},
+			err:  nil,
+		},
+		{
+			name: "values",
+			env:  map[string]string{"PGMIGRATE_TEST_NAME": "", "PGMIGRATE_TEST_DRY_RUN": "0", "PGMIGRATE_TEST_OFFSET": "7"},
+			want: testConfig{Name: "", IsDryRun: false, Offset: 7, Skipped: ""},
+			err:  nil,
+		},
+		{
+			name: "required not set",
+			env:  map[string]string{},
+			want: testConfig{},
+			err:  ErrRequiredConfigField,
+		},
+		{
+			name: "invalid bool",
+			env:  map[string]string{"PGMIGRATE_TEST_NAME": "wallets", "PGMIGRATE_TEST_DRY_RUN": "maybe"},
+			want: testConfig{},
+			err:  strconv.ErrSyntax,
+		},
+		{
+			name: "int overflow",
+			env:  map[string]string{"PGMIGRATE_TEST_NAME": "wallets", "PGMIGRATE_TEST_OFFSET": "40000"},
+			want: testConfig{},
+			err:  strconv.ErrRange,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			unsetEnv(t, "PGMIGRATE_TEST_NAME", "PGMIGRATE_TEST_DRY_RUN", "PGMIGRATE_TEST_OFFSET")
+
+			for name, value := range testCase.env {
+				t.Setenv(name, value)
+			}
+
+			var cfg testConfig
+
+			err := loadEnv(&cfg)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("loadEnv error = %v, want %v", err, testCase.err)
+			}
+
+			if err == nil && cfg != testCase.want {
+				t.Errorf("config = %+v, want %+v", cfg, testCase.want)
+			}
+		})
+	}
+}
+
+func TestLoadEnvUnsupportedField(t *testing.T) {
+	type testConfig struct {
+		Ratio float64 `envconfig:"PGMIGRATE_TEST_RATIO" default:"0.5"`
+	}
+
+	unsetEnv(t, "PGMIGRATE_TEST_RATIO")
+
+	var cfg testConfig
+
+	err := loadEnv(&cfg)
+	if !errors.Is(err, ErrUnsupportedConfigField) {
+		t.Errorf("loadEnv error = %v, want %v", err, ErrUnsupportedConfigField)
+	}
+}
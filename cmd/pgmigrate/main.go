@@ -0,0 +1,152 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+// Command pgmigrate applies SQL migrations with same connection config and semantics as services,
+// PostgreSQL connection config loaded from POSTGRESQL_* environment variables.
+//
+// Usage:
+//
+//	pgmigrate [flags] up [version]
+//	pgmigrate [flags] down [version]
+//	pgmigrate [flags] status
+//	pgmigrate [flags] verify
+//	pgmigrate [flags] create <name>
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/migrations"
+)
+
+var ErrMissingCommand = errors.New("missing command")
+
+func main() {
+	err := run()
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "pgmigrate: "+err.Error())
+
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	flags := flag.NewFlagSet("pgmigrate", flag.ContinueOnError)
+	dir := flags.String("dir", "migrations", "directory of migration files")
+	tableName := flags.String("table", migrations.DefaultTableName, "name of applied migrations table")
+	lockID := flags.Int64("lock-id", migrations.DefaultLockID, "key of migrations advisory lock")
+	isDryRun := flags.Bool("dry-run", false, "only print migrations, without execution")
+	isDebug := flags.Bool("debug", false, "enable debug logging")
+
+	flags.Usage = func() {
+		_, _ = fmt.Fprintln(flags.Output(), "Usage: pgmigrate [flags] up [version] | down [version] | "+
+			"status | verify | create <name>")
+		flags.PrintDefaults()
+	}
+
+	err := flags.Parse(os.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+
+		return ErrMissingCommand
+	}
+
+	command, args := flags.Arg(0), flags.Args()[1:]
+
+	if command == migrations.CommandCreate {
+		return create(*dir, args)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	logLevel := slog.LevelInfo
+	if *isDebug {
+		logLevel = slog.LevelDebug
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+	cfg, err := loadConfig(*isDebug)
+	if err != nil {
+		return err
+	}
+
+	conn := postgres.NewConnection(ctx, nil, nil, cfg, postgres.WithLogger(logger), postgres.WithName("pgmigrate"))
+
+	_, err = conn.Connect()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	migrator, err := migrations.NewMigrator(conn, os.DirFS(*dir),
+		migrations.WithTableName(*tableName),
+		migrations.WithLockID(*lockID),
+		migrations.WithDryRun(*isDryRun))
+	if err != nil {
+		return err
+	}
+
+	return migrations.RunCommand(ctx, migrator, os.Stdout, command, args...)
+}
+
+func create(dir string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: create <name>", migrations.ErrInvalidCommandArgs)
+	}
+
+	upFile, downFile, err := migrations.CreateFiles(dir, args[0], time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(os.Stdout, "created %s\ncreated %s\n", upFile, downFile)
+
+	return err
+}
@@ -0,0 +1,195 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidArrayLiteral    = errors.New("invalid postgres array literal")
+	ErrMultiDimensionalArray  = errors.New("multi-dimensional arrays not supported")
+	ErrNullArrayElement       = errors.New("array contains NULL element")
+	ErrUnsupportedArraySource = errors.New("unsupported source type of array")
+)
+
+// arrayElement is the raw text of array literal element, nil for NULL element...
+type arrayElement []byte
+
+// arraySourceBytes converts source value of Scan function to bytes...
+func arraySourceBytes(src interface{}) ([]byte, error) {
+	switch value := src.(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedArraySource, src)
+	}
+}
+
+// parseArrayLiteral parses one-dimensional postgres array literal, e.g. {1,"a b",NULL}.
+// Multi-dimensional arrays rejected with ErrMultiDimensionalArray...
+func parseArrayLiteral(src []byte) ([]arrayElement, error) {
+	// skip optional dimension decoration, e.g. [1:3]={1,2,3}
+	if len(src) != 0 && src[0] == '[' {
+		idx := bytes.IndexByte(src, '=')
+		if idx < 0 {
+			return nil, ErrInvalidArrayLiteral
+		}
+
+		if bytes.Count(src[:idx], []byte("[")) > 1 {
+			return nil, ErrMultiDimensionalArray
+		}
+
+		src = src[idx+1:]
+	}
+
+	if len(src) < 2 || src[0] != '{' || src[len(src)-1] != '}' {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidArrayLiteral, src)
+	}
+
+	body := src[1 : len(src)-1]
+	if len(body) == 0 {
+		return []arrayElement{}, nil
+	}
+
+	if body[0] == '{' {
+		return nil, ErrMultiDimensionalArray
+	}
+
+	elements := make([]arrayElement, 0, bytes.Count(body, []byte(","))+1)
+
+	for pos := 0; pos <= len(body); pos++ {
+		element, next, err := parseArrayElement(body, pos)
+		if err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, element)
+		pos = next
+
+		if pos < len(body) && body[pos] != ',' {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidArrayLiteral, src)
+		}
+	}
+
+	return elements, nil
+}
+
+// parseArrayElement parses element started at pos, returns position of delimiter after element...
+func parseArrayElement(body []byte, pos int) (arrayElement, int, error) {
+	if pos < len(body) && body[pos] == '{' {
+		return nil, 0, ErrMultiDimensionalArray
+	}
+
+	if pos < len(body) && body[pos] == '"' {
+		var element []byte
+
+		for pos++; pos < len(body); pos++ {
+			switch body[pos] {
+			case '\\':
+				pos++
+				if pos == len(body) {
+					return nil, 0, ErrInvalidArrayLiteral
+				}
+
+				element = append(element, body[pos])
+			case '"':
+				if element == nil {
+					element = []byte{}
+				}
+
+				return element, pos + 1, nil
+			default:
+				element = append(element, body[pos])
+			}
+		}
+
+		return nil, 0, ErrInvalidArrayLiteral
+	}
+
+	end := pos
+	for end < len(body) && body[end] != ',' {
+		end++
+	}
+
+	element := bytes.TrimSpace(body[pos:end])
+	if len(element) == 0 {
+		return nil, 0, ErrInvalidArrayLiteral
+	}
+
+	if bytes.EqualFold(element, []byte("NULL")) {
+		return nil, end, nil
+	}
+
+	return element, end, nil
+}
+
+// formatArrayLiteral formats one-dimensional postgres array literal, nil elements formatted as NULL...
+func formatArrayLiteral(elements []*string) string {
+	var literal strings.Builder
+
+	literal.WriteByte('{')
+
+	for i, element := range elements {
+		if i != 0 {
+			literal.WriteByte(',')
+		}
+
+		if element == nil {
+			literal.WriteString("NULL")
+
+			continue
+		}
+
+		literal.WriteByte('"')
+
+		for _, char := range []byte(*element) {
+			if char == '"' || char == '\\' {
+				literal.WriteByte('\\')
+			}
+
+			literal.WriteByte(char)
+		}
+
+		literal.WriteByte('"')
+	}
+
+	literal.WriteByte('}')
+
+	return literal.String()
+}
@@ -0,0 +1,168 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"errors"
+	"testing"
+)
+
+func stringPointer(value string) *string {
+	return &value
+}
+
+func TestParseArrayLiteral(t *testing.T) {
+	testCases := []struct {
+		name    string
+		literal string
+		// want contains nil for NULL elements
+		want    []*string
+		wantErr error
+	}{
+		{name: "empty", literal: "{}", want: []*string{}},
+		{name: "plain elements", literal: "{1,2.50,-3}",
+			want: []*string{stringPointer("1"), stringPointer("2.50"), stringPointer("-3")}},
+		{name: "NULL elements", literal: "{1,NULL,null}",
+			want: []*string{stringPointer("1"), nil, nil}},
+		{name: "quoted NULL is string", literal: `{"NULL"}`, want: []*string{stringPointer("NULL")}},
+		{name: "quoted empty string", literal: `{""}`, want: []*string{stringPointer("")}},
+		{name: "quoted with delimiters", literal: `{"a,b","{c}"," d "}`,
+			want: []*string{stringPointer("a,b"), stringPointer("{c}"), stringPointer(" d ")}},
+		{name: "escaped quote and backslash", literal: `{"say \"hi\"","back\\slash"}`,
+			want: []*string{stringPointer(`say "hi"`), stringPointer(`back\slash`)}},
+		{name: "dimension decoration", literal: "[1:2]={7,8}", want: []*string{stringPointer("7"), stringPointer("8")}},
+		{name: "multi-dimensional", literal: "{{1,2},{3,4}}", wantErr: ErrMultiDimensionalArray},
+		{name: "multi-dimensional decoration", literal: "[1:1][1:2]={{1,2}}", wantErr: ErrMultiDimensionalArray},
+		{name: "missing braces", literal: "1,2", wantErr: ErrInvalidArrayLiteral},
+		{name: "trailing comma", literal: "{1,}", wantErr: ErrInvalidArrayLiteral},
+		{name: "unterminated quote", literal: `{"abc}`, wantErr: ErrInvalidArrayLiteral},
+		{name: "garbage after quote", literal: `{"a"b}`, wantErr: ErrInvalidArrayLiteral},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			elements, err := parseArrayLiteral([]byte(testCase.literal))
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("parseArrayLiteral error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if testCase.wantErr != nil {
+				return
+			}
+
+			if len(elements) != len(testCase.want) {
+				t.Fatalf("parsed %d elements, want %d", len(elements), len(testCase.want))
+			}
+
+			for i, want := range testCase.want {
+				switch {
+				case want == nil && elements[i] != nil:
+					t.Errorf("element %d = %q, want NULL", i, elements[i])
+				case want != nil && (elements[i] == nil || string(elements[i]) != *want):
+					t.Errorf("element %d = %q, want %q", i, elements[i], *want)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatArrayLiteralRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name     string
+		elements []*string
+		want     string
+	}{
+		{name: "empty", elements: []*string{}, want: "{}"},
+		{name: "NULL element", elements: []*string{stringPointer("a"), nil}, want: `{"a",NULL}`},
+		{name: "NULL string", elements: []*string{stringPointer("NULL")}, want: `{"NULL"}`},
+		{name: "delimiters and spaces", elements: []*string{stringPointer("a,b"), stringPointer(" {c} ")},
+			want: `{"a,b"," {c} "}`},
+		{name: "quote and backslash", elements: []*string{stringPointer(`"x"\y`)}, want: `{"\"x\"\\y"}`},
+		{name: "empty string", elements: []*string{stringPointer("")}, want: `{""}`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			literal := formatArrayLiteral(testCase.elements)
+			if literal != testCase.want {
+				t.Fatalf("formatArrayLiteral = %s, want %s", literal, testCase.want)
+			}
+
+			parsed, err := parseArrayLiteral([]byte(literal))
+			if err != nil {
+				t.Fatalf("parseArrayLiteral(%s) returned error: %v", literal, err)
+			}
+
+			for i, element := range testCase.elements {
+				if (element == nil) != (parsed[i] == nil) || (element != nil && *element != string(parsed[i])) {
+					t.Errorf("round-trip element %d = %q, want %v", i, parsed[i], element)
+				}
+			}
+		})
+	}
+}
+
+func TestNumericArrays(t *testing.T) {
+	var decimals DecimalArray
+
+	err := decimals.Scan([]byte("{1.50,-0.001,42}"))
+	if err != nil {
+		t.Fatalf("DecimalArray.Scan returned error: %v", err)
+	}
+
+	value, err := decimals.Value()
+	if err != nil {
+		t.Fatalf("DecimalArray.Value returned error: %v", err)
+	}
+
+	if value != `{"1.50","-0.001","42"}` {
+		t.Errorf("DecimalArray.Value = %v", value)
+	}
+
+	var integers BigIntArray
+
+	err = integers.Scan("{1,NULL}")
+	if !errors.Is(err, ErrNullArrayElement) {
+		t.Errorf("BigIntArray.Scan error = %v, want %v", err, ErrNullArrayElement)
+	}
+
+	err = integers.Scan("{1,NaN}")
+	if !errors.Is(err, ErrNonFiniteNumeric) {
+		t.Errorf("BigIntArray.Scan error = %v, want %v", err, ErrNonFiniteNumeric)
+	}
+
+	err = integers.Scan(nil)
+	if err != nil || integers != nil {
+		t.Errorf("BigIntArray.Scan(nil) = %v, %v, want nil array", integers, err)
+	}
+}
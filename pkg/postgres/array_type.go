@@ -0,0 +1,231 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresArray is the marker interface of array types, used for rejecting of multi-dimensional arrays...
+type postgresArray interface {
+	isPostgresArray()
+}
+
+// Array is the generic one-dimensional postgres array. Elements which implement sql.Scanner and driver.Valuer
+// scanned and encoded by own functions, other elements converted same with database/sql.
+// NULL elements supported by Scanner elements and pointer elements, e.g. Array[*string].
+// Array can be used as query parameter, e.g. WHERE address = ANY($1)...
+type Array[T any] []T
+
+func (Array[T]) isPostgresArray() {}
+
+func (a *Array[T]) Scan(src interface{}) error {
+	if src == nil {
+		*a = nil
+
+		return nil
+	}
+
+	err := checkArrayElementType[T]()
+	if err != nil {
+		return err
+	}
+
+	elements, err := scanArrayElements(src)
+	if err != nil {
+		return err
+	}
+
+	result := make(Array[T], len(elements))
+
+	for i, element := range elements {
+		err = scanArrayElement(&result[i], element)
+		if err != nil {
+			return fmt.Errorf("unable to scan array element with index %d: %w", i, err)
+		}
+	}
+
+	*a = result
+
+	return nil
+}
+
+func (a Array[T]) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	err := checkArrayElementType[T]()
+	if err != nil {
+		return nil, err
+	}
+
+	elements := make([]*string, len(a))
+
+	for i := range a {
+		elements[i], err = formatArrayElement(a[i])
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode array element with index %d: %w", i, err)
+		}
+	}
+
+	return formatArrayLiteral(elements), nil
+}
+
+func (BigIntArray) isPostgresArray() {}
+
+func (DecimalArray) isPostgresArray() {}
+
+var (
+	postgresArrayType = reflect.TypeOf((*postgresArray)(nil)).Elem()
+	valuerType        = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// checkArrayElementType rejects element types, which are arrays themselves...
+func checkArrayElementType[T any]() error {
+	elemType := reflect.TypeOf((*T)(nil)).Elem()
+	if elemType.Implements(postgresArrayType) || reflect.PointerTo(elemType).Implements(postgresArrayType) {
+		return fmt.Errorf("%w: %s", ErrMultiDimensionalArray, elemType)
+	}
+
+	switch elemType.Kind() { //nolint:exhaustive // only slices and arrays must be checked
+	case reflect.Slice, reflect.Array:
+		if elemType.Elem().Kind() == reflect.Uint8 || elemType.Implements(valuerType) {
+			return nil
+		}
+
+		return fmt.Errorf("%w: %s", ErrMultiDimensionalArray, elemType)
+	default:
+		return nil
+	}
+}
+
+func scanArrayElement[T any](dst *T, element arrayElement) error {
+	if scanner, isScanner := any(dst).(sql.Scanner); isScanner {
+		if element == nil {
+			return scanner.Scan(nil)
+		}
+
+		return scanner.Scan(bytes.Clone(element))
+	}
+
+	if element == nil {
+		if reflect.TypeOf(dst).Elem().Kind() != reflect.Pointer {
+			return ErrNullArrayElement
+		}
+
+		var zero T
+		*dst = zero
+
+		return nil
+	}
+
+	switch typedDst := any(dst).(type) {
+	case *[]byte:
+		return scanByteaArrayElement(typedDst, element)
+	case *time.Time:
+		value, err := pq.ParseTimestamp(nil, string(element))
+		if err != nil {
+			return err //nolint:wrapcheck // wrapped by caller
+		}
+
+		*typedDst = value
+
+		return nil
+	}
+
+	value := sql.Null[T]{} //nolint:exhaustruct // zero value used
+	if err := value.Scan(bytes.Clone(element)); err != nil {
+		return err //nolint:wrapcheck // wrapped by caller
+	}
+
+	*dst = value.V
+
+	return nil
+}
+
+func scanByteaArrayElement(dst *[]byte, element arrayElement) error {
+	if !bytes.HasPrefix(element, []byte(`\x`)) {
+		return fmt.Errorf("%w: bytea element not in hex format", ErrInvalidArrayLiteral)
+	}
+
+	decoded := make([]byte, hex.DecodedLen(len(element)-2))
+
+	_, err := hex.Decode(decoded, element[2:])
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidArrayLiteral, err)
+	}
+
+	*dst = decoded
+
+	return nil
+}
+
+func formatArrayElement(element any) (*string, error) {
+	value, err := driver.DefaultParameterConverter.ConvertValue(element)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // wrapped by caller
+	}
+
+	var text string
+
+	switch typedValue := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		text = typedValue
+	case []byte:
+		text = `\x` + hex.EncodeToString(typedValue)
+	case int64:
+		text = strconv.FormatInt(typedValue, 10)
+	case float64:
+		text = strconv.FormatFloat(typedValue, 'g', -1, 64)
+	case bool:
+		text = strconv.FormatBool(typedValue)
+	case time.Time:
+		text = string(pq.FormatTimestamp(typedValue))
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedArraySource, value)
+	}
+
+	return &text, nil
+}
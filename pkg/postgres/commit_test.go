@@ -0,0 +1,208 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/faultinject"
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/pgerrors"
+
+	"github.com/lib/pq"
+)
+
+const commitVerificationQuery = "SELECT EXISTS (SELECT 1 FROM withdrawals WHERE id = $1)"
+
+var errVerificationFailed = errors.New("verification connection refused")
+
+// newFaultStubConnection returns connection over stub database with fault injection...
+func newFaultStubConnection(t *testing.T, db *stubDatabase, injector *faultinject.Injector) *Connection {
+	t.Helper()
+
+	return newDriverConnection(t, func(driverName string) {
+		faultinject.RegisterDriver(driverName, db, injector)
+	})
+}
+
+// verifiedDatabase returns stub database, which serves result of commit verification query...
+func verifiedDatabase(isCommitted bool) *stubDatabase {
+	return &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+		if statement.query != commitVerificationQuery {
+			return stubResult{}, false //nolint:exhaustruct // result not scripted
+		}
+
+		return stubResult{
+			columns:      []string{"exists"},
+			rows:         [][]driver.Value{{isCommitted}},
+			rowsAffected: 0,
+			err:          nil,
+		}, true
+	}}
+}
+
+func verifyWithdrawal(ctx context.Context, stmt Querier) (bool, error) {
+	var isCommitted bool
+
+	err := stmt.GetContext(ctx, &isCommitted, commitVerificationQuery, 1)
+
+	return isCommitted, err
+}
+
+func TestCommitOutcomeUnknown(t *testing.T) {
+	testCases := []struct {
+		name             string
+		isCommitted      bool
+		verifier         CommitVerifier
+		isOutcomeUnknown bool
+		isErr            bool
+		verificationErr  error
+	}{
+		{name: "without verifier", verifier: nil, isOutcomeUnknown: true, isErr: true},
+		{
+			name: "verified as committed", isCommitted: true, verifier: verifyWithdrawal,
+			isOutcomeUnknown: false, isErr: false,
+		},
+		{
+			name: "verified as not committed", isCommitted: false, verifier: verifyWithdrawal,
+			isOutcomeUnknown: false, isErr: true,
+		},
+		{
+			name: "verification failed",
+			verifier: func(context.Context, Querier) (bool, error) {
+				return false, errVerificationFailed
+			},
+			isOutcomeUnknown: true, isErr: true, verificationErr: errVerificationFailed,
+		},
+	}
+
+	commitFuncs := map[string]func(ctx context.Context, conn *Connection, opts ...TxOption) error{
+		"tx helper": func(ctx context.Context, conn *Connection, opts ...TxOption) error {
+			return conn.BeginTxWithRollbackOnError(ctx, func(txStmtCtx context.Context) error {
+				return ExecExpectRows(txStmtCtx, conn, 1, "INSERT INTO withdrawals")
+			}, opts...)
+		},
+		"contextual tx": func(ctx context.Context, conn *Connection, opts ...TxOption) error {
+			txCtx, err := conn.BeginContextualTxStatement(ctx)
+			if err != nil {
+				return err
+			}
+
+			err = ExecExpectRows(txCtx, conn, 1, "INSERT INTO withdrawals")
+			if err != nil {
+				return err
+			}
+
+			return conn.CommitContextualTxStatement(txCtx, opts...)
+		},
+	}
+
+	for _, testCase := range testCases {
+		for commitName, commit := range commitFuncs {
+			t.Run(testCase.name+" "+commitName, func(t *testing.T) {
+				db := verifiedDatabase(testCase.isCommitted)
+				injector := faultinject.NewInjector(1)
+				injector.AddRule(faultinject.Rule{AfterCommit: true, OnCall: 1}) //nolint:exhaustruct // only trigger
+				conn := newFaultStubConnection(t, db, injector)
+
+				var opts []TxOption
+				if testCase.verifier != nil {
+					opts = append(opts, WithCommitVerifier(testCase.verifier))
+				}
+
+				err := commit(context.Background(), conn, opts...)
+
+				if (err != nil) != testCase.isErr {
+					t.Fatalf("commit error = %v, want error %t", err, testCase.isErr)
+				}
+
+				if errors.Is(err, ErrCommitOutcomeUnknown) != testCase.isOutcomeUnknown {
+					t.Errorf("commit error = %v, want outcome unknown %t", err, testCase.isOutcomeUnknown)
+				}
+
+				if testCase.isErr && !IsConnectionError(err) {
+					t.Errorf("commit error = %v, want connection error", err)
+				}
+
+				var outcomeErr *CommitOutcomeUnknownError
+				if errors.As(err, &outcomeErr) && !errors.Is(outcomeErr.VerificationErr, testCase.verificationErr) {
+					t.Errorf("verification error = %v, want %v", outcomeErr.VerificationErr, testCase.verificationErr)
+				}
+
+				want := []string{"BEGIN", "INSERT INTO withdrawals", "COMMIT"}
+				if testCase.verifier != nil && testCase.verificationErr == nil {
+					want = append(want, commitVerificationQuery)
+				}
+
+				if got := db.queries(); !reflect.DeepEqual(got, want) {
+					t.Errorf("queries = %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestCommitServerErrorNotVerified(t *testing.T) {
+	db := &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+		if statement.query != "COMMIT" {
+			return stubResult{}, false //nolint:exhaustruct // result not scripted
+		}
+
+		return stubResult{columns: nil, rows: nil, rowsAffected: 0, err: &pq.Error{
+			Code:    pgerrors.SQLStateSerializationFailure,
+			Message: "could not serialize access due to read/write dependencies among transactions",
+		}}, true
+	}}
+	conn := newStubConnection(t, db)
+
+	isVerified := false
+
+	err := conn.BeginTxWithRollbackOnError(context.Background(), func(txStmtCtx context.Context) error {
+		return ExecExpectRows(txStmtCtx, conn, 1, "INSERT INTO withdrawals")
+	}, WithCommitVerifier(func(context.Context, Querier) (bool, error) {
+		isVerified = true
+
+		return true, nil
+	}))
+
+	if !errors.Is(err, pgerrors.ErrSerializationFailure) || errors.Is(err, ErrCommitOutcomeUnknown) {
+		t.Errorf("commit error = %v, want %v", err, pgerrors.ErrSerializationFailure)
+	}
+
+	if isVerified {
+		t.Error("commit verifier called for server-side commit error")
+	}
+}
@@ -79,3 +79,9 @@ type DBConfigService interface {
 	BaseConfig
 	CommonDBConfig
 }
+
+// DriverNameConfig is the optional interface of DBConfigService. If config implements it,
+// NewConnection uses returned database/sql driver name, e.g. name of pgreplay or faultinject driver...
+type DriverNameConfig interface {
+	GetDBDriverName() string
+}
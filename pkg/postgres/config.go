@@ -32,12 +32,11 @@
 
 package postgres
 
-import (
-	"fmt"
+var (
+	_ CommonDBConfig   = (*PostgresConfig)(nil)
+	_ DriverNameConfig = (*PostgresConfig)(nil)
 )
 
-var _ CommonDBConfig = (*PostgresConfig)(nil)
-
 type PostgresConfig struct {
 	DBHost     string `envconfig:"POSTGRESQL_SERVICE_HOST"`
 	DBName     string `envconfig:"POSTGRESQL_DATABASE_NAME" secret:"true"`
@@ -51,15 +50,31 @@ type PostgresConfig struct {
 	DBMaxIdleConns   uint8  `envconfig:"POSTGRESQL_MAX_IDLE_CONNECTIONS" default:"8" `
 	// DBConnectRetryCount is the maximum number of reconnection tries. If 0 - infinite loop
 	DBConnectRetryCount uint8 `envconfig:"POSTGRESQL_CONNECTION_RETRY_COUNT" default:"0"`
+	// DBDriverName is the name of registered database/sql driver. If empty - DefaultDriverName
+	DBDriverName string `envconfig:"POSTGRESQL_DRIVER_NAME" default:"postgres"`
 }
 
 func (c *PostgresConfig) Prepare() error {
 	return nil
 }
 
+// GetDatabaseDSN returns key=value connection string, same with connection string of Connection.
+// All values quoted, so empty values and values with spaces or quotes are supported...
 func (c *PostgresConfig) GetDatabaseDSN() string {
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		c.DBHost, c.DBPort, c.DBUsername, c.DBPassword, c.DBName, c.DBSSLMode)
+	return formatPostgresDSN(&connectionParams{
+		host:         c.DBHost,
+		user:         c.DBUsername,
+		password:     c.DBPassword,
+		sslMode:      c.DBSSLMode,
+		database:     c.DBName,
+		options:      nil,
+		retryTimeOut: 0,
+		port:         c.DBPort,
+		retryCount:   0,
+		maxOpenConn:  0,
+		maxIdleConn:  0,
+		debug:        false,
+	})
 }
 
 func (c *PostgresConfig) GetDBHost() string {
@@ -101,3 +116,7 @@ func (c *PostgresConfig) GetDBMaxOpenConns() uint8 {
 func (c *PostgresConfig) GetDBMaxIdleConns() uint8 {
 	return c.DBMaxIdleConns
 }
+
+func (c *PostgresConfig) GetDBDriverName() string {
+	return c.DBDriverName
+}
@@ -34,4 +34,8 @@ package postgres
 
 const (
 	ConnectionRetryCountTag = "retry_count"
+	ErrorTag                = "error"
 )
+
+// DefaultDriverName is the name of lib/pq database/sql driver...
+const DefaultDriverName = "postgres"
@@ -0,0 +1,387 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const DefaultCopyBatchSize = 10000
+
+var ErrCopyRowFailed = errors.New("unable to copy row")
+
+//nolint:gochecknoglobals // it's ok, compiled once
+var copyLineRegexp = regexp.MustCompile(`COPY [^,]+, line (\d+)`)
+
+// CopyRowError contains number of failed row, counting from 1...
+type CopyRowError struct {
+	Row int64
+	Err error
+}
+
+func (e *CopyRowError) Error() string {
+	return fmt.Sprintf("%s %d: %s", ErrCopyRowFailed.Error(), e.Row, e.Err.Error())
+}
+
+func (e *CopyRowError) Unwrap() []error {
+	return []error{ErrCopyRowFailed, e.Err}
+}
+
+// CopyFromSource is the iterator of rows for CopyFrom function...
+type CopyFromSource interface {
+	// Next advances to next row, returns false if no more rows or error occurred
+	Next() bool
+	// Values returns values of current row
+	Values() ([]interface{}, error)
+	// Err returns error of iteration
+	Err() error
+}
+
+type copyFromRows struct {
+	rows [][]interface{}
+	idx  int
+}
+
+func (s *copyFromRows) Next() bool {
+	s.idx++
+
+	return s.idx <= len(s.rows)
+}
+
+func (s *copyFromRows) Values() ([]interface{}, error) {
+	return s.rows[s.idx-1], nil
+}
+
+func (s *copyFromRows) Err() error {
+	return nil
+}
+
+// CopyFromRows returns CopyFromSource of in-memory rows...
+func CopyFromRows(rows [][]interface{}) CopyFromSource {
+	return &copyFromRows{
+		rows: rows,
+		idx:  0,
+	}
+}
+
+type copyFromFunc struct {
+	next   func() ([]interface{}, bool, error)
+	values []interface{}
+	err    error
+}
+
+func (s *copyFromFunc) Next() bool {
+	if s.err != nil {
+		return false
+	}
+
+	values, hasNext, err := s.next()
+	if err != nil {
+		s.err = err
+
+		return false
+	}
+
+	s.values = values
+
+	return hasNext
+}
+
+func (s *copyFromFunc) Values() ([]interface{}, error) {
+	return s.values, nil
+}
+
+func (s *copyFromFunc) Err() error {
+	return s.err
+}
+
+// CopyFromFunc returns CopyFromSource of rows produced by next function.
+// Function must return false if no more rows...
+func CopyFromFunc(next func() ([]interface{}, bool, error)) CopyFromSource {
+	return &copyFromFunc{
+		next:   next,
+		values: nil,
+		err:    nil,
+	}
+}
+
+// CopyOption is optional setting of CopyFrom function...
+type CopyOption func(opts *copyOptions)
+
+type copyOptions struct {
+	isBatchCommit bool
+}
+
+// WithCopyBatchCommit commits each batch in own transaction, if CopyFrom called outside of contextual transaction.
+// Rows of committed batches stay in table, if one of next batches fails. Ignored in contextual transaction...
+func WithCopyBatchCommit() CopyOption {
+	return func(opts *copyOptions) {
+		opts.isBatchCommit = true
+	}
+}
+
+func newCopyOptions(opts ...CopyOption) *copyOptions {
+	copyOpts := &copyOptions{
+		isBatchCommit: false,
+	}
+
+	for _, opt := range opts {
+		opt(copyOpts)
+	}
+
+	return copyOpts
+}
+
+// CopyFrom copies rows into table with COPY FROM STDIN statement.
+// In contextual transaction all batches copied in caller's transaction, otherwise in one own transaction,
+// or each batch in own transaction with WithCopyBatchCommit option. Returns count of copied rows,
+// in case of error - count of rows of committed batches...
+func (c *Connection) CopyFrom(ctx context.Context,
+	table string,
+	columns []string,
+	rows CopyFromSource,
+	batchSize int,
+	opts ...CopyOption,
+) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultCopyBatchSize
+	}
+
+	query := copyInQuery(table, columns)
+
+	_, inTransaction := c.TxFromContext(ctx)
+	if inTransaction || !newCopyOptions(opts...).isBatchCommit {
+		var total int64
+
+		err := c.withCopyTx(ctx, func(tx *sqlx.Tx) error {
+			for {
+				copied, hasMore, copyErr := c.copyBatch(ctx, tx, query, rows, batchSize, total)
+				if copyErr != nil {
+					return copyErr
+				}
+
+				total += copied
+
+				if !hasMore {
+					return nil
+				}
+			}
+		})
+		if err != nil {
+			return 0, c.e.ErrorNoWrap(err)
+		}
+
+		return total, nil
+	}
+
+	var total int64
+
+	for {
+		var (
+			copied  int64
+			hasMore bool
+		)
+
+		err := c.withCopyTx(ctx, func(tx *sqlx.Tx) error {
+			var copyErr error
+
+			copied, hasMore, copyErr = c.copyBatch(ctx, tx, query, rows, batchSize, total)
+
+			return copyErr
+		})
+		if err != nil {
+			return total, c.e.ErrorNoWrap(err)
+		}
+
+		total += copied
+
+		if !hasMore {
+			return total, nil
+		}
+	}
+}
+
+func (c *Connection) withCopyTx(ctx context.Context, copyFunc func(tx *sqlx.Tx) error) error {
+	tx, inTransaction := c.TxFromContext(ctx)
+	if inTransaction {
+		return copyFunc(tx)
+	}
+
+	return c.BeginReadCommittedTxRollbackOnError(ctx, func(txStmtCtx context.Context) error {
+		return c.MustWithTransaction(txStmtCtx, copyFunc)
+	})
+}
+
+func (c *Connection) copyBatch(ctx context.Context,
+	tx *sqlx.Tx,
+	query string,
+	rows CopyFromSource,
+	batchSize int,
+	rowOffset int64,
+) (int64, bool, error) {
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return 0, false, c.ClassifyErrorContext(ctx, err)
+	}
+
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	var copied int64
+
+	hasMore := true
+
+	for copied < int64(batchSize) {
+		if !rows.Next() {
+			hasMore = false
+
+			break
+		}
+
+		values, valuesErr := rows.Values()
+		if valuesErr != nil {
+			return 0, false, c.e.ErrorOnly(&CopyRowError{Row: rowOffset + copied + 1, Err: valuesErr})
+		}
+
+		_, err = stmt.ExecContext(ctx, values...)
+		if err != nil {
+			return 0, false, c.copyRowError(ctx, err, rowOffset, rowOffset+copied+1)
+		}
+
+		copied++
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return 0, false, c.e.ErrorOnly(&CopyRowError{Row: rowOffset + copied + 1, Err: err})
+	}
+
+	// empty exec flushes buffered rows and finishes COPY statement
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		return 0, false, c.copyRowError(ctx, err, rowOffset, 0)
+	}
+
+	return copied, hasMore, nil
+}
+
+// copyRowError resolves number of failed row. Server reports line number of COPY statement in error context,
+// rows buffered by driver so data errors usually reported on later rows or on final flush...
+func (c *Connection) copyRowError(ctx context.Context, err error, rowOffset, currentRow int64) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		matches := copyLineRegexp.FindStringSubmatch(pqErr.Where)
+		if len(matches) == 2 { //nolint:mnd // full match and line number
+			line, parseErr := strconv.ParseInt(matches[1], 10, 64)
+			if parseErr == nil {
+				return c.e.ErrorOnly(&CopyRowError{Row: rowOffset + line, Err: c.ClassifyErrorContext(ctx, err)})
+			}
+		}
+	}
+
+	if currentRow == 0 || errors.Is(err, sql.ErrTxDone) {
+		return c.ClassifyErrorContext(ctx, err)
+	}
+
+	return c.e.ErrorOnly(&CopyRowError{Row: currentRow, Err: c.ClassifyErrorContext(ctx, err)})
+}
+
+// CopyFromStructs copies slice of structs into table. Columns derived from db tags, same with sqlx mapping...
+func CopyFromStructs[T any](ctx context.Context,
+	conn *Connection,
+	table string,
+	items []T,
+	batchSize int,
+	opts ...CopyOption,
+) (int64, error) {
+	idx := 0
+
+	return CopyFromStructFunc(ctx, conn, table, func() (T, bool, error) {
+		if idx >= len(items) {
+			var zero T
+
+			return zero, false, nil
+		}
+
+		idx++
+
+		return items[idx-1], true, nil
+	}, batchSize, opts...)
+}
+
+// CopyFromStructFunc copies structs produced by next function into table without loading all items in memory.
+// Function must return false if no more items. Columns derived from db tags, same with sqlx mapping...
+func CopyFromStructFunc[T any](ctx context.Context,
+	conn *Connection,
+	table string,
+	next func() (T, bool, error),
+	batchSize int,
+	opts ...CopyOption,
+) (int64, error) {
+	mapping, err := newStructMapping(conn.Dbx.Mapper, reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return 0, conn.e.ErrorOnly(err)
+	}
+
+	rows := CopyFromFunc(func() ([]interface{}, bool, error) {
+		item, hasNext, nextErr := next()
+		if nextErr != nil || !hasNext {
+			return nil, hasNext, nextErr
+		}
+
+		return mapping.values(reflect.ValueOf(item)), true, nil
+	})
+
+	return conn.CopyFrom(ctx, table, mapping.columns, rows, batchSize, opts...)
+}
+
+// copyInQuery returns COPY FROM STDIN statement. Table name can be qualified by schema name...
+func copyInQuery(table string, columns []string) string {
+	schema, tableName, hasSchema := strings.Cut(table, ".")
+	if hasSchema {
+		return pq.CopyInSchema(schema, tableName, columns...)
+	}
+
+	return pq.CopyIn(table, columns...)
+}
@@ -0,0 +1,273 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+const copyWalletsQuery = `COPY "wallets" ("id", "balance") FROM STDIN`
+
+// copyFlushDatabase returns stub database, which fails flush of given COPY batch, counting from 1...
+func copyFlushDatabase(failedBatch int, flushErr error) *stubDatabase {
+	flushes := 0
+
+	return &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+		if statement.query != copyWalletsQuery || len(statement.args) != 0 {
+			return stubResult{}, false //nolint:exhaustruct // result not scripted
+		}
+
+		flushes++
+		if flushes != failedBatch {
+			return stubResult{}, false //nolint:exhaustruct // result not scripted
+		}
+
+		return stubResult{columns: nil, rows: nil, rowsAffected: 0, err: flushErr}, true
+	}}
+}
+
+func copyWalletRows(count int) CopyFromSource {
+	rows := make([][]interface{}, count)
+
+	for i := range rows {
+		rows[i] = []interface{}{int64(i + 1), "0"}
+	}
+
+	return CopyFromRows(rows)
+}
+
+func countStubQueries(db *stubDatabase, query string) int {
+	count := 0
+
+	for _, executed := range db.queries() {
+		if executed == query {
+			count++
+		}
+	}
+
+	return count
+}
+
+func TestCopyFromBatches(t *testing.T) {
+	errFlush := errors.New("flush failed")
+
+	testCases := []struct {
+		name          string
+		opts          []CopyOption
+		failedBatch   int
+		copied        int64
+		beginCount    int
+		commitCount   int
+		rollbackCount int
+	}{
+		{name: "one transaction", opts: nil, failedBatch: 0, copied: 5, beginCount: 1, commitCount: 1},
+		{
+			name: "batch commit", opts: []CopyOption{WithCopyBatchCommit()}, failedBatch: 0,
+			copied: 5, beginCount: 3, commitCount: 3,
+		},
+		{name: "one transaction failed", opts: nil, failedBatch: 2, copied: 0, beginCount: 1, rollbackCount: 1},
+		{
+			name: "batch commit failed", opts: []CopyOption{WithCopyBatchCommit()}, failedBatch: 2,
+			copied: 2, beginCount: 2, commitCount: 1, rollbackCount: 1,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := copyFlushDatabase(testCase.failedBatch, errFlush)
+			conn := newStubConnection(t, db)
+
+			copied, err := conn.CopyFrom(context.Background(), "wallets", []string{"id", "balance"},
+				copyWalletRows(5), 2, testCase.opts...)
+			if testCase.failedBatch == 0 && err != nil {
+				t.Fatalf("CopyFrom returned error: %v", err)
+			}
+
+			if testCase.failedBatch != 0 && !errors.Is(err, errFlush) {
+				t.Fatalf("CopyFrom error = %v, want %v", err, errFlush)
+			}
+
+			if copied != testCase.copied {
+				t.Errorf("copied = %d, want %d", copied, testCase.copied)
+			}
+
+			if countStubQueries(db, "BEGIN") != testCase.beginCount ||
+				countStubQueries(db, "COMMIT") != testCase.commitCount ||
+				countStubQueries(db, "ROLLBACK") != testCase.rollbackCount {
+				t.Errorf("unexpected transactions, executed %q", db.queries())
+			}
+		})
+	}
+}
+
+func TestCopyFromContextualTxIgnoresBatchCommit(t *testing.T) {
+	db := &stubDatabase{}
+	conn := newStubConnection(t, db)
+
+	err := conn.BeginTxWithRollbackOnError(context.Background(), func(txStmtCtx context.Context) error {
+		copied, copyErr := conn.CopyFrom(txStmtCtx, "wallets", []string{"id", "balance"},
+			copyWalletRows(5), 2, WithCopyBatchCommit())
+		if copied != 5 {
+			t.Errorf("copied = %d, want 5", copied)
+		}
+
+		return copyErr
+	})
+	if err != nil {
+		t.Fatalf("transaction returned error: %v", err)
+	}
+
+	if countStubQueries(db, "BEGIN") != 1 || countStubQueries(db, "COMMIT") != 1 {
+		t.Errorf("batches not copied in caller's transaction, executed %q", db.queries())
+	}
+
+	if countStubQueries(db, copyWalletsQuery) != 8 {
+		t.Errorf("executed %d COPY statements, want 5 rows and 3 flushes", countStubQueries(db, copyWalletsQuery))
+	}
+}
+
+func TestCopyFromRowError(t *testing.T) {
+	errRow := errors.New("row failed")
+
+	testCases := []struct {
+		name   string
+		db     *stubDatabase
+		rows   CopyFromSource
+		row    int64
+		hasRow bool
+	}{
+		{
+			name: "line of flushed batch",
+			db: copyFlushDatabase(2, &pq.Error{ //nolint:exhaustruct // only code and context
+				Code:  "22P02",
+				Where: `COPY wallets, line 2, column balance: "abc"`,
+			}),
+			rows:   copyWalletRows(5),
+			row:    4,
+			hasRow: true,
+		},
+		{
+			name:   "flush without line",
+			db:     copyFlushDatabase(1, &pq.Error{Code: "23505"}), //nolint:exhaustruct // only code
+			rows:   copyWalletRows(5),
+			row:    0,
+			hasRow: false,
+		},
+		{
+			name: "exec of buffered row",
+			db: &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+				if statement.query != copyWalletsQuery || len(statement.args) == 0 || statement.args[0] != int64(3) {
+					return stubResult{}, false //nolint:exhaustruct // result not scripted
+				}
+
+				return stubResult{columns: nil, rows: nil, rowsAffected: 0, err: errRow}, true
+			}},
+			rows:   copyWalletRows(5),
+			row:    3,
+			hasRow: true,
+		},
+		{
+			name: "values of source",
+			db:   &stubDatabase{},
+			rows: CopyFromFunc(func() ([]interface{}, bool, error) {
+				return nil, false, errRow
+			}),
+			row:    1,
+			hasRow: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			conn := newStubConnection(t, testCase.db)
+
+			_, err := conn.CopyFrom(context.Background(), "wallets", []string{"id", "balance"}, testCase.rows, 2)
+			if err == nil {
+				t.Fatal("CopyFrom returned nil error")
+			}
+
+			var rowErr *CopyRowError
+
+			isRowErr := errors.As(err, &rowErr)
+			if isRowErr != testCase.hasRow {
+				t.Fatalf("CopyFrom error = %v, row error expected - %t", err, testCase.hasRow)
+			}
+
+			if isRowErr && rowErr.Row != testCase.row {
+				t.Errorf("failed row = %d, want %d", rowErr.Row, testCase.row)
+			}
+		})
+	}
+}
+
+func TestCopyFromStructs(t *testing.T) {
+	type walletRow struct {
+		ID      int64  `db:"id"`
+		Balance string `db:"balance"`
+	}
+
+	db := &stubDatabase{}
+	conn := newStubConnection(t, db)
+
+	copied, err := CopyFromStructs(context.Background(), conn, "wallets",
+		[]walletRow{{ID: 1, Balance: "10"}, {ID: 2, Balance: "20"}}, 0)
+	if err != nil {
+		t.Fatalf("CopyFromStructs returned error: %v", err)
+	}
+
+	if copied != 2 {
+		t.Errorf("copied = %d, want 2", copied)
+	}
+
+	statement, _ := db.find(copyWalletsQuery)
+	if len(statement.args) != 0 || countStubQueries(db, copyWalletsQuery) != 3 {
+		t.Errorf("rows not flushed, executed %q", db.queries())
+	}
+
+	want := []driver.Value{int64(2), "20"}
+
+	for i := len(db.statements) - 1; i >= 0; i-- {
+		if db.statements[i].query == copyWalletsQuery && len(db.statements[i].args) != 0 {
+			if db.statements[i].args[0] != want[0] || db.statements[i].args[1] != want[1] {
+				t.Errorf("last row args = %v, want %v", db.statements[i].args, want)
+			}
+
+			break
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/internal/connstr"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidDSN = errors.New("invalid postgres connection string")
+
+const (
+	defaultDSNHost = "localhost"
+	defaultDSNPort = 5432
+	// defaultMaxConns - same with default values of PostgresConfig
+	defaultMaxConns = 8
+)
+
+// formatDSNOptions returns additional connection string parameters in stable order...
+func formatDSNOptions(options map[string]string) string {
+	if len(options) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var builder strings.Builder
+
+	for _, key := range keys {
+		builder.WriteString(" ")
+		builder.WriteString(key)
+		builder.WriteString("=")
+		builder.WriteString(connstr.QuoteValue(options[key]))
+	}
+
+	return builder.String()
+}
+
+// parseDSN parses URL or key=value connection string to connection params.
+// Parameters without own connectionParams field stored as additional options...
+func parseDSN(dsn string) (*connectionParams, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		convertedDSN, err := pq.ParseURL(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidDSN, err)
+		}
+
+		dsn = convertedDSN
+	}
+
+	values, err := parseDSNValues(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &connectionParams{
+		host:         defaultDSNHost,
+		user:         "",
+		password:     "",
+		sslMode:      "", // empty value - lib/pq default mode
+		database:     "",
+		options:      nil,
+		retryTimeOut: 0,
+		port:         defaultDSNPort,
+		retryCount:   1,
+		maxOpenConn:  defaultMaxConns,
+		maxIdleConn:  defaultMaxConns,
+		debug:        false,
+	}
+
+	for key, value := range values {
+		switch key {
+		case "host":
+			params.host = value
+		case "port":
+			port, parseErr := strconv.ParseUint(value, 10, 16)
+			if parseErr != nil {
+				return nil, fmt.Errorf("%w: invalid port %q", ErrInvalidDSN, value)
+			}
+
+			params.port = uint16(port)
+		case "user":
+			params.user = value
+		case "password":
+			params.password = value
+		case "dbname":
+			params.database = value
+		case "sslmode":
+			params.sslMode = value
+		default:
+			if params.options == nil {
+				params.options = make(map[string]string)
+			}
+
+			params.options[key] = value
+		}
+	}
+
+	return params, nil
+}
+
+// parseDSNValues parses key=value connection string, same with libpq - values can be single-quoted,
+// backslash escapes quote and backslash characters...
+func parseDSNValues(dsn string) (map[string]string, error) {
+	values := make(map[string]string)
+	runes := []rune(dsn)
+
+	skipSpaces := func(position int) int {
+		for position < len(runes) && unicode.IsSpace(runes[position]) {
+			position++
+		}
+
+		return position
+	}
+
+	position := skipSpaces(0)
+
+	for position < len(runes) {
+		keyStart := position
+		for position < len(runes) && !unicode.IsSpace(runes[position]) && runes[position] != '=' {
+			position++
+		}
+
+		key := string(runes[keyStart:position])
+
+		position = skipSpaces(position)
+		if position >= len(runes) || runes[position] != '=' || key == "" {
+			return nil, fmt.Errorf("%w: missing \"=\" after %q", ErrInvalidDSN, key)
+		}
+
+		position = skipSpaces(position + 1)
+
+		var value strings.Builder
+
+		isQuoted := position < len(runes) && runes[position] == '\''
+		if isQuoted {
+			position++
+		}
+
+		for ; position < len(runes); position++ {
+			char := runes[position]
+
+			if isQuoted && char == '\'' {
+				break
+			}
+
+			if !isQuoted && unicode.IsSpace(char) {
+				break
+			}
+
+			if char == '\\' {
+				position++
+				if position >= len(runes) {
+					return nil, fmt.Errorf("%w: missing character after backslash", ErrInvalidDSN)
+				}
+
+				char = runes[position]
+			}
+
+			value.WriteRune(char)
+		}
+
+		if isQuoted {
+			if position >= len(runes) {
+				return nil, fmt.Errorf("%w: unterminated quoted string", ErrInvalidDSN)
+			}
+
+			position++
+		}
+
+		values[key] = value.String()
+		position = skipSpaces(position)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,150 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// libpqOptions parses connection string by lib/pq parser, same with sql.Open of lib/pq driver.
+// Parsed options read from unexported field of pq.Connector, lib/pq has no other exported parser...
+func libpqOptions(t *testing.T, dsn string) map[string]string {
+	t.Helper()
+
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		t.Fatalf("lib/pq unable to parse %s: %v", dsn, err)
+	}
+
+	opts := reflect.ValueOf(connector).Elem().FieldByName("opts")
+	if opts.Kind() != reflect.Map {
+		t.Skip("options of pq.Connector not available in this lib/pq version")
+	}
+
+	parsed := make(map[string]string, opts.Len())
+
+	iter := opts.MapRange()
+	for iter.Next() {
+		parsed[iter.Key().String()] = iter.Value().String()
+	}
+
+	return parsed
+}
+
+func TestFormatPostgresDSNRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name   string
+		params *connectionParams
+	}{
+		{name: "plain values", params: &connectionParams{
+			host: "localhost", port: 5432, user: "wallet", password: "secret", database: "wallet", sslMode: "disable",
+		}},
+		{name: "empty password - trust authentication", params: &connectionParams{
+			host: "/tmp/pg-socket", port: 5432, user: "postgres", password: "", database: "postgres", sslMode: "disable",
+		}},
+		{name: "values with spaces and quotes", params: &connectionParams{
+			host: "db", port: 6432, user: "o'brien", password: `pa ss\word`, database: "my db", sslMode: "",
+		}},
+		{name: "additional options", params: &connectionParams{
+			host: "db", port: 5432, user: "u", password: "p", database: "d", sslMode: "require",
+			options: map[string]string{"application_name": "wallet api"},
+		}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dsn := formatPostgresDSN(testCase.params)
+
+			parsed, err := parseDSN(dsn)
+			if err != nil {
+				t.Fatalf("parseDSN(%s) returned error: %v", dsn, err)
+			}
+
+			want := testCase.params
+			if parsed.host != want.host || parsed.port != want.port || parsed.user != want.user ||
+				parsed.password != want.password || parsed.database != want.database || parsed.sslMode != want.sslMode {
+				t.Errorf("parseDSN(%s) = %+v, want %+v", dsn, parsed, want)
+			}
+
+			for key, value := range want.options {
+				if parsed.options[key] != value {
+					t.Errorf("option %s = %q, want %q", key, parsed.options[key], value)
+				}
+			}
+
+			libpqParsed := libpqOptions(t, dsn)
+			wantLibpq := map[string]string{
+				"host": want.host, "port": strconv.Itoa(int(want.port)), "user": want.user,
+				"password": want.password, "dbname": want.database, "sslmode": want.sslMode,
+			}
+
+			for key, value := range want.options {
+				wantLibpq[key] = value
+			}
+
+			for key, value := range wantLibpq {
+				if libpqParsed[key] != value {
+					t.Errorf("lib/pq parsed %s = %q, want %q", key, libpqParsed[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestPostgresConfigGetDatabaseDSN(t *testing.T) {
+	cfg := &PostgresConfig{
+		DBHost:     "db",
+		DBPort:     6432,
+		DBUsername: "o'brien",
+		DBPassword: `pa ss\word'`,
+		DBName:     "my db",
+		DBSSLMode:  "disable",
+	}
+
+	parsed := libpqOptions(t, cfg.GetDatabaseDSN())
+
+	want := map[string]string{
+		"host": "db", "port": "6432", "user": "o'brien", "password": `pa ss\word'`, "dbname": "my db",
+		"sslmode": "disable",
+	}
+
+	for key, value := range want {
+		if parsed[key] != value {
+			t.Errorf("lib/pq parsed %s = %q, want %q", key, parsed[key], value)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var _ errorFormatterService = (*defaultErrorFormatter)(nil)
+
+// codedError is the error with code of default error formatter...
+type codedError struct {
+	err  error
+	code int
+}
+
+func (e *codedError) Error() string {
+	return fmt.Sprintf("%s (code: %d)", e.err.Error(), e.code)
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// defaultErrorFormatter is the minimal implementation of error formatter service, used by Connection
+// if error formatter not passed to constructor. Errors wrapped by standard fmt.Errorf function...
+type defaultErrorFormatter struct{}
+
+func (s *defaultErrorFormatter) ErrorWithCode(err error, code int) error {
+	return &codedError{err: err, code: code}
+}
+
+func (s *defaultErrorFormatter) ErrWithCode(err error, code int) error {
+	return s.ErrorWithCode(err, code)
+}
+
+func (s *defaultErrorFormatter) ErrorGetCode(err error) int {
+	var codedErr *codedError
+	if errors.As(err, &codedErr) {
+		return codedErr.code
+	}
+
+	return 0
+}
+
+func (s *defaultErrorFormatter) ErrGetCode(err error) int {
+	return s.ErrorGetCode(err)
+}
+
+func (s *defaultErrorFormatter) ErrorNoWrap(err error) error {
+	return err
+}
+
+func (s *defaultErrorFormatter) ErrNoWrap(err error) error {
+	return err
+}
+
+func (s *defaultErrorFormatter) ErrorOnly(err error, details ...string) error {
+	if len(details) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%w: %s", err, strings.Join(details, ", "))
+}
+
+func (s *defaultErrorFormatter) Error(err error, details ...string) error {
+	return s.ErrorOnly(err, details...)
+}
+
+func (s *defaultErrorFormatter) Errorf(err error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))
+}
+
+func (s *defaultErrorFormatter) NewError(details ...string) error {
+	return errors.New(strings.Join(details, ", ")) //nolint:err113 // dynamic error by design
+}
+
+func (s *defaultErrorFormatter) NewErrorf(format string, args ...interface{}) error {
+	return fmt.Errorf(format, args...) //nolint:err113 // dynamic error by design
+}
@@ -0,0 +1,155 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/pgerrors"
+)
+
+var (
+	ErrStatementTimeout = errors.New("statement cancelled by statement timeout")
+	ErrLockNotAvailable = errors.New("lock not available - lock timeout exceeded or NOWAIT lock request failed")
+	// ErrCommitOutcomeUnknown - connection was lost while COMMIT was in flight.
+	// Transaction may be committed or not, so retry of such transaction is not safe
+	ErrCommitOutcomeUnknown = errors.New("transaction commit outcome unknown - connection lost during commit")
+)
+
+// TimeoutError is returned if statement was cancelled by statement_timeout or lock was not acquired
+// in lock_timeout. PostgreSQL uses same 55P03 SQLSTATE code for failed NOWAIT lock requests...
+type TimeoutError struct {
+	// SQLState is the PostgreSQL error code - 57014 or 55P03
+	SQLState string
+
+	reason error
+	err    error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s: %s", e.reason.Error(), e.err.Error())
+}
+
+// Unwrap allows to match TimeoutError with ErrStatementTimeout or ErrLockNotAvailable and with original driver error...
+func (e *TimeoutError) Unwrap() []error {
+	return []error{e.reason, e.err}
+}
+
+// ClassifyTimeoutError wraps timeout driver errors with TimeoutError, other errors returned as is.
+// Same 57014 SQLSTATE code used for cancel requests, e.g. by context cancellation, so 57014 error classified
+// as ErrStatementTimeout only if statement timeout was set. Server messages depend on lc_messages setting,
+// so they are not used for classification...
+func ClassifyTimeoutError(err error, isStatementTimeoutSet bool) error {
+	var timeoutErr *TimeoutError
+	if errors.As(err, &timeoutErr) {
+		return err
+	}
+
+	var reason error
+
+	sqlState := pgerrors.SQLState(err)
+
+	switch sqlState {
+	case pgerrors.SQLStateQueryCanceled:
+		if !isStatementTimeoutSet {
+			return err
+		}
+
+		reason = ErrStatementTimeout
+	case pgerrors.SQLStateLockNotAvailable:
+		reason = ErrLockNotAvailable
+	default:
+		return err
+	}
+
+	return &TimeoutError{
+		SQLState: sqlState,
+		reason:   reason,
+		err:      err,
+	}
+}
+
+// CommitOutcomeUnknownError is returned if connection was lost while COMMIT was in flight
+// and transaction state can not be verified...
+type CommitOutcomeUnknownError struct {
+	// Err is the original commit error
+	Err error
+	// VerificationErr is the error of commit verifier, nil if verifier not set
+	VerificationErr error
+}
+
+func (e *CommitOutcomeUnknownError) Error() string {
+	if e.VerificationErr != nil {
+		return fmt.Sprintf("%s: %s, verification failed: %s", ErrCommitOutcomeUnknown.Error(),
+			e.Err.Error(), e.VerificationErr.Error())
+	}
+
+	return fmt.Sprintf("%s: %s", ErrCommitOutcomeUnknown.Error(), e.Err.Error())
+}
+
+// Unwrap allows to match CommitOutcomeUnknownError with ErrCommitOutcomeUnknown and with original errors...
+func (e *CommitOutcomeUnknownError) Unwrap() []error {
+	if e.VerificationErr != nil {
+		return []error{ErrCommitOutcomeUnknown, e.Err, e.VerificationErr}
+	}
+
+	return []error{ErrCommitOutcomeUnknown, e.Err}
+}
+
+// IsConnectionError reports whether error is connection-level error - lost or broken connection...
+func IsConnectionError(err error) bool {
+	return pgerrors.IsConnectionError(err)
+}
+
+// ClassifyError classifies driver error by SQLSTATE and wraps it with stable error code.
+// Result can be matched with pgerrors sentinel errors and ErrLockNotAvailable. Statement timeout
+// can not be told from cancel request without context, use ClassifyErrorContext for that...
+func (c *Connection) ClassifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return c.ef.Format(ClassifyTimeoutError(err, false))
+}
+
+// ClassifyErrorContext same with ClassifyError, but 57014 error classified as ErrStatementTimeout
+// if contextual transaction was started with statement timeout and context was not cancelled...
+func (c *Connection) ClassifyErrorContext(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return c.ef.Format(ClassifyTimeoutError(err, c.isStatementTimeoutSet(ctx)))
+}
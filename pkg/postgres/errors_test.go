@@ -0,0 +1,287 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/pgerrors"
+
+	"github.com/lib/pq"
+)
+
+func TestClassifyTimeoutError(t *testing.T) {
+	errOther := errors.New("connection refused")
+
+	testCases := []struct {
+		name                  string
+		err                   error
+		isStatementTimeoutSet bool
+		reason                error
+	}{
+		{
+			name:                  "statement timeout",
+			err:                   &pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"},
+			isStatementTimeoutSet: true,
+			reason:                ErrStatementTimeout,
+		},
+		{
+			name:                  "localized statement timeout",
+			err:                   &pq.Error{Code: "57014", Message: "Abbruch der Anweisung wegen Zeitüberschreitung"},
+			isStatementTimeoutSet: true,
+			reason:                ErrStatementTimeout,
+		},
+		{
+			name:                  "cancel request without statement timeout",
+			err:                   &pq.Error{Code: "57014", Message: "canceling statement due to statement timeout"},
+			isStatementTimeoutSet: false,
+			reason:                nil,
+		},
+		{
+			name:                  "lock timeout",
+			err:                   &pq.Error{Code: "55P03", Message: "canceling statement due to lock timeout"},
+			isStatementTimeoutSet: false,
+			reason:                ErrLockNotAvailable,
+		},
+		{
+			name:                  "nowait lock request",
+			err:                   &pq.Error{Code: "55P03", Message: "could not obtain lock on row in relation"},
+			isStatementTimeoutSet: true,
+			reason:                ErrLockNotAvailable,
+		},
+		{
+			name:                  "other sqlstate",
+			err:                   &pq.Error{Code: "40001", Message: "could not serialize access"},
+			isStatementTimeoutSet: true,
+			reason:                nil,
+		},
+		{name: "not driver error", err: errOther, isStatementTimeoutSet: true, reason: nil},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := ClassifyTimeoutError(testCase.err, testCase.isStatementTimeoutSet)
+
+			var timeoutErr *TimeoutError
+			if testCase.reason == nil {
+				if err != testCase.err { //nolint:errorlint // error must be returned as is
+					t.Errorf("ClassifyTimeoutError() = %v, want original error", err)
+				}
+
+				return
+			}
+
+			if !errors.As(err, &timeoutErr) || !errors.Is(err, testCase.reason) {
+				t.Fatalf("ClassifyTimeoutError() = %v, want %v", err, testCase.reason)
+			}
+
+			if !errors.Is(err, testCase.err) || timeoutErr.SQLState != pgerrors.SQLState(testCase.err) {
+				t.Errorf("TimeoutError does not keep driver error: %+v", timeoutErr)
+			}
+
+			if ClassifyTimeoutError(err, testCase.isStatementTimeoutSet) != err { //nolint:errorlint // same error
+				t.Error("TimeoutError wrapped twice")
+			}
+		})
+	}
+}
+
+// queryCanceledDatabase returns stub database, which cancels wallets update with 57014 error.
+// Callback onCancel called before error returned, same with cancel request of driver...
+func queryCanceledDatabase(onCancel func()) *stubDatabase {
+	return &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+		if !strings.HasPrefix(statement.query, "UPDATE wallets") {
+			return stubResult{}, false //nolint:exhaustruct // result not scripted
+		}
+
+		onCancel()
+
+		return stubResult{columns: nil, rows: nil, rowsAffected: 0, err: &pq.Error{
+			Code:    "57014",
+			Message: "canceling statement due to user request",
+		}}, true
+	}}
+}
+
+func TestStatementTimeoutClassifiedByTxOptions(t *testing.T) {
+	testCases := []struct {
+		name        string
+		newCtx      func() (context.Context, context.CancelFunc)
+		opts        []TxOption
+		isCancelled bool
+		isTimeout   bool
+	}{
+		{
+			name:      "without statement timeout",
+			newCtx:    func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			opts:      nil,
+			isTimeout: false,
+		},
+		{
+			name:      "with statement timeout",
+			newCtx:    func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			opts:      []TxOption{WithStatementTimeout(time.Second)},
+			isTimeout: true,
+		},
+		{
+			name:      "lock timeout only",
+			newCtx:    func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			opts:      []TxOption{WithLockTimeout(time.Second)},
+			isTimeout: false,
+		},
+		{
+			name: "statement timeout from context deadline",
+			newCtx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), time.Minute)
+			},
+			opts:      []TxOption{WithStatementTimeoutFromContext()},
+			isTimeout: true,
+		},
+		{
+			name:      "statement timeout from context without deadline",
+			newCtx:    func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			opts:      []TxOption{WithStatementTimeoutFromContext()},
+			isTimeout: false,
+		},
+		{
+			name:        "cancelled context with statement timeout",
+			newCtx:      func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+			opts:        []TxOption{WithStatementTimeout(time.Second)},
+			isCancelled: true,
+			isTimeout:   false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx, cancel := testCase.newCtx()
+			defer cancel()
+
+			conn := newStubConnection(t, queryCanceledDatabase(func() {
+				if testCase.isCancelled {
+					cancel()
+				}
+			}))
+
+			err := conn.BeginReadCommittedTxRollbackOnError(ctx, func(txStmtCtx context.Context) error {
+				return ExecExpectRows(txStmtCtx, conn, 1, "UPDATE wallets SET balance = 0")
+			}, testCase.opts...)
+
+			if pgerrors.SQLState(err) != pgerrors.SQLStateQueryCanceled {
+				t.Fatalf("tx error = %v, want SQLSTATE %s", err, pgerrors.SQLStateQueryCanceled)
+			}
+
+			if errors.Is(err, ErrStatementTimeout) != testCase.isTimeout {
+				t.Errorf("tx error = %v, statement timeout = %t, want %t", err,
+					errors.Is(err, ErrStatementTimeout), testCase.isTimeout)
+			}
+
+			if !testCase.isTimeout && !errors.Is(err, pgerrors.ErrQueryCanceled) {
+				t.Errorf("tx error = %v, want %v", err, pgerrors.ErrQueryCanceled)
+			}
+		})
+	}
+}
+
+func TestStatementTimeoutNotInheritedByNextTransaction(t *testing.T) {
+	conn := newStubConnection(t, queryCanceledDatabase(func() {}))
+
+	timeoutCtx, err := conn.BeginContextualTxStatement(context.Background(), WithStatementTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	defer func() {
+		_ = conn.RollbackContextualTxStatement(timeoutCtx)
+	}()
+
+	// transaction started from derived context replaces transaction with statement timeout
+	txCtx, err := conn.BeginContextualTxStatement(timeoutCtx)
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	defer func() {
+		_ = conn.RollbackContextualTxStatement(txCtx)
+	}()
+
+	err = ExecExpectRows(txCtx, conn, 1, "UPDATE wallets SET balance = 0")
+	if !errors.Is(err, pgerrors.ErrQueryCanceled) || errors.Is(err, ErrStatementTimeout) {
+		t.Errorf("update error = %v, want %v", err, pgerrors.ErrQueryCanceled)
+	}
+
+	err = ExecExpectRows(timeoutCtx, conn, 1, "UPDATE wallets SET balance = 0")
+	if !errors.Is(err, ErrStatementTimeout) {
+		t.Errorf("update error = %v, want %v", err, ErrStatementTimeout)
+	}
+}
+
+func TestContextualTxRolledBackOnContextCancel(t *testing.T) {
+	db := &stubDatabase{}
+	conn := newStubConnection(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	txCtx, err := conn.BeginContextualTxStatement(ctx)
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	cancel()
+
+	// database/sql rolls back transaction in background after context cancellation
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if _, isRolledBack := db.find("ROLLBACK"); isRolledBack {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	err = conn.CommitContextualTxStatement(txCtx)
+	if err == nil {
+		t.Fatal("transaction committed after context cancellation")
+	}
+
+	if _, isCommitted := db.find("COMMIT"); isCommitted {
+		t.Error("COMMIT sent after context cancellation")
+	}
+
+	if _, isRolledBack := db.find("ROLLBACK"); !isRolledBack {
+		t.Error("transaction is not rolled back after context cancellation")
+	}
+}
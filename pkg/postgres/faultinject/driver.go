@@ -0,0 +1,310 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package faultinject
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/internal/driverwrap"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	_ driver.Conn               = (*faultConn)(nil)
+	_ driver.ConnBeginTx        = (*faultConn)(nil)
+	_ driver.ConnPrepareContext = (*faultConn)(nil)
+	_ driver.ExecerContext      = (*faultConn)(nil)
+	_ driver.QueryerContext     = (*faultConn)(nil)
+	_ driver.Pinger             = (*faultConn)(nil)
+	_ driver.NamedValueChecker  = (*faultConn)(nil)
+	_ driver.SessionResetter    = (*faultConn)(nil)
+	_ driver.Validator          = (*faultConn)(nil)
+	_ driver.StmtExecContext    = (*faultStmt)(nil)
+	_ driver.StmtQueryContext   = (*faultStmt)(nil)
+)
+
+// Register registers fault-injecting wrapper of lib/pq driver under given name. Driver name can be used by
+// postgres.Connection SetDriverName function. Same with sql.Register function panics if name already registered...
+func Register(name string, injector *Injector) {
+	RegisterDriver(name, &pq.Driver{}, injector)
+}
+
+// RegisterDriver registers fault-injecting wrapper of given driver under given name...
+func RegisterDriver(name string, base driver.Driver, injector *Injector) {
+	sql.Register(name, &faultDriver{base: base, injector: injector})
+	sqlx.BindDriver(name, sqlx.DOLLAR)
+}
+
+type faultDriver struct {
+	base     driver.Driver
+	injector *Injector
+}
+
+func (d *faultDriver) Open(dsn string) (driver.Conn, error) {
+	_, err := d.injector.inject(context.Background(), OpConnect, "")
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := d.base.Open(dsn)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return &faultConn{
+		base:     conn,
+		injector: d.injector,
+		isBroken: atomic.Bool{},
+	}, nil
+}
+
+type faultConn struct {
+	base     driver.Conn
+	injector *Injector
+	// isBroken - connection reset injected, all next operations fails with driver.ErrBadConn
+	isBroken atomic.Bool
+}
+
+func (c *faultConn) inject(ctx context.Context, operation Operation, statement string) error {
+	_, err := c.injectRule(ctx, operation, statement)
+
+	return err
+}
+
+// injectRule returns triggered rule and injected error. Connection marked as broken before operation
+// if rule resets connection, except AfterCommit rules - connection reset after COMMIT...
+func (c *faultConn) injectRule(ctx context.Context, operation Operation, statement string) (*Rule, error) {
+	if c.isBroken.Load() {
+		return nil, driver.ErrBadConn
+	}
+
+	rule, err := c.injector.inject(ctx, operation, statement)
+	if rule != nil && rule.ResetConnection && !rule.AfterCommit {
+		c.isBroken.Store(true)
+	}
+
+	return rule, err
+}
+
+func (c *faultConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *faultConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.isBroken.Load() {
+		return nil, driver.ErrBadConn
+	}
+
+	stmt, err := driverwrap.PrepareContext(ctx, c.base, query)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return &faultStmt{base: stmt, conn: c, query: query}, nil
+}
+
+func (c *faultConn) Close() error {
+	return c.base.Close() //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *faultConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{Isolation: 0, ReadOnly: false})
+}
+
+func (c *faultConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	err := c.inject(ctx, OpBegin, "")
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := driverwrap.BeginTx(ctx, c.base, opts)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return &faultTx{base: tx, conn: c}, nil
+}
+
+func (c *faultConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, isExecer := c.base.(driver.ExecerContext)
+	if !isExecer {
+		return nil, driver.ErrSkip
+	}
+
+	err := c.inject(ctx, OpExec, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return execer.ExecContext(ctx, query, args) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *faultConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, isQueryer := c.base.(driver.QueryerContext)
+	if !isQueryer {
+		return nil, driver.ErrSkip
+	}
+
+	err := c.inject(ctx, OpQuery, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return queryer.QueryContext(ctx, query, args) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *faultConn) Ping(ctx context.Context) error {
+	err := c.inject(ctx, OpPing, "")
+	if err != nil {
+		return err
+	}
+
+	return driverwrap.Ping(ctx, c.base) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *faultConn) CheckNamedValue(value *driver.NamedValue) error {
+	return driverwrap.CheckNamedValue(c.base, value) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *faultConn) ResetSession(ctx context.Context) error {
+	if c.isBroken.Load() {
+		return driver.ErrBadConn
+	}
+
+	return driverwrap.ResetSession(ctx, c.base) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *faultConn) IsValid() bool {
+	if c.isBroken.Load() {
+		return false
+	}
+
+	return driverwrap.IsValid(c.base)
+}
+
+type faultStmt struct {
+	base  driver.Stmt
+	conn  *faultConn
+	query string
+}
+
+func (s *faultStmt) Close() error {
+	return s.base.Close() //nolint:wrapcheck // driver errors returned as is
+}
+
+func (s *faultStmt) NumInput() int {
+	return s.base.NumInput()
+}
+
+func (s *faultStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), driverwrap.NamedValues(args))
+}
+
+func (s *faultStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	err := s.conn.inject(ctx, OpExec, s.query)
+	if err != nil {
+		return nil, err
+	}
+
+	return driverwrap.StmtExecContext(ctx, s.base, args) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (s *faultStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), driverwrap.NamedValues(args))
+}
+
+func (s *faultStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	err := s.conn.inject(ctx, OpQuery, s.query)
+	if err != nil {
+		return nil, err
+	}
+
+	return driverwrap.StmtQueryContext(ctx, s.base, args) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (s *faultStmt) CheckNamedValue(value *driver.NamedValue) error {
+	if _, isChecker := s.base.(driver.NamedValueChecker); isChecker {
+		return driverwrap.CheckNamedValue(s.base, value) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return s.conn.CheckNamedValue(value)
+}
+
+type faultTx struct {
+	base driver.Tx
+	conn *faultConn
+}
+
+// Commit injects fault before COMMIT. If fault injected without connection reset transaction rolled back,
+// same with failed COMMIT on server side. Fault of AfterCommit rule injected after successful COMMIT...
+func (t *faultTx) Commit() error {
+	rule, err := t.conn.injectRule(context.Background(), OpCommit, "")
+	if rule != nil && rule.AfterCommit {
+		commitErr := t.base.Commit()
+		if commitErr != nil {
+			return commitErr //nolint:wrapcheck // driver errors returned as is
+		}
+
+		t.conn.isBroken.Store(true)
+
+		return err
+	}
+
+	if err != nil {
+		if !t.conn.isBroken.Load() {
+			_ = t.base.Rollback()
+		}
+
+		return err
+	}
+
+	return t.base.Commit() //nolint:wrapcheck // driver errors returned as is
+}
+
+func (t *faultTx) Rollback() error {
+	err := t.conn.inject(context.Background(), OpRollback, "")
+	if err != nil {
+		if !t.conn.isBroken.Load() {
+			_ = t.base.Rollback()
+		}
+
+		return err
+	}
+
+	return t.base.Rollback() //nolint:wrapcheck // driver errors returned as is
+}
@@ -0,0 +1,265 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/pgerrors"
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/pgfake"
+)
+
+const updateBalanceQuery = "UPDATE wallets SET balance = balance - $1 WHERE id = $2"
+
+// driverSequence is used for generation of unique names of registered drivers...
+var driverSequence atomic.Uint64
+
+// newFaultServer starts fake server and registers fault-injecting lib/pq driver for it...
+func newFaultServer(t *testing.T) (*pgfake.Server, *Injector, *postgres.PostgresConfig) {
+	t.Helper()
+
+	srv := pgfake.NewServer(pgfake.Config{User: "wallet", Password: "secret", Database: "wallets", Auth: pgfake.AuthMD5})
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("unable to start fake server: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	srv.Script(updateBalanceQuery, pgfake.Response{Tag: "UPDATE 1"}) //nolint:exhaustruct // only command tag
+
+	injector := NewInjector(1)
+	driverName := fmt.Sprintf("postgres-faults-%d", driverSequence.Add(1))
+	Register(driverName, injector)
+
+	cfg := srv.PostgresConfig()
+	cfg.DBDriverName = driverName
+
+	return srv, injector, cfg
+}
+
+func newConnection(cfg *postgres.PostgresConfig) *postgres.Connection {
+	return postgres.NewConnection(context.Background(), nil, nil, cfg,
+		postgres.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+}
+
+func connect(t *testing.T, cfg *postgres.PostgresConfig) *postgres.Connection {
+	t.Helper()
+
+	conn, err := newConnection(cfg).Connect()
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn
+}
+
+func countQueries(srv *pgfake.Server, query string) int {
+	count := 0
+
+	for _, received := range srv.Queries() {
+		if received == query {
+			count++
+		}
+	}
+
+	return count
+}
+
+func updateBalance(conn *postgres.Connection) func(txStmtCtx context.Context) error {
+	return func(txStmtCtx context.Context) error {
+		_, err := conn.Q(txStmtCtx).ExecContext(txStmtCtx, updateBalanceQuery, "10", 1)
+
+		return err
+	}
+}
+
+func TestConnectRetriesInjectedFaults(t *testing.T) {
+	testCases := []struct {
+		name        string
+		operation   Operation
+		retryCount  uint8
+		isConnected bool
+	}{
+		{name: "connect fault retried", operation: OpConnect, retryCount: 2, isConnected: true},
+		{name: "ping fault retried", operation: OpPing, retryCount: 2, isConnected: true},
+		{name: "connect fault without retries", operation: OpConnect, retryCount: 1, isConnected: false},
+		{name: "ping fault without retries", operation: OpPing, retryCount: 1, isConnected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, injector, cfg := newFaultServer(t)
+			injector.AddRule(Rule{Operation: testCase.operation, OnCall: 1})
+
+			cfg.DBConnectRetryCount = testCase.retryCount
+
+			conn, err := newConnection(cfg).Connect()
+			if !testCase.isConnected {
+				if !errors.Is(err, ErrInjectedFault) {
+					t.Errorf("Connect error = %v, want %v", err, ErrInjectedFault)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Connect returned error: %v", err)
+			}
+
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			if injector.Injected() != 1 || injector.Calls(testCase.operation) < 2 {
+				t.Errorf("injected %d faults in %d calls, want 1 fault and retried call",
+					injector.Injected(), injector.Calls(testCase.operation))
+			}
+		})
+	}
+}
+
+func TestTxHelperInjectedSQLState(t *testing.T) {
+	srv, injector, cfg := newFaultServer(t)
+	conn := connect(t, cfg)
+
+	injector.AddRule(Rule{Operation: OpExec, Statement: "UPDATE wallets", SQLState: pgerrors.SQLStateSerializationFailure})
+
+	err := conn.BeginReadCommittedTxRollbackOnError(context.Background(), updateBalance(conn))
+	if !errors.Is(err, pgerrors.ErrSerializationFailure) || !pgerrors.IsRetryable(err) {
+		t.Errorf("tx helper error = %v, want retryable %v", err, pgerrors.ErrSerializationFailure)
+	}
+
+	if countQueries(srv, "ROLLBACK") != 1 || countQueries(srv, "COMMIT") != 0 {
+		t.Errorf("transaction is not rolled back, server received %v", srv.Queries())
+	}
+
+	if countQueries(srv, updateBalanceQuery) != 0 {
+		t.Error("statement with injected fault reached server")
+	}
+}
+
+func TestTxHelperCommitConnectionReset(t *testing.T) {
+	srv, injector, cfg := newFaultServer(t)
+	conn := connect(t, cfg)
+
+	injector.AddRule(Rule{Operation: OpCommit, ResetConnection: true, OnCall: 1})
+
+	err := conn.BeginReadCommittedTxRollbackOnError(context.Background(), updateBalance(conn))
+	if !errors.Is(err, postgres.ErrCommitOutcomeUnknown) {
+		t.Fatalf("tx helper error = %v, want %v", err, postgres.ErrCommitOutcomeUnknown)
+	}
+
+	// connection reset before COMMIT sent
+	if countQueries(srv, "COMMIT") != 0 {
+		t.Errorf("COMMIT reached server, server received %v", srv.Queries())
+	}
+
+	// broken connection removed from pool, next transaction uses new connection
+	err = conn.BeginReadCommittedTxRollbackOnError(context.Background(), updateBalance(conn))
+	if err != nil {
+		t.Fatalf("tx helper returned error after connection reset: %v", err)
+	}
+
+	if countQueries(srv, "COMMIT") != 1 {
+		t.Errorf("second transaction is not committed, server received %v", srv.Queries())
+	}
+}
+
+func TestCommitContextualTxStatementAfterCommit(t *testing.T) {
+	srv, injector, cfg := newFaultServer(t)
+	conn := connect(t, cfg)
+	ctx := context.Background()
+
+	injector.AddRule(Rule{AfterCommit: true, OnCall: 1})
+
+	txCtx, err := conn.BeginContextualTxStatement(ctx)
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	err = updateBalance(conn)(txCtx)
+	if err != nil {
+		t.Fatalf("update returned error: %v", err)
+	}
+
+	err = conn.CommitContextualTxStatement(txCtx)
+	if !errors.Is(err, postgres.ErrCommitOutcomeUnknown) {
+		t.Fatalf("CommitContextualTxStatement error = %v, want %v", err, postgres.ErrCommitOutcomeUnknown)
+	}
+
+	// transaction committed by server, but client received connection error
+	if countQueries(srv, "COMMIT") != 1 {
+		t.Errorf("COMMIT not received by server, server received %v", srv.Queries())
+	}
+
+	if !conn.IsHealed(ctx) {
+		t.Error("connection is not healed after connection reset")
+	}
+}
+
+func TestRollbackContextualTxStatementInjectedFault(t *testing.T) {
+	srv, injector, cfg := newFaultServer(t)
+	conn := connect(t, cfg)
+
+	injector.AddRule(Rule{Operation: OpRollback})
+
+	txCtx, err := conn.BeginContextualTxStatement(context.Background())
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	err = conn.RollbackContextualTxStatement(txCtx)
+	if !errors.Is(err, ErrInjectedFault) {
+		t.Errorf("RollbackContextualTxStatement error = %v, want %v", err, ErrInjectedFault)
+	}
+
+	// transaction rolled back on server, because connection was not reset
+	if countQueries(srv, "ROLLBACK") != 1 {
+		t.Errorf("transaction is not rolled back on server, server received %v", srv.Queries())
+	}
+}
@@ -0,0 +1,256 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrInjectedFault = errors.New("injected fault")
+
+// Operation is the kind of driver operation...
+type Operation string
+
+const (
+	OpConnect  Operation = "connect"
+	OpPing     Operation = "ping"
+	OpBegin    Operation = "begin"
+	OpExec     Operation = "exec"
+	OpQuery    Operation = "query"
+	OpCommit   Operation = "commit"
+	OpRollback Operation = "rollback"
+)
+
+// Rule describes injected fault. Rule matches operation if all set match conditions are satisfied.
+// If neither Err, SQLState, ResetConnection nor AfterCommit set and Latency is zero ErrInjectedFault injected...
+type Rule struct {
+	// Operation is the kind of matched operation, empty value matches all operations
+	Operation Operation
+	// Statement is the substring of matched statement text
+	Statement string
+	// StatementPattern is the regular expression of matched statement text
+	StatementPattern *regexp.Regexp
+
+	// OnCall - fault injected only on N-th matched call, started from 1. If 0 - fault injected on every matched call
+	OnCall int
+	// Probability of fault injection, in range (0, 1]. If 0 - fault always injected
+	Probability float64
+
+	// Err is the injected error
+	Err error
+	// SQLState is the code of injected *pq.Error, e.g. 40001
+	SQLState string
+	// Latency is the delay before operation, injected alone or together with error
+	Latency time.Duration
+	// ResetConnection - connection marked as broken, operation fails with connection error
+	// and connection removed from pool
+	ResetConnection bool
+	// AfterCommit - COMMIT executed by server, then connection reset injected. Transaction committed,
+	// but client receives connection error, same with connection lost while COMMIT was in flight.
+	// Rule with AfterCommit matches only OpCommit operation
+	AfterCommit bool
+}
+
+func (r *Rule) matches(operation Operation, statement string) bool {
+	if r.Operation != "" && r.Operation != operation {
+		return false
+	}
+
+	if r.AfterCommit && operation != OpCommit {
+		return false
+	}
+
+	if r.Statement != "" && !strings.Contains(statement, r.Statement) {
+		return false
+	}
+
+	if r.StatementPattern != nil && !r.StatementPattern.MatchString(statement) {
+		return false
+	}
+
+	return true
+}
+
+// fault returns injected error of rule...
+func (r *Rule) fault() error {
+	switch {
+	case r.ResetConnection, r.AfterCommit:
+		return fmt.Errorf("%w: connection reset: %w", ErrInjectedFault, io.ErrUnexpectedEOF)
+	case r.Err != nil:
+		return r.Err
+	case r.SQLState != "":
+		return &pq.Error{ //nolint:exhaustruct // only required fields of server error
+			Severity: "ERROR",
+			Code:     pq.ErrorCode(r.SQLState),
+			Message:  ErrInjectedFault.Error(),
+		}
+	case r.Latency > 0:
+		return nil
+	default:
+		return ErrInjectedFault
+	}
+}
+
+type ruleState struct {
+	rule    Rule
+	matched int
+}
+
+// Injector decides which faults injected into driver operations. Rules evaluated in order of adding,
+// first triggered rule injects fault...
+type Injector struct {
+	mu sync.Mutex
+
+	rules  []*ruleState
+	random *rand.Rand
+
+	calls    map[Operation]int
+	injected int
+}
+
+// AddRule adds fault injection rules...
+func (i *Injector) AddRule(rules ...Rule) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, rule := range rules {
+		i.rules = append(i.rules, &ruleState{rule: rule, matched: 0})
+	}
+}
+
+// Reset removes all rules and counters...
+func (i *Injector) Reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.rules = nil
+	i.calls = make(map[Operation]int)
+	i.injected = 0
+}
+
+// Calls returns count of driver operations of given kind...
+func (i *Injector) Calls(operation Operation) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.calls[operation]
+}
+
+// Injected returns count of injected faults...
+func (i *Injector) Injected() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.injected
+}
+
+// trigger returns triggered rule for operation, nil if fault not injected...
+func (i *Injector) trigger(operation Operation, statement string) *Rule {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.calls[operation]++
+
+	var triggered *Rule
+
+	for _, state := range i.rules {
+		if !state.rule.matches(operation, statement) {
+			continue
+		}
+
+		state.matched++
+
+		if triggered != nil {
+			continue
+		}
+
+		if state.rule.OnCall != 0 && state.rule.OnCall != state.matched {
+			continue
+		}
+
+		if state.rule.Probability != 0 && i.random.Float64() >= state.rule.Probability {
+			continue
+		}
+
+		triggered = &state.rule
+	}
+
+	if triggered != nil {
+		i.injected++
+	}
+
+	return triggered
+}
+
+// inject applies triggered rule, returns triggered rule and injected error.
+// Rule is nil if fault not injected or latency interrupted by context...
+func (i *Injector) inject(ctx context.Context, operation Operation, statement string) (*Rule, error) {
+	rule := i.trigger(operation, statement)
+	if rule == nil {
+		return nil, nil
+	}
+
+	if rule.Latency > 0 {
+		timer := time.NewTimer(rule.Latency)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	return rule, rule.fault()
+}
+
+// NewInjector returns injector without rules. Seed used for probability decisions, so runs are reproducible...
+func NewInjector(seed int64) *Injector {
+	return &Injector{
+		mu:       sync.Mutex{},
+		rules:    nil,
+		random:   rand.New(rand.NewSource(seed)), //nolint:gosec // deterministic fault injection, not security
+		calls:    make(map[Operation]int),
+		injected: 0,
+	}
+}
@@ -0,0 +1,234 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestRuleMatches(t *testing.T) {
+	testCases := []struct {
+		name      string
+		rule      Rule
+		operation Operation
+		statement string
+		isMatched bool
+	}{
+		{name: "any operation", rule: Rule{}, operation: OpQuery, statement: "SELECT 1", isMatched: true},
+		{name: "same operation", rule: Rule{Operation: OpExec}, operation: OpExec, statement: "", isMatched: true},
+		{name: "other operation", rule: Rule{Operation: OpExec}, operation: OpQuery, statement: "", isMatched: false},
+		{
+			name: "statement substring", rule: Rule{Statement: "UPDATE wallets"},
+			operation: OpExec, statement: "UPDATE wallets SET balance = $1", isMatched: true,
+		},
+		{
+			name: "other statement", rule: Rule{Statement: "UPDATE wallets"},
+			operation: OpExec, statement: "UPDATE addresses SET used = true", isMatched: false,
+		},
+		{
+			name: "statement pattern", rule: Rule{StatementPattern: regexp.MustCompile(`^INSERT INTO \w+_audit`)},
+			operation: OpExec, statement: "INSERT INTO wallets_audit VALUES ($1)", isMatched: true,
+		},
+		{
+			name: "statement pattern mismatch", rule: Rule{StatementPattern: regexp.MustCompile(`^INSERT`)},
+			operation: OpExec, statement: "UPDATE wallets", isMatched: false,
+		},
+		{name: "after commit on commit", rule: Rule{AfterCommit: true}, operation: OpCommit, isMatched: true},
+		{name: "after commit on exec", rule: Rule{AfterCommit: true}, operation: OpExec, isMatched: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			isMatched := testCase.rule.matches(testCase.operation, testCase.statement)
+			if isMatched != testCase.isMatched {
+				t.Errorf("matches(%s, %q) = %t, want %t", testCase.operation, testCase.statement,
+					isMatched, testCase.isMatched)
+			}
+		})
+	}
+}
+
+func TestRuleFault(t *testing.T) {
+	errCustom := errors.New("custom fault")
+
+	testCases := []struct {
+		name     string
+		rule     Rule
+		check    func(err error) bool
+		isNilErr bool
+	}{
+		{name: "default fault", rule: Rule{}, check: func(err error) bool {
+			return errors.Is(err, ErrInjectedFault)
+		}},
+		{name: "custom error", rule: Rule{Err: errCustom}, check: func(err error) bool {
+			return errors.Is(err, errCustom)
+		}},
+		{name: "sqlstate", rule: Rule{SQLState: "40001"}, check: func(err error) bool {
+			var pqErr *pq.Error
+
+			return errors.As(err, &pqErr) && pqErr.Code == "40001"
+		}},
+		{name: "connection reset", rule: Rule{ResetConnection: true, SQLState: "40001"}, check: func(err error) bool {
+			return errors.Is(err, ErrInjectedFault) && errors.Is(err, io.ErrUnexpectedEOF)
+		}},
+		{name: "after commit", rule: Rule{AfterCommit: true}, check: func(err error) bool {
+			return errors.Is(err, io.ErrUnexpectedEOF)
+		}},
+		{name: "latency only", rule: Rule{Latency: time.Millisecond}, isNilErr: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := testCase.rule.fault()
+			if testCase.isNilErr {
+				if err != nil {
+					t.Errorf("fault() = %v, want nil", err)
+				}
+
+				return
+			}
+
+			if !testCase.check(err) {
+				t.Errorf("unexpected fault() error: %v", err)
+			}
+		})
+	}
+}
+
+// triggerSequence returns numbers of calls, started from 1, on which fault injected...
+func triggerSequence(injector *Injector, calls int) []int {
+	var triggered []int
+
+	for call := 1; call <= calls; call++ {
+		if injector.trigger(OpExec, "UPDATE wallets") != nil {
+			triggered = append(triggered, call)
+		}
+	}
+
+	return triggered
+}
+
+func TestInjectorOnCall(t *testing.T) {
+	injector := NewInjector(1)
+	injector.AddRule(Rule{Operation: OpExec, OnCall: 3})
+
+	got := triggerSequence(injector, 6)
+	if !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("fault injected on calls %v, want [3]", got)
+	}
+
+	if injector.Calls(OpExec) != 6 || injector.Injected() != 1 {
+		t.Errorf("calls = %d, injected = %d, want 6 and 1", injector.Calls(OpExec), injector.Injected())
+	}
+}
+
+func TestInjectorFirstTriggeredRuleWins(t *testing.T) {
+	injector := NewInjector(1)
+	injector.AddRule(Rule{Operation: OpExec, OnCall: 1, SQLState: "40001"}, Rule{Operation: OpExec, OnCall: 2})
+
+	first := injector.trigger(OpExec, "")
+	second := injector.trigger(OpExec, "")
+
+	// second rule counts first call too, so it triggered on second call
+	if first == nil || first.SQLState != "40001" {
+		t.Errorf("first call triggered rule %+v, want rule with SQLSTATE 40001", first)
+	}
+
+	if second == nil || second.OnCall != 2 {
+		t.Errorf("second call triggered rule %+v, want rule on second call", second)
+	}
+}
+
+func TestInjectorProbabilityDeterministicPerSeed(t *testing.T) {
+	newInjector := func(seed int64) *Injector {
+		injector := NewInjector(seed)
+		injector.AddRule(Rule{Operation: OpExec, Probability: 0.3})
+
+		return injector
+	}
+
+	first := triggerSequence(newInjector(42), 200)
+	second := triggerSequence(newInjector(42), 200)
+
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("injections of same seed differ:\n%v\n%v", first, second)
+	}
+
+	// 60 faults expected, wide bounds keep test stable on math/rand changes
+	if len(first) < 30 || len(first) > 90 {
+		t.Errorf("injected %d faults of 200 calls with probability 0.3", len(first))
+	}
+
+	other := triggerSequence(newInjector(7), 200)
+	if reflect.DeepEqual(first, other) {
+		t.Error("injections of different seeds are same")
+	}
+}
+
+func TestInjectorReset(t *testing.T) {
+	injector := NewInjector(1)
+	injector.AddRule(Rule{Operation: OpExec})
+
+	_ = triggerSequence(injector, 2)
+
+	injector.Reset()
+
+	if got := triggerSequence(injector, 2); len(got) != 0 {
+		t.Errorf("fault injected on calls %v after reset", got)
+	}
+
+	if injector.Calls(OpExec) != 2 || injector.Injected() != 0 {
+		t.Errorf("calls = %d, injected = %d after reset, want 2 and 0", injector.Calls(OpExec), injector.Injected())
+	}
+}
+
+func TestInjectorLatencyInterruptedByContext(t *testing.T) {
+	injector := NewInjector(1)
+	injector.AddRule(Rule{Operation: OpQuery, Latency: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	rule, err := injector.inject(ctx, OpQuery, "SELECT 1")
+	if rule != nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("inject() = %v, %v, want nil rule and %v", rule, err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,202 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"log/slog"
+	"time"
+)
+
+// Operation is the kind of instrumented database operation...
+type Operation string
+
+const (
+	OperationExec     Operation = "exec"
+	OperationQuery    Operation = "query"
+	OperationBegin    Operation = "begin"
+	OperationCommit   Operation = "commit"
+	OperationRollback Operation = "rollback"
+)
+
+// QueryEvent describes instrumented database operation. Duration and Err filled before AfterQuery hook call.
+// For OperationQuery event finished when driver returned rows, reading of rows not included...
+type QueryEvent struct {
+	// ConnectionName is the name of connection, set by WithName option
+	ConnectionName string
+	// Database is the name of connection database
+	Database  string
+	Operation Operation
+	// Statement is the query text, empty for transaction operations
+	Statement string
+	Args      []any
+
+	StartedAt time.Time
+	Duration  time.Duration
+	Err       error
+}
+
+// Hooks are called around every database operation of connection pool, including transaction statements
+// and operations of sub-packages. BeforeQuery hooks called in registration order, AfterQuery - in reverse order.
+// Nil hook functions are skipped...
+type Hooks struct {
+	// BeforeQuery called before operation, returned context passed to driver and to AfterQuery hook
+	BeforeQuery func(ctx context.Context, event *QueryEvent) context.Context
+	// AfterQuery called after operation
+	AfterQuery func(ctx context.Context, event *QueryEvent)
+}
+
+// MetricsCollector collects metrics of database operations, e.g. by prometheus histogram...
+type MetricsCollector interface {
+	ObserveQuery(connectionName string, operation Operation, duration time.Duration, err error)
+}
+
+// Tracer starts tracing spans of database operations. Can be implemented by OpenTelemetry tracer adapter...
+type Tracer interface {
+	StartSpan(ctx context.Context, spanName string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Span is the tracing span of database operation...
+type Span interface {
+	// End finishes span, err is the error of operation or nil
+	End(err error)
+}
+
+const (
+	TracingSpanPrefix       = "postgres."
+	TracingDBSystemTag      = "db.system"
+	TracingDBNameTag        = "db.name"
+	TracingDBOperationTag   = "db.operation"
+	TracingDBStatementTag   = "db.statement"
+	TracingConnectionTag    = "db.connection_name"
+	TracingDBSystemPostgres = "postgresql"
+)
+
+type tracingSpanCtxKey struct{}
+
+func tracerHooks(tracer Tracer) Hooks {
+	return Hooks{
+		BeforeQuery: func(ctx context.Context, event *QueryEvent) context.Context {
+			spanCtx, span := tracer.StartSpan(ctx, TracingSpanPrefix+string(event.Operation),
+				slog.String(TracingDBSystemTag, TracingDBSystemPostgres),
+				slog.String(TracingDBNameTag, event.Database),
+				slog.String(TracingDBOperationTag, string(event.Operation)),
+				slog.String(TracingDBStatementTag, event.Statement),
+				slog.String(TracingConnectionTag, event.ConnectionName))
+
+			return context.WithValue(spanCtx, tracingSpanCtxKey{}, span)
+		},
+		AfterQuery: func(ctx context.Context, event *QueryEvent) {
+			span, isSpan := ctx.Value(tracingSpanCtxKey{}).(Span)
+			if isSpan {
+				span.End(event.Err)
+			}
+		},
+	}
+}
+
+func metricsHooks(collector MetricsCollector) Hooks {
+	return Hooks{
+		BeforeQuery: nil,
+		AfterQuery: func(_ context.Context, event *QueryEvent) {
+			collector.ObserveQuery(event.ConnectionName, event.Operation, event.Duration, event.Err)
+		},
+	}
+}
+
+// instrumentation calls hooks around driver operations...
+type instrumentation struct {
+	connectionName string
+	database       string
+	hooks          []Hooks
+}
+
+func (i *instrumentation) withDatabase(database string) *instrumentation {
+	if i == nil {
+		return nil
+	}
+
+	return &instrumentation{
+		connectionName: i.connectionName,
+		database:       database,
+		hooks:          i.hooks,
+	}
+}
+
+func (i *instrumentation) observe(ctx context.Context,
+	operation Operation,
+	statement string,
+	args []driver.NamedValue,
+	call func(ctx context.Context) error,
+) error {
+	var eventArgs []any
+
+	if len(args) != 0 {
+		eventArgs = make([]any, len(args))
+
+		for idx, arg := range args {
+			eventArgs[idx] = arg.Value
+		}
+	}
+
+	event := &QueryEvent{
+		ConnectionName: i.connectionName,
+		Database:       i.database,
+		Operation:      operation,
+		Statement:      statement,
+		Args:           eventArgs,
+		StartedAt:      time.Now(),
+		Duration:       0,
+		Err:            nil,
+	}
+
+	for _, hooks := range i.hooks {
+		if hooks.BeforeQuery != nil {
+			ctx = hooks.BeforeQuery(ctx, event)
+		}
+	}
+
+	err := call(ctx)
+
+	event.Duration = time.Since(event.StartedAt)
+	event.Err = err
+
+	for idx := len(i.hooks) - 1; idx >= 0; idx-- {
+		if i.hooks[idx].AfterQuery != nil {
+			i.hooks[idx].AfterQuery(ctx, event)
+		}
+	}
+
+	return err
+}
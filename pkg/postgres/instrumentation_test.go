@@ -0,0 +1,283 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log/slog"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type hookCtxKey struct{}
+
+// hookRecorder records calls of hooks, metrics collector and tracer...
+type hookRecorder struct {
+	mu sync.Mutex
+
+	calls  []string
+	events []QueryEvent
+}
+
+func (r *hookRecorder) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, call)
+}
+
+func (r *hookRecorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.calls...)
+}
+
+func (r *hookRecorder) hooks(name string) Hooks {
+	return Hooks{
+		BeforeQuery: func(ctx context.Context, event *QueryEvent) context.Context {
+			r.record(name + " before " + string(event.Operation))
+
+			return context.WithValue(ctx, hookCtxKey{}, name)
+		},
+		AfterQuery: func(ctx context.Context, event *QueryEvent) {
+			r.record(name + " after " + string(event.Operation) + " ctx " + ctx.Value(hookCtxKey{}).(string))
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			r.events = append(r.events, *event)
+		},
+	}
+}
+
+func (r *hookRecorder) ObserveQuery(connectionName string, operation Operation, _ time.Duration, err error) {
+	r.record("metrics " + connectionName + " " + string(operation) + " " + errString(err))
+}
+
+func (r *hookRecorder) StartSpan(ctx context.Context, spanName string, attrs ...slog.Attr) (context.Context, Span) {
+	statement := ""
+
+	for _, attr := range attrs {
+		if attr.Key == TracingDBStatementTag {
+			statement = attr.Value.String()
+		}
+	}
+
+	r.record("span start " + spanName + " " + statement)
+
+	return ctx, &recordedSpan{recorder: r, name: spanName}
+}
+
+type recordedSpan struct {
+	recorder *hookRecorder
+	name     string
+}
+
+func (s *recordedSpan) End(err error) {
+	s.recorder.record("span end " + s.name + " " + errString(err))
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "ok"
+	}
+
+	return err.Error()
+}
+
+func TestInstrumentationHooksOrder(t *testing.T) {
+	recorder := &hookRecorder{}
+	conn := newStubConnection(t, &stubDatabase{},
+		WithName("wallets-db"),
+		WithHooks(recorder.hooks("first"), Hooks{BeforeQuery: nil, AfterQuery: nil}),
+		WithMetrics(recorder),
+		WithHooks(recorder.hooks("second")),
+		WithTracer(recorder))
+
+	// only operations of test recorded
+	recorder.calls, recorder.events = nil, nil
+
+	err := ExecExpectRows(context.Background(), conn, 1, "UPDATE wallets SET balance = $1", "0")
+	if err != nil {
+		t.Fatalf("query returned error: %v", err)
+	}
+
+	want := []string{
+		"span start postgres.exec UPDATE wallets SET balance = $1",
+		"first before exec",
+		"second before exec",
+		"second after exec ctx second",
+		"first after exec ctx second",
+		"metrics wallets-db exec ok",
+		"span end postgres.exec ok",
+	}
+
+	if got := recorder.recorded(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls = %q, want %q", got, want)
+	}
+
+	event := recorder.events[0]
+	if event.ConnectionName != "wallets-db" || event.Database != "wallets" ||
+		event.Statement != "UPDATE wallets SET balance = $1" || !reflect.DeepEqual(event.Args, []any{"0"}) {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
+
+func TestInstrumentationTransactionOperations(t *testing.T) {
+	errInsert := errors.New("insert failed")
+
+	db := &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+		if statement.query != "INSERT INTO audit" {
+			return stubResult{}, false //nolint:exhaustruct // result not scripted
+		}
+
+		return stubResult{columns: nil, rows: nil, rowsAffected: 0, err: errInsert}, true
+	}}
+
+	recorder := &hookRecorder{}
+	conn := newStubConnection(t, db, WithMetrics(recorder))
+	recorder.calls = nil
+
+	err := conn.BeginTxWithRollbackOnError(context.Background(), func(txStmtCtx context.Context) error {
+		_, execErr := conn.Q(txStmtCtx).ExecContext(txStmtCtx, "INSERT INTO audit")
+
+		return execErr
+	})
+	if !errors.Is(err, errInsert) {
+		t.Fatalf("tx error = %v, want %v", err, errInsert)
+	}
+
+	want := []string{
+		"metrics " + DefaultConnectionName + " begin ok",
+		"metrics " + DefaultConnectionName + " exec insert failed",
+		"metrics " + DefaultConnectionName + " rollback ok",
+	}
+
+	if got := recorder.recorded(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestInstrumentationDisabledWithoutOptions(t *testing.T) {
+	conn := newStubConnection(t, &stubDatabase{})
+
+	if conn.instr != nil {
+		t.Error("instrumentation set without hooks, metrics and tracer")
+	}
+
+	if _, isInstrumented := conn.Dbx.Driver().(*stubDatabase); !isInstrumented {
+		t.Errorf("driver = %T, want registered driver", conn.Dbx.Driver())
+	}
+}
+
+// contextDatabase is the stub driver, which implements driver.DriverContext and records connect contexts...
+type contextDatabase struct {
+	*stubDatabase
+
+	mu          sync.Mutex
+	dsn         string
+	connectVals []any
+}
+
+func (d *contextDatabase) OpenConnector(dsn string) (driver.Connector, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.dsn = dsn
+
+	return &contextConnector{db: d}, nil
+}
+
+type contextConnector struct {
+	db *contextDatabase
+}
+
+func (c *contextConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.db.mu.Lock()
+	c.db.connectVals = append(c.db.connectVals, ctx.Value(hookCtxKey{}))
+	c.db.mu.Unlock()
+
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.db.Open(c.db.dsn)
+}
+
+func (c *contextConnector) Driver() driver.Driver {
+	return c.db
+}
+
+func TestInstrumentedConnectorUsesDriverConnector(t *testing.T) {
+	db := &contextDatabase{stubDatabase: &stubDatabase{}} //nolint:exhaustruct // recorded fields
+	conn := newDriverConnection(t, func(driverName string) {
+		sql.Register(driverName, db)
+	}, WithHooks(Hooks{BeforeQuery: nil, AfterQuery: nil}))
+
+	if db.dsn != formatPostgresDSN(conn.params) {
+		t.Errorf("connector opened with DSN %q, want %q", db.dsn, formatPostgresDSN(conn.params))
+	}
+
+	// every query opens new connection with query context
+	conn.Dbx.SetMaxIdleConns(0)
+
+	ctx := context.WithValue(context.Background(), hookCtxKey{}, "withdrawal")
+
+	err := ExecExpectRows(ctx, conn, 1, "UPDATE wallets SET balance = 0")
+	if err != nil {
+		t.Fatalf("query returned error: %v", err)
+	}
+
+	db.mu.Lock()
+	lastVal := db.connectVals[len(db.connectVals)-1]
+	db.mu.Unlock()
+
+	if lastVal != "withdrawal" {
+		t.Errorf("connect context value = %v, want query context", lastVal)
+	}
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = conn.Dbx.Conn(cancelledCtx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("connect with cancelled context error = %v, want %v", err, context.Canceled)
+	}
+}
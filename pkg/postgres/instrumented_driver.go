@@ -0,0 +1,281 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/internal/driverwrap"
+)
+
+var (
+	_ driver.Connector          = (*instrumentedConnector)(nil)
+	_ driver.Connector          = (*dsnConnector)(nil)
+	_ driver.Conn               = (*instrumentedConn)(nil)
+	_ driver.ConnBeginTx        = (*instrumentedConn)(nil)
+	_ driver.ConnPrepareContext = (*instrumentedConn)(nil)
+	_ driver.ExecerContext      = (*instrumentedConn)(nil)
+	_ driver.QueryerContext     = (*instrumentedConn)(nil)
+	_ driver.Pinger             = (*instrumentedConn)(nil)
+	_ driver.NamedValueChecker  = (*instrumentedConn)(nil)
+	_ driver.SessionResetter    = (*instrumentedConn)(nil)
+	_ driver.Validator          = (*instrumentedConn)(nil)
+	_ driver.StmtExecContext    = (*instrumentedStmt)(nil)
+	_ driver.StmtQueryContext   = (*instrumentedStmt)(nil)
+)
+
+// openConnector returns connector of base driver. Driver, which not implements driver.DriverContext,
+// opened by DSN on every connect, same with database/sql...
+func openConnector(base driver.Driver, dsn string) (driver.Connector, error) {
+	driverCtx, isDriverCtx := base.(driver.DriverContext)
+	if isDriverCtx {
+		return driverCtx.OpenConnector(dsn) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return &dsnConnector{base: base, dsn: dsn}, nil
+}
+
+// dsnConnector opens connections of driver without connector support...
+type dsnConnector struct {
+	base driver.Driver
+	dsn  string
+}
+
+func (c *dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.base.Open(c.dsn) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.base
+}
+
+// instrumentedConnector opens connections of base connector wrapped by instrumentation hooks.
+// Context of connect passed to base connector, so dial can be cancelled...
+type instrumentedConnector struct {
+	base  driver.Connector
+	instr *instrumentation
+}
+
+func (c *instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.base.Connect(ctx)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return &instrumentedConn{base: conn, instr: c.instr}, nil
+}
+
+func (c *instrumentedConnector) Driver() driver.Driver {
+	return c.base.Driver()
+}
+
+type instrumentedConn struct {
+	base  driver.Conn
+	instr *instrumentation
+}
+
+func (c *instrumentedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := driverwrap.PrepareContext(ctx, c.base, query)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return &instrumentedStmt{base: stmt, conn: c, query: query}, nil
+}
+
+func (c *instrumentedConn) Close() error {
+	return c.base.Close() //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *instrumentedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{Isolation: 0, ReadOnly: false})
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+
+	err := c.instr.observe(ctx, OperationBegin, "", nil, func(ctx context.Context) error {
+		var beginErr error
+
+		tx, beginErr = driverwrap.BeginTx(ctx, c.base, opts)
+
+		return beginErr //nolint:wrapcheck // driver errors returned as is
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &instrumentedTx{base: tx, conn: c, ctx: ctx}, nil
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context,
+	query string,
+	args []driver.NamedValue,
+) (driver.Result, error) {
+	execer, isExecer := c.base.(driver.ExecerContext)
+	if !isExecer {
+		return nil, driver.ErrSkip
+	}
+
+	var result driver.Result
+
+	err := c.instr.observe(ctx, OperationExec, query, args, func(ctx context.Context) error {
+		var execErr error
+
+		result, execErr = execer.ExecContext(ctx, query, args)
+
+		return execErr //nolint:wrapcheck // driver errors returned as is
+	})
+
+	return result, err
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context,
+	query string,
+	args []driver.NamedValue,
+) (driver.Rows, error) {
+	queryer, isQueryer := c.base.(driver.QueryerContext)
+	if !isQueryer {
+		return nil, driver.ErrSkip
+	}
+
+	var rows driver.Rows
+
+	err := c.instr.observe(ctx, OperationQuery, query, args, func(ctx context.Context) error {
+		var queryErr error
+
+		rows, queryErr = queryer.QueryContext(ctx, query, args)
+
+		return queryErr //nolint:wrapcheck // driver errors returned as is
+	})
+
+	return rows, err
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	return driverwrap.Ping(ctx, c.base) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *instrumentedConn) CheckNamedValue(value *driver.NamedValue) error {
+	return driverwrap.CheckNamedValue(c.base, value) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	return driverwrap.ResetSession(ctx, c.base) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	return driverwrap.IsValid(c.base)
+}
+
+type instrumentedStmt struct {
+	base  driver.Stmt
+	conn  *instrumentedConn
+	query string
+}
+
+func (s *instrumentedStmt) Close() error {
+	return s.base.Close() //nolint:wrapcheck // driver errors returned as is
+}
+
+func (s *instrumentedStmt) NumInput() int {
+	return s.base.NumInput()
+}
+
+func (s *instrumentedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), driverwrap.NamedValues(args))
+}
+
+func (s *instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	var result driver.Result
+
+	err := s.conn.instr.observe(ctx, OperationExec, s.query, args, func(ctx context.Context) error {
+		var execErr error
+
+		result, execErr = driverwrap.StmtExecContext(ctx, s.base, args)
+
+		return execErr //nolint:wrapcheck // driver errors returned as is
+	})
+
+	return result, err
+}
+
+func (s *instrumentedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), driverwrap.NamedValues(args))
+}
+
+func (s *instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	var rows driver.Rows
+
+	err := s.conn.instr.observe(ctx, OperationQuery, s.query, args, func(ctx context.Context) error {
+		var queryErr error
+
+		rows, queryErr = driverwrap.StmtQueryContext(ctx, s.base, args)
+
+		return queryErr //nolint:wrapcheck // driver errors returned as is
+	})
+
+	return rows, err
+}
+
+func (s *instrumentedStmt) CheckNamedValue(value *driver.NamedValue) error {
+	if _, isChecker := s.base.(driver.NamedValueChecker); isChecker {
+		return driverwrap.CheckNamedValue(s.base, value) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return s.conn.CheckNamedValue(value)
+}
+
+// instrumentedTx - driver.Tx functions have no context argument, so context of BeginTx used by hooks...
+type instrumentedTx struct {
+	base driver.Tx
+	conn *instrumentedConn
+	ctx  context.Context //nolint:containedctx // context of transaction begin, used by commit and rollback hooks
+}
+
+func (t *instrumentedTx) Commit() error {
+	return t.conn.instr.observe(t.ctx, OperationCommit, "", nil, func(_ context.Context) error {
+		return t.base.Commit() //nolint:wrapcheck // driver errors returned as is
+	})
+}
+
+func (t *instrumentedTx) Rollback() error {
+	return t.conn.instr.observe(t.ctx, OperationRollback, "", nil, func(_ context.Context) error {
+		return t.base.Rollback() //nolint:wrapcheck // driver errors returned as is
+	})
+}
@@ -0,0 +1,136 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+// Package driverwrap contains forwarding helpers of database/sql driver wrappers - instrumented driver,
+// fault-injecting driver and record-and-replay driver. Helpers call context-aware interface of base connection
+// or statement if implemented, otherwise fallback to legacy functions. Driver errors returned as is...
+package driverwrap
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// NamedValues converts legacy driver values to named values with ordinal positions...
+func NamedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Name: "", Ordinal: i + 1, Value: arg}
+	}
+
+	return named
+}
+
+// Values converts named values to legacy driver values...
+func Values(args []driver.NamedValue) []driver.Value {
+	plain := make([]driver.Value, len(args))
+
+	for i, arg := range args {
+		plain[i] = arg.Value
+	}
+
+	return plain
+}
+
+// PrepareContext prepares statement of base connection...
+func PrepareContext(ctx context.Context, conn driver.Conn, query string) (driver.Stmt, error) {
+	if preparer, isPreparer := conn.(driver.ConnPrepareContext); isPreparer {
+		return preparer.PrepareContext(ctx, query) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return conn.Prepare(query) //nolint:wrapcheck // driver errors returned as is
+}
+
+// BeginTx starts transaction of base connection...
+func BeginTx(ctx context.Context, conn driver.Conn, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, isBeginner := conn.(driver.ConnBeginTx); isBeginner {
+		return beginner.BeginTx(ctx, opts) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return conn.Begin() //nolint:wrapcheck,staticcheck // fallback for drivers without ConnBeginTx
+}
+
+// Ping pings base connection, connection without driver.Pinger considered alive...
+func Ping(ctx context.Context, conn driver.Conn) error {
+	if pinger, isPinger := conn.(driver.Pinger); isPinger {
+		return pinger.Ping(ctx) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return nil
+}
+
+// CheckNamedValue checks value by base connection or statement, driver.ErrSkip returned
+// if base does not implement driver.NamedValueChecker - default conversion used...
+func CheckNamedValue(base any, value *driver.NamedValue) error {
+	if checker, isChecker := base.(driver.NamedValueChecker); isChecker {
+		return checker.CheckNamedValue(value) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return driver.ErrSkip
+}
+
+// ResetSession resets session of base connection...
+func ResetSession(ctx context.Context, conn driver.Conn) error {
+	if resetter, isResetter := conn.(driver.SessionResetter); isResetter {
+		return resetter.ResetSession(ctx) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return nil
+}
+
+// IsValid reports whether base connection can be reused, connection without driver.Validator is valid...
+func IsValid(conn driver.Conn) bool {
+	if validator, isValidator := conn.(driver.Validator); isValidator {
+		return validator.IsValid()
+	}
+
+	return true
+}
+
+// StmtExecContext executes base prepared statement...
+func StmtExecContext(ctx context.Context, stmt driver.Stmt, args []driver.NamedValue) (driver.Result, error) {
+	if execer, isExecer := stmt.(driver.StmtExecContext); isExecer {
+		return execer.ExecContext(ctx, args) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return stmt.Exec(Values(args)) //nolint:wrapcheck,staticcheck // fallback for drivers without StmtExecContext
+}
+
+// StmtQueryContext queries base prepared statement...
+func StmtQueryContext(ctx context.Context, stmt driver.Stmt, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, isQueryer := stmt.(driver.StmtQueryContext); isQueryer {
+		return queryer.QueryContext(ctx, args) //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return stmt.Query(Values(args)) //nolint:wrapcheck,staticcheck // fallback for drivers without StmtQueryContext
+}
@@ -0,0 +1,347 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/pgerrors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const DefaultCursorFetchSize = 1000
+
+var ErrIteratorClosed = errors.New("iterator already closed")
+
+//nolint:gochecknoglobals // it's ok, sequence of server-side cursor names
+var cursorSequence atomic.Uint64
+
+//nolint:gochecknoglobals // it's ok
+var scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+
+// Iterator yields rows of large result set one by one, scanned into T by sqlx struct mapping.
+// Iterator must be closed, Close is safe to call after early exit of loop...
+type Iterator[T any] struct {
+	//nolint:containedctx // it's ok, iterator bounded by caller's context
+	ctx  context.Context
+	conn *Connection
+
+	rows  *sqlx.Rows
+	value T
+	err   error
+
+	isStructScan bool
+	isClosed     bool
+
+	// server-side cursor mode fields
+	cursorName  string
+	fetchSize   int
+	buffer      []T
+	bufferPos   int
+	isExhausted bool
+	stmt        Querier
+	ownTx       *sqlx.Tx
+}
+
+// Stream executes query and returns Iterator of result rows.
+// Query executed in contextual transaction if present, otherwise with connection pool...
+func Stream[T any](ctx context.Context, conn *Connection, query string, args ...interface{}) *Iterator[T] {
+	iter := newIterator[T](ctx, conn)
+
+	rows, err := conn.Q(ctx).QueryxContext(ctx, query, args...)
+	if err != nil {
+		iter.err = conn.ClassifyErrorContext(ctx, err)
+
+		return iter
+	}
+
+	iter.rows = rows
+
+	return iter
+}
+
+// StreamCursor declares server-side cursor for query and fetches rows by fetchSize batches, so memory usage
+// is constant. Cursor declared in contextual transaction if present, otherwise iterator opens own transaction,
+// which finished on Close...
+func StreamCursor[T any](ctx context.Context,
+	conn *Connection,
+	fetchSize int,
+	query string,
+	args ...interface{},
+) *Iterator[T] {
+	iter := newIterator[T](ctx, conn)
+
+	if fetchSize <= 0 {
+		fetchSize = DefaultCursorFetchSize
+	}
+
+	iter.fetchSize = fetchSize
+	iter.cursorName = "lib_postgres_cursor_" + strconv.FormatUint(cursorSequence.Add(1), 10)
+
+	tx, inTransaction := conn.TxFromContext(ctx)
+	if !inTransaction {
+		var err error
+
+		tx, err = conn.beginTx(ctx, nil, newTxOptions())
+		if err != nil {
+			iter.err = conn.e.ErrorNoWrap(err)
+
+			return iter
+		}
+
+		iter.ownTx = tx
+	}
+
+	iter.stmt = tx
+
+	_, err := tx.ExecContext(ctx, "DECLARE "+pq.QuoteIdentifier(iter.cursorName)+
+		" NO SCROLL CURSOR FOR "+query, args...)
+	if err != nil {
+		iter.err = conn.ClassifyErrorContext(ctx, err)
+		iter.cursorName = ""
+	}
+
+	return iter
+}
+
+// Next advances iterator to next row. Returns false if no more rows or error occurred...
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil || it.isClosed {
+		return false
+	}
+
+	err := it.ctx.Err()
+	if err != nil {
+		it.err = it.conn.e.ErrorOnly(err)
+
+		return false
+	}
+
+	if it.cursorName == "" {
+		return it.nextRow()
+	}
+
+	return it.nextCursorRow()
+}
+
+func (it *Iterator[T]) nextRow() bool {
+	if it.rows == nil {
+		return false
+	}
+
+	if !it.rows.Next() {
+		err := it.rows.Err()
+		if err != nil {
+			it.err = it.conn.ClassifyErrorContext(it.ctx, err)
+		}
+
+		return false
+	}
+
+	value, err := it.scan(it.rows)
+	if err != nil {
+		it.err = it.conn.e.ErrorOnly(err)
+
+		return false
+	}
+
+	it.value = value
+
+	return true
+}
+
+// nextCursorRow serves rows from buffer of fetched batch. Each FETCH batch is drained into buffer
+// and rows are closed right away, so connection is free for other statements of transaction...
+func (it *Iterator[T]) nextCursorRow() bool {
+	if it.bufferPos >= len(it.buffer) {
+		if it.isExhausted {
+			return false
+		}
+
+		err := it.fetchBatch()
+		if err != nil {
+			it.err = err
+
+			return false
+		}
+
+		if len(it.buffer) == 0 {
+			return false
+		}
+	}
+
+	it.value = it.buffer[it.bufferPos]
+	it.bufferPos++
+
+	return true
+}
+
+func (it *Iterator[T]) fetchBatch() error {
+	rows, err := it.stmt.QueryxContext(it.ctx, fmt.Sprintf("FETCH %d FROM %s",
+		it.fetchSize, pq.QuoteIdentifier(it.cursorName)))
+	if err != nil {
+		return it.conn.ClassifyErrorContext(it.ctx, err)
+	}
+
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	clear(it.buffer)
+	it.buffer = it.buffer[:0]
+	it.bufferPos = 0
+
+	for rows.Next() {
+		value, scanErr := it.scan(rows)
+		if scanErr != nil {
+			return it.conn.e.ErrorOnly(scanErr)
+		}
+
+		it.buffer = append(it.buffer, value)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return it.conn.ClassifyErrorContext(it.ctx, err)
+	}
+
+	if len(it.buffer) < it.fetchSize {
+		it.isExhausted = true
+	}
+
+	return nil
+}
+
+func (it *Iterator[T]) scan(rows *sqlx.Rows) (T, error) {
+	var value T
+
+	var err error
+
+	if it.isStructScan {
+		err = rows.StructScan(&value)
+	} else {
+		err = rows.Scan(&value)
+	}
+
+	return value, err //nolint:wrapcheck // wrapped by caller
+}
+
+// Value returns current row...
+func (it *Iterator[T]) Value() T {
+	return it.value
+}
+
+// Err returns error occurred during iteration...
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Close releases rows, closes server-side cursor and finishes own transaction of iterator...
+func (it *Iterator[T]) Close() error {
+	if it.isClosed {
+		return nil
+	}
+
+	it.isClosed = true
+
+	var closeErr error
+
+	if it.rows != nil {
+		closeErr = it.rows.Close()
+		it.rows = nil
+	}
+
+	it.buffer = nil
+
+	// server error aborts contextual transaction, so CLOSE is skipped only in this case.
+	// Context of iterator may be already cancelled, but cursor must be closed anyway
+	if it.cursorName != "" && it.ownTx == nil && pgerrors.SQLState(it.err) == "" {
+		_, err := it.stmt.ExecContext(context.WithoutCancel(it.ctx), "CLOSE "+pq.QuoteIdentifier(it.cursorName))
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+
+	if it.ownTx != nil {
+		// cursor is read-only, so rollback of own transaction is enough. Transaction is already
+		// rolled back by database/sql, if context of iterator was cancelled
+		err := it.ownTx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) && closeErr == nil {
+			closeErr = err
+		}
+	}
+
+	if closeErr != nil {
+		return it.conn.e.ErrorOnly(closeErr)
+	}
+
+	return nil
+}
+
+func newIterator[T any](ctx context.Context, conn *Connection) *Iterator[T] {
+	var value T
+
+	return &Iterator[T]{
+		ctx:          ctx,
+		conn:         conn,
+		rows:         nil,
+		value:        value,
+		err:          nil,
+		isStructScan: isStructScannable(conn, reflect.TypeOf((*T)(nil)).Elem()),
+		isClosed:     false,
+		cursorName:   "",
+		fetchSize:    0,
+		buffer:       nil,
+		bufferPos:    0,
+		isExhausted:  false,
+		stmt:         nil,
+		ownTx:        nil,
+	}
+}
+
+// isStructScannable same with sqlx rules - struct without sql.Scanner implementation and with mapped fields...
+func isStructScannable(conn *Connection, valueType reflect.Type) bool {
+	if valueType.Kind() != reflect.Struct || reflect.PointerTo(valueType).Implements(scannerType) {
+		return false
+	}
+
+	return len(conn.Dbx.Mapper.TypeMap(valueType).Index) != 0
+}
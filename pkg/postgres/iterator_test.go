@@ -0,0 +1,266 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type iteratorTestWallet struct {
+	ID   int64  `db:"id"`
+	Name string `db:"name"`
+}
+
+// cursorDatabase returns stub database, which serves rows of wallets table by FETCH statements.
+// FETCH statement fails with fetchErr, if it is set...
+func cursorDatabase(rowsCount int, fetchErr error) *stubDatabase {
+	served := 0
+
+	return &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+		if !strings.HasPrefix(statement.query, "FETCH 2 FROM") {
+			return stubResult{}, false //nolint:exhaustruct // result not scripted
+		}
+
+		if fetchErr != nil {
+			return stubResult{columns: nil, rows: nil, rowsAffected: 0, err: fetchErr}, true
+		}
+
+		rows := make([][]driver.Value, 0, 2)
+
+		for ; served < rowsCount && len(rows) < 2; served++ {
+			rows = append(rows, []driver.Value{int64(served + 1), "wallet"})
+		}
+
+		return stubResult{columns: []string{"id", "name"}, rows: rows, rowsAffected: 0, err: nil}, true
+	}}
+}
+
+func collectIterator[T any](t *testing.T, iter *Iterator[T]) []T {
+	t.Helper()
+
+	var values []T
+
+	for iter.Next() {
+		values = append(values, iter.Value())
+	}
+
+	err := iter.Err()
+	if err != nil {
+		t.Fatalf("iterator returned error: %v", err)
+	}
+
+	err = iter.Close()
+	if err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	return values
+}
+
+func TestStream(t *testing.T) {
+	db := &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+		if !strings.HasPrefix(statement.query, "SELECT id") {
+			return stubResult{}, false //nolint:exhaustruct // result not scripted
+		}
+
+		if statement.query == "SELECT id FROM wallets" {
+			return stubResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(1)}}, rowsAffected: 0, err: nil}, true
+		}
+
+		return stubResult{
+			columns:      []string{"id", "name"},
+			rows:         [][]driver.Value{{int64(1), "first"}, {int64(2), "second"}},
+			rowsAffected: 0,
+			err:          nil,
+		}, true
+	}}
+	conn := newStubConnection(t, db)
+
+	wallets := collectIterator(t, Stream[iteratorTestWallet](context.Background(), conn,
+		"SELECT id, name FROM wallets"))
+
+	want := []iteratorTestWallet{{ID: 1, Name: "first"}, {ID: 2, Name: "second"}}
+	if !reflect.DeepEqual(wallets, want) {
+		t.Errorf("wallets = %+v, want %+v", wallets, want)
+	}
+
+	// scalar values scanned without struct mapping
+	iter := Stream[int64](context.Background(), conn, "SELECT id FROM wallets")
+	if !iter.Next() || iter.Value() != 1 {
+		t.Fatalf("first value = %d, error = %v", iter.Value(), iter.Err())
+	}
+
+	// early exit of loop
+	err := iter.Close()
+	if err != nil || iter.Next() {
+		t.Errorf("iterator not closed, error = %v", err)
+	}
+}
+
+func TestStreamCursor(t *testing.T) {
+	testCases := []struct {
+		name       string
+		rowsCount  int
+		fetchCount int
+	}{
+		{name: "empty result", rowsCount: 0, fetchCount: 1},
+		{name: "last batch not full", rowsCount: 3, fetchCount: 2},
+		{name: "last batch full", rowsCount: 4, fetchCount: 3},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := cursorDatabase(testCase.rowsCount, nil)
+			conn := newStubConnection(t, db)
+
+			wallets := collectIterator(t, StreamCursor[iteratorTestWallet](context.Background(), conn, 2,
+				"SELECT id, name FROM wallets WHERE name = $1", "wallet"))
+
+			if len(wallets) != testCase.rowsCount {
+				t.Errorf("iterated %d rows, want %d", len(wallets), testCase.rowsCount)
+			}
+
+			declare, isFound := db.find("DECLARE")
+			if !isFound || !strings.HasSuffix(declare.query, " NO SCROLL CURSOR FOR SELECT id, name FROM wallets WHERE name = $1") ||
+				!reflect.DeepEqual(declare.args, []driver.Value{"wallet"}) {
+				t.Errorf("unexpected DECLARE statement: %+v", declare)
+			}
+
+			fetchCount := 0
+
+			for _, query := range db.queries() {
+				if strings.HasPrefix(query, "FETCH") {
+					fetchCount++
+				}
+			}
+
+			if fetchCount != testCase.fetchCount {
+				t.Errorf("executed %d FETCH statements, want %d", fetchCount, testCase.fetchCount)
+			}
+
+			// own transaction rolled back without CLOSE statement
+			if _, isClosed := db.find("CLOSE"); isClosed || countStubQueries(db, "ROLLBACK") != 1 {
+				t.Errorf("own transaction is not rolled back, executed %q", db.queries())
+			}
+		})
+	}
+}
+
+func TestStreamCursorContextualTx(t *testing.T) {
+	testCases := []struct {
+		name      string
+		fetchErr  error
+		isClosed  bool
+		isFailure bool
+	}{
+		{name: "cursor closed", fetchErr: nil, isClosed: true, isFailure: false},
+		{
+			name:      "server error aborts transaction",
+			fetchErr:  &pq.Error{Code: "57014"}, //nolint:exhaustruct // only code
+			isClosed:  false,
+			isFailure: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := cursorDatabase(3, testCase.fetchErr)
+			conn := newStubConnection(t, db)
+
+			err := conn.BeginTxWithRollbackOnError(context.Background(), func(txStmtCtx context.Context) error {
+				iter := StreamCursor[iteratorTestWallet](txStmtCtx, conn, 2, "SELECT id, name FROM wallets")
+
+				for iter.Next() {
+				}
+
+				closeErr := iter.Close()
+				if closeErr != nil {
+					t.Errorf("Close returned error: %v", closeErr)
+				}
+
+				return iter.Err()
+			})
+			if (err != nil) != testCase.isFailure {
+				t.Fatalf("transaction error = %v, failure expected - %t", err, testCase.isFailure)
+			}
+
+			if _, isClosed := db.find("CLOSE"); isClosed != testCase.isClosed {
+				t.Errorf("cursor closed - %t, want %t", isClosed, testCase.isClosed)
+			}
+
+			if countStubQueries(db, "BEGIN") != 1 {
+				t.Errorf("iterator opened own transaction, executed %q", db.queries())
+			}
+		})
+	}
+}
+
+func TestStreamCursorCancelledContext(t *testing.T) {
+	db := cursorDatabase(3, nil)
+	conn := newStubConnection(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	iter := StreamCursor[iteratorTestWallet](ctx, conn, 2, "SELECT id, name FROM wallets")
+	if !iter.Next() {
+		t.Fatalf("first row not fetched, error = %v", iter.Err())
+	}
+
+	cancel()
+
+	if iter.Next() || !errors.Is(iter.Err(), context.Canceled) {
+		t.Fatalf("iterator error = %v, want %v", iter.Err(), context.Canceled)
+	}
+
+	// wait for rollback of own transaction by database/sql
+	for deadline := time.Now().Add(time.Second); countStubQueries(db, "ROLLBACK") == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("own transaction is not rolled back after context cancel")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	err := iter.Close()
+	if err != nil {
+		t.Errorf("Close returned error: %v", err)
+	}
+}
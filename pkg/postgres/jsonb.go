@@ -0,0 +1,250 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNullJSONB              = errors.New("unable to scan NULL into not nullable jsonb type")
+	ErrUnsupportedJSONBSource = errors.New("unsupported source type of jsonb value")
+)
+
+// StrictJSONDecoding is the optional interface of JSONB value type.
+// If DisallowUnknownJSONFields returns true, unknown object fields returns decoding error.
+// For strict decoding of single column StrictJSONB and NullStrictJSONB types can be used...
+type StrictJSONDecoding interface {
+	DisallowUnknownJSONFields() bool
+}
+
+// JSONB is the generic JSON/JSONB column type. Value encoded as string, so it supported by COPY helpers too...
+type JSONB[T any] struct {
+	V T
+}
+
+// NewJSONB ....
+func NewJSONB[T any](value T) JSONB[T] {
+	return JSONB[T]{V: value}
+}
+
+func (j *JSONB[T]) Scan(src interface{}) error {
+	if src == nil {
+		return ErrNullJSONB
+	}
+
+	var value T
+
+	err := decodeJSONB(src, &value, false)
+	if err != nil {
+		return err
+	}
+
+	j.V = value
+
+	return nil
+}
+
+func (j JSONB[T]) Value() (driver.Value, error) {
+	return encodeJSONB(j.V)
+}
+
+// NullJSONB is the nullable JSONB...
+type NullJSONB[T any] struct {
+	V     T
+	Valid bool
+}
+
+// NewNullJSONB ....
+func NewNullJSONB[T any](value T) NullJSONB[T] {
+	return NullJSONB[T]{V: value, Valid: true}
+}
+
+func (n *NullJSONB[T]) Scan(src interface{}) error {
+	var value T
+
+	if src == nil {
+		n.V, n.Valid = value, false
+
+		return nil
+	}
+
+	err := decodeJSONB(src, &value, false)
+	if err != nil {
+		return err
+	}
+
+	n.V, n.Valid = value, true
+
+	return nil
+}
+
+func (n NullJSONB[T]) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+
+	return encodeJSONB(n.V)
+}
+
+// StrictJSONB same with JSONB, but unknown object fields returns decoding error...
+type StrictJSONB[T any] struct {
+	V T
+}
+
+// NewStrictJSONB ....
+func NewStrictJSONB[T any](value T) StrictJSONB[T] {
+	return StrictJSONB[T]{V: value}
+}
+
+func (j *StrictJSONB[T]) Scan(src interface{}) error {
+	if src == nil {
+		return ErrNullJSONB
+	}
+
+	var value T
+
+	err := decodeJSONB(src, &value, true)
+	if err != nil {
+		return err
+	}
+
+	j.V = value
+
+	return nil
+}
+
+func (j StrictJSONB[T]) Value() (driver.Value, error) {
+	return encodeJSONB(j.V)
+}
+
+// NullStrictJSONB same with NullJSONB, but unknown object fields returns decoding error...
+type NullStrictJSONB[T any] struct {
+	V     T
+	Valid bool
+}
+
+// NewNullStrictJSONB ....
+func NewNullStrictJSONB[T any](value T) NullStrictJSONB[T] {
+	return NullStrictJSONB[T]{V: value, Valid: true}
+}
+
+func (n *NullStrictJSONB[T]) Scan(src interface{}) error {
+	var value T
+
+	if src == nil {
+		n.V, n.Valid = value, false
+
+		return nil
+	}
+
+	err := decodeJSONB(src, &value, true)
+	if err != nil {
+		return err
+	}
+
+	n.V, n.Valid = value, true
+
+	return nil
+}
+
+func (n NullStrictJSONB[T]) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+
+	return encodeJSONB(n.V)
+}
+
+// JSONBPathContains returns query parameter for containment operator - column @> $1.
+// Value nested into objects by path, e.g. path "meta", "chain" gives {"meta":{"chain":value}}...
+func JSONBPathContains(value any, path ...string) JSONB[any] {
+	for i := len(path) - 1; i >= 0; i-- {
+		value = map[string]any{path[i]: value}
+	}
+
+	return JSONB[any]{V: value}
+}
+
+// JSONBPath returns text[] query parameter for path operators - column #> $1 and column #>> $1...
+func JSONBPath(path ...string) string {
+	elements := make([]*string, len(path))
+
+	for i := range path {
+		elements[i] = &path[i]
+	}
+
+	return formatArrayLiteral(elements)
+}
+
+func encodeJSONB(value any) (driver.Value, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode jsonb value: %w", err)
+	}
+
+	return string(encoded), nil
+}
+
+// decodeJSONB decodes JSON value into dst. Unknown object fields disallowed if isStrict set
+// or dst implements StrictJSONDecoding...
+func decodeJSONB(src interface{}, dst any, isStrict bool) error {
+	var srcBytes []byte
+
+	switch value := src.(type) {
+	case []byte:
+		srcBytes = value
+	case string:
+		srcBytes = []byte(value)
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedJSONBSource, src)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(srcBytes))
+
+	strict, isStrictType := dst.(StrictJSONDecoding)
+	if isStrict || (isStrictType && strict.DisallowUnknownJSONFields()) {
+		decoder.DisallowUnknownFields()
+	}
+
+	err := decoder.Decode(dst)
+	if err != nil {
+		return fmt.Errorf("unable to decode jsonb value: %w", err)
+	}
+
+	return nil
+}
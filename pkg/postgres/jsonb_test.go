@@ -0,0 +1,212 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+type jsonbTestMeta struct {
+	Chain string `json:"chain"`
+	Block int64  `json:"block"`
+}
+
+// jsonbTestStrictMeta disallows unknown fields by StrictJSONDecoding interface...
+type jsonbTestStrictMeta struct {
+	Chain string `json:"chain"`
+}
+
+func (m jsonbTestStrictMeta) DisallowUnknownJSONFields() bool {
+	return true
+}
+
+func TestJSONBScan(t *testing.T) {
+	testCases := []struct {
+		name string
+		src  interface{}
+		want jsonbTestMeta
+		err  error
+	}{
+		{name: "bytes", src: []byte(`{"chain":"tron","block":42}`), want: jsonbTestMeta{Chain: "tron", Block: 42}},
+		{name: "string", src: `{"chain":"tron"}`, want: jsonbTestMeta{Chain: "tron", Block: 0}},
+		{name: "unknown fields allowed", src: `{"chain":"tron","memo":"x"}`, want: jsonbTestMeta{Chain: "tron"}},
+		{name: "null", src: nil, want: jsonbTestMeta{}, err: ErrNullJSONB},
+		{name: "unsupported source", src: int64(1), want: jsonbTestMeta{}, err: ErrUnsupportedJSONBSource},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var value JSONB[jsonbTestMeta]
+
+			err := value.Scan(testCase.src)
+			if !errors.Is(err, testCase.err) {
+				t.Fatalf("Scan error = %v, want %v", err, testCase.err)
+			}
+
+			if value.V != testCase.want {
+				t.Errorf("value = %+v, want %+v", value.V, testCase.want)
+			}
+		})
+	}
+
+	var invalid JSONB[jsonbTestMeta]
+	if err := invalid.Scan(`{"chain":`); err == nil {
+		t.Error("Scan of invalid JSON returned nil error")
+	}
+}
+
+func TestNullJSONBScan(t *testing.T) {
+	value := NewNullJSONB(jsonbTestMeta{Chain: "tron", Block: 1})
+
+	err := value.Scan(nil)
+	if err != nil || value.Valid || value.V != (jsonbTestMeta{}) {
+		t.Errorf("Scan(nil) = %+v, error = %v, want invalid zero value", value, err)
+	}
+
+	err = value.Scan([]byte(`{"chain":"eth"}`))
+	if err != nil || !value.Valid || value.V.Chain != "eth" {
+		t.Errorf("Scan = %+v, error = %v, want valid value", value, err)
+	}
+}
+
+func TestJSONBValue(t *testing.T) {
+	testCases := []struct {
+		name   string
+		valuer driver.Valuer
+		want   driver.Value
+	}{
+		{name: "jsonb", valuer: NewJSONB(jsonbTestMeta{Chain: "tron", Block: 2}), want: `{"chain":"tron","block":2}`},
+		{name: "null jsonb", valuer: NullJSONB[jsonbTestMeta]{V: jsonbTestMeta{}, Valid: false}, want: nil},
+		{name: "valid null jsonb", valuer: NewNullJSONB([]int{1, 2}), want: `[1,2]`},
+		{name: "strict jsonb", valuer: NewStrictJSONB("tron"), want: `"tron"`},
+		{name: "null strict jsonb", valuer: NullStrictJSONB[string]{V: "", Valid: false}, want: nil},
+		{name: "valid null strict jsonb", valuer: NewNullStrictJSONB(true), want: `true`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			value, err := testCase.valuer.Value()
+			if err != nil {
+				t.Fatalf("Value returned error: %v", err)
+			}
+
+			if value != testCase.want {
+				t.Errorf("Value = %v, want %v", value, testCase.want)
+			}
+		})
+	}
+
+	_, err := NewJSONB(func() {}).Value()
+	if err == nil {
+		t.Error("Value of not encodable type returned nil error")
+	}
+}
+
+func TestJSONBStrictDecoding(t *testing.T) {
+	const src = `{"chain":"tron","memo":"x"}`
+
+	var byInterface JSONB[jsonbTestStrictMeta]
+	if err := byInterface.Scan(src); err == nil {
+		t.Error("JSONB of StrictJSONDecoding type decoded unknown field")
+	}
+
+	var strict StrictJSONB[jsonbTestMeta]
+	if err := strict.Scan(src); err == nil {
+		t.Error("StrictJSONB decoded unknown field")
+	}
+
+	if err := strict.Scan(`{"chain":"tron"}`); err != nil || strict.V.Chain != "tron" {
+		t.Errorf("StrictJSONB = %+v, error = %v", strict.V, err)
+	}
+
+	if err := strict.Scan(nil); !errors.Is(err, ErrNullJSONB) {
+		t.Errorf("StrictJSONB Scan(nil) error = %v, want %v", err, ErrNullJSONB)
+	}
+
+	nullStrict := NewNullStrictJSONB(jsonbTestMeta{Chain: "eth", Block: 0})
+	if err := nullStrict.Scan([]byte(src)); err == nil {
+		t.Error("NullStrictJSONB decoded unknown field")
+	}
+
+	if err := nullStrict.Scan(nil); err != nil || nullStrict.Valid {
+		t.Errorf("NullStrictJSONB Scan(nil) = %+v, error = %v, want invalid value", nullStrict, err)
+	}
+
+	// same type in another column decoded without strict mode
+	var lenient JSONB[jsonbTestMeta]
+	if err := lenient.Scan(src); err != nil || lenient.V.Chain != "tron" {
+		t.Errorf("JSONB = %+v, error = %v", lenient.V, err)
+	}
+}
+
+func TestJSONBPathHelpers(t *testing.T) {
+	contains, err := JSONBPathContains("tron", "meta", "chain").Value()
+	if err != nil || contains != `{"meta":{"chain":"tron"}}` {
+		t.Errorf("JSONBPathContains = %v, error = %v", contains, err)
+	}
+
+	contains, err = JSONBPathContains(map[string]int{"block": 1}).Value()
+	if err != nil || contains != `{"block":1}` {
+		t.Errorf("JSONBPathContains without path = %v, error = %v", contains, err)
+	}
+
+	if path := JSONBPath("meta", "chain name"); path != `{"meta","chain name"}` {
+		t.Errorf("JSONBPath = %s", path)
+	}
+}
+
+func TestJSONBCopyFromStructs(t *testing.T) {
+	type walletRow struct {
+		ID   int64                    `db:"id"`
+		Meta NullJSONB[jsonbTestMeta] `db:"meta"`
+	}
+
+	db := &stubDatabase{}
+	conn := newStubConnection(t, db)
+
+	_, err := CopyFromStructs(context.Background(), conn, "wallets", []walletRow{
+		{ID: 1, Meta: NewNullJSONB(jsonbTestMeta{Chain: "tron", Block: 1})},
+	}, 0)
+	if err != nil {
+		t.Fatalf("CopyFromStructs returned error: %v", err)
+	}
+
+	for _, statement := range db.statements {
+		if len(statement.args) == 2 && statement.args[1] != `{"chain":"tron","block":1}` {
+			t.Errorf("copied jsonb value = %v", statement.args[1])
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	CommandUp     = "up"
+	CommandDown   = "down"
+	CommandStatus = "status"
+	CommandVerify = "verify"
+	CommandCreate = "create"
+
+	createVersionLayout = "20060102150405"
+)
+
+var (
+	ErrUnknownCommand       = errors.New("unknown migrations command")
+	ErrInvalidCommandArgs   = errors.New("invalid migrations command arguments")
+	ErrInvalidMigrationSlug = errors.New("migration name must contain only letters, digits, '_' and '-' symbols")
+)
+
+//nolint:gochecknoglobals // it's ok, compiled once
+var migrationSlugRegexp = regexp.MustCompile(`^[\w\-]+$`)
+
+// RunCommand executes up, down, status or verify command with migrator and writes report to out.
+// Can be used by services for own migrate commands with embedded migrations:
+//   - up [version] - applies all pending migrations or migrations up to version
+//   - down [version] - rolls back last migration or all migrations after version
+//   - status - prints status of migrations
+//   - verify - checks checksums of applied migrations
+func RunCommand(ctx context.Context, migrator *Migrator, out io.Writer, command string, args ...string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%w: %s %v", ErrInvalidCommandArgs, command, args)
+	}
+
+	switch command {
+	case CommandUp:
+		return runUp(ctx, migrator, out, args)
+	case CommandDown:
+		return runDown(ctx, migrator, out, args)
+	case CommandStatus:
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		return PrintStatus(out, statuses)
+	case CommandVerify:
+		err := migrator.Verify(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintln(out, "all applied migrations verified")
+
+		return err
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownCommand, command)
+	}
+}
+
+func runUp(ctx context.Context, migrator *Migrator, out io.Writer, args []string) error {
+	var (
+		applied []*Migration
+		err     error
+	)
+
+	if len(args) == 0 {
+		applied, err = migrator.Up(ctx)
+	} else {
+		version, parseErr := strconv.ParseInt(args[0], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidCommandArgs, parseErr)
+		}
+
+		applied, err = migrator.UpTo(ctx, version)
+	}
+
+	printErr := printMigrations(out, migrator.isDryRun, "up", applied)
+
+	return errors.Join(err, printErr)
+}
+
+func runDown(ctx context.Context, migrator *Migrator, out io.Writer, args []string) error {
+	var (
+		rolledBack []*Migration
+		err        error
+	)
+
+	if len(args) == 0 {
+		rolledBack, err = migrator.Down(ctx)
+	} else {
+		version, parseErr := strconv.ParseInt(args[0], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidCommandArgs, parseErr)
+		}
+
+		rolledBack, err = migrator.DownTo(ctx, version)
+	}
+
+	printErr := printMigrations(out, migrator.isDryRun, "down", rolledBack)
+
+	return errors.Join(err, printErr)
+}
+
+func printMigrations(out io.Writer, isDryRun bool, direction string, migrations []*Migration) error {
+	prefix := ""
+	if isDryRun {
+		prefix = "[dry-run] "
+	}
+
+	if len(migrations) == 0 {
+		_, err := fmt.Fprintf(out, "%sno migrations to apply: %s\n", prefix, direction)
+
+		return err
+	}
+
+	for _, migration := range migrations {
+		_, err := fmt.Fprintf(out, "%s%s: %s\n", prefix, direction, migration.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PrintStatus writes statuses of migrations as table...
+func PrintStatus(out io.Writer, statuses []*MigrationStatus) error {
+	writer := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) //nolint:mnd // table padding
+
+	_, err := fmt.Fprintln(writer, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		state := "pending"
+		appliedAt := "-"
+
+		switch {
+		case status.IsApplied && status.Migration == nil:
+			state = "applied, file missing"
+		case status.IsChecksumMismatch:
+			state = "applied, checksum mismatch"
+		case status.IsApplied:
+			state = "applied"
+		}
+
+		if status.IsApplied {
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+
+		_, err = fmt.Fprintf(writer, "%d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Flush()
+}
+
+// CreateFiles creates empty up and down migration files in directory.
+// Version of migration is the creation timestamp in YYYYMMDDhhmmss format...
+func CreateFiles(dir, name string, now time.Time) (string, string, error) {
+	if !migrationSlugRegexp.MatchString(name) {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidMigrationSlug, name)
+	}
+
+	prefix := filepath.Join(dir, now.UTC().Format(createVersionLayout)+"_"+name)
+	upFile, downFile := prefix+".up.sql", prefix+".down.sql"
+
+	for _, fileName := range []string{upFile, downFile} {
+		file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644) //nolint:mnd // file mode
+		if err != nil {
+			return "", "", err
+		}
+
+		err = file.Close()
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return upFile, downFile, nil
+}
@@ -0,0 +1,329 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	upSuffix   = "up"
+	downSuffix = "down"
+
+	// NoTransactionDirective disables transaction for migration, must be placed in first line of migration file.
+	// Required for statements which can not be executed in transaction, e.g. CREATE INDEX CONCURRENTLY.
+	// Migration file with directive must contain only one statement - several statements of one query
+	// executed by server in implicit transaction
+	NoTransactionDirective = "-- migrate:no-transaction"
+)
+
+var (
+	ErrDuplicateMigration   = errors.New("duplicate migration version")
+	ErrMissingUpMigration   = errors.New("missing up migration file")
+	ErrInvalidMigrationName = errors.New("invalid migration file name")
+	// ErrNoTxMultipleStatements - migration file with NoTransactionDirective contains several statements
+	ErrNoTxMultipleStatements = errors.New("migration without transaction must contain only one statement")
+)
+
+//nolint:gochecknoglobals // it's ok, compiled once
+var fileNameRegexp = regexp.MustCompile(`^(\d+)_([\w\-]+)\.(up|down)\.sql$`)
+
+// Migration is the versioned pair of up and down SQL scripts...
+type Migration struct {
+	Version int64
+	Name    string
+
+	UpSQL   string
+	DownSQL string
+	// Checksum is the SHA-256 checksum of up script
+	Checksum string
+
+	UpNoTransaction   bool
+	DownNoTransaction bool
+}
+
+// HasDown reports whether migration has down script...
+func (m *Migration) HasDown() bool {
+	return m.DownSQL != ""
+}
+
+func (m *Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+// ParseFileName parses migration file name in format {version}_{name}.{up|down}.sql...
+func ParseFileName(fileName string) (int64, string, string, error) {
+	matches := fileNameRegexp.FindStringSubmatch(fileName)
+	if matches == nil {
+		return 0, "", "", fmt.Errorf("%w: %s", ErrInvalidMigrationName, fileName)
+	}
+
+	version, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return 0, "", "", fmt.Errorf("%w: %s: %w", ErrInvalidMigrationName, fileName, err)
+	}
+
+	return version, matches[2], matches[3], nil
+}
+
+// Load reads migrations from directory of file system, e.g. embed.FS. Files with other extensions skipped...
+func Load(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read migrations directory: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration, len(entries))
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		loadErr := loadFile(fsys, dir, entry.Name(), byVersion)
+		if loadErr != nil {
+			return nil, loadErr
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+
+	for _, migration := range byVersion {
+		if migration.UpSQL == "" {
+			return nil, fmt.Errorf("%w: %s", ErrMissingUpMigration, migration.String())
+		}
+
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func loadFile(fsys fs.FS, dir, fileName string, byVersion map[int64]*Migration) error {
+	version, name, direction, err := ParseFileName(fileName)
+	if err != nil {
+		return err
+	}
+
+	content, err := fs.ReadFile(fsys, path.Join(dir, fileName))
+	if err != nil {
+		return fmt.Errorf("unable to read migration file %s: %w", fileName, err)
+	}
+
+	migration, isExists := byVersion[version]
+	if !isExists {
+		migration = &Migration{
+			Version:           version,
+			Name:              name,
+			UpSQL:             "",
+			DownSQL:           "",
+			Checksum:          "",
+			UpNoTransaction:   false,
+			DownNoTransaction: false,
+		}
+
+		byVersion[version] = migration
+	}
+
+	if migration.Name != name {
+		return fmt.Errorf("%w: %d", ErrDuplicateMigration, version)
+	}
+
+	script := string(content)
+
+	noTransaction := hasNoTransactionDirective(script)
+	if noTransaction && countStatements(script) > 1 {
+		return fmt.Errorf("%w: %s", ErrNoTxMultipleStatements, fileName)
+	}
+
+	switch direction {
+	case upSuffix:
+		if migration.UpSQL != "" {
+			return fmt.Errorf("%w: %d", ErrDuplicateMigration, version)
+		}
+
+		checksum := sha256.Sum256(content)
+
+		migration.UpSQL = script
+		migration.Checksum = hex.EncodeToString(checksum[:])
+		migration.UpNoTransaction = noTransaction
+	case downSuffix:
+		if migration.DownSQL != "" {
+			return fmt.Errorf("%w: %d", ErrDuplicateMigration, version)
+		}
+
+		migration.DownSQL = script
+		migration.DownNoTransaction = noTransaction
+	}
+
+	return nil
+}
+
+func hasNoTransactionDirective(script string) bool {
+	firstLine, _, _ := strings.Cut(strings.TrimLeft(script, " \t\r\n"), "\n")
+
+	return strings.TrimSpace(firstLine) == NoTransactionDirective
+}
+
+// countStatements returns count of non-empty statements of script, separated by semicolons.
+// Semicolons in string literals, quoted identifiers, dollar-quoted strings and comments are skipped...
+//
+//nolint:gocognit,cyclop,funlen // it's ok, single pass lexer
+func countStatements(script string) int {
+	count := 0
+	hasContent := false
+
+	for i := 0; i < len(script); i++ {
+		char := script[i]
+
+		switch {
+		case char == '-' && i+1 < len(script) && script[i+1] == '-':
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+
+				continue
+			}
+
+			i += end
+		case char == '/' && i+1 < len(script) && script[i+1] == '*':
+			depth := 1
+			i += 2
+
+			for ; i < len(script) && depth > 0; i++ {
+				switch {
+				case script[i] == '/' && i+1 < len(script) && script[i+1] == '*':
+					depth++
+					i++
+				case script[i] == '*' && i+1 < len(script) && script[i+1] == '/':
+					depth--
+					i++
+				}
+			}
+
+			i--
+		case char == '\'' || char == '"':
+			hasContent = true
+			isEscapeString := char == '\'' && i > 0 && (script[i-1] == 'E' || script[i-1] == 'e')
+
+			for i++; i < len(script); i++ {
+				if isEscapeString && script[i] == '\\' {
+					i++
+
+					continue
+				}
+
+				if script[i] == char {
+					// doubled quote is the escaped quote
+					if i+1 < len(script) && script[i+1] == char {
+						i++
+
+						continue
+					}
+
+					break
+				}
+			}
+		case char == '$':
+			hasContent = true
+
+			// dollar quote can not follow identifier, e.g. in name of column
+			if i > 0 && isIdentifierChar(script[i-1]) {
+				continue
+			}
+
+			tag, isTag := dollarQuoteTag(script[i:])
+			if !isTag {
+				continue
+			}
+
+			end := strings.Index(script[i+len(tag):], tag)
+			if end < 0 {
+				i = len(script)
+
+				continue
+			}
+
+			i += len(tag) + end + len(tag) - 1
+		case char == ';':
+			if hasContent {
+				count++
+			}
+
+			hasContent = false
+		case char != ' ' && char != '\t' && char != '\r' && char != '\n':
+			hasContent = true
+		}
+	}
+
+	if hasContent {
+		count++
+	}
+
+	return count
+}
+
+// dollarQuoteTag returns opening tag of dollar-quoted string, e.g. $$ or $body$...
+func dollarQuoteTag(script string) (string, bool) {
+	for i := 1; i < len(script); i++ {
+		char := script[i]
+
+		switch {
+		case char == '$':
+			return script[:i+1], true
+		case isIdentifierChar(char) && (i > 1 || char < '0' || char > '9'):
+		default:
+			return "", false
+		}
+	}
+
+	return "", false
+}
+
+func isIdentifierChar(char byte) bool {
+	return char == '_' || char == '$' ||
+		(char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9')
+}
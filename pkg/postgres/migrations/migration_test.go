@@ -0,0 +1,98 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package migrations
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCountStatements(t *testing.T) {
+	testCases := []struct {
+		name   string
+		script string
+		want   int
+	}{
+		{name: "empty", script: "", want: 0},
+		{name: "only comments", script: "-- migrate:no-transaction\n/* nothing; here */\n", want: 0},
+		{name: "single without semicolon", script: "CREATE INDEX CONCURRENTLY idx ON t (a)", want: 1},
+		{name: "single with semicolon", script: "-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY idx ON t (a);\n", want: 1},
+		{name: "trailing empty statements", script: "VACUUM t;;\n;", want: 1},
+		{name: "two statements", script: "CREATE INDEX CONCURRENTLY a ON t (a);\nCREATE INDEX CONCURRENTLY b ON t (b);", want: 2},
+		{name: "semicolon in string", script: "INSERT INTO t VALUES ('a;b')", want: 1},
+		{name: "doubled quote in string", script: "INSERT INTO t VALUES ('it''s; fine')", want: 1},
+		{name: "escape string", script: `INSERT INTO t VALUES (E'a\';b')`, want: 1},
+		{name: "quoted identifier", script: `SELECT 1 AS "a;b"`, want: 1},
+		{name: "line comment", script: "SELECT 1 -- ; not a separator\n", want: 1},
+		{name: "nested block comment", script: "/* a /* b; */ c; */ SELECT 1", want: 1},
+		{name: "dollar quoted body", script: "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql", want: 1},
+		{name: "tagged dollar quote", script: "DO $body$ BEGIN PERFORM 1; END $body$;", want: 1},
+		{name: "positional parameter", script: "SELECT $1; SELECT $2", want: 2},
+		{name: "dollar in identifier", script: "SELECT a$b; SELECT 1", want: 2},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := countStatements(testCase.script)
+			if got != testCase.want {
+				t.Errorf("countStatements(%q) = %d, want %d", testCase.script, got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsNoTransactionMultipleStatements(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_init.up.sql": {Data: []byte("CREATE TABLE t (a INT, b INT);")},
+		"2_indexes.up.sql": {Data: []byte(NoTransactionDirective + "\n" +
+			"CREATE INDEX CONCURRENTLY a_idx ON t (a);\nCREATE INDEX CONCURRENTLY b_idx ON t (b);\n")},
+	}
+
+	_, err := Load(fsys, ".")
+	if !errors.Is(err, ErrNoTxMultipleStatements) {
+		t.Fatalf("Load error = %v, want %v", err, ErrNoTxMultipleStatements)
+	}
+
+	fsys["2_indexes.up.sql"] = &fstest.MapFile{Data: []byte(NoTransactionDirective + "\n" +
+		"CREATE INDEX CONCURRENTLY a_idx ON t (a);\n")}
+
+	migrations, err := Load(fsys, ".")
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	if len(migrations) != 2 || !migrations[1].UpNoTransaction {
+		t.Fatalf("unexpected migrations: %v", migrations)
+	}
+}
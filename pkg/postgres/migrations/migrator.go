@@ -0,0 +1,537 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	DefaultTableName = "schema_migrations"
+	// DefaultLockID is the key of advisory lock, which guarantees that only one replica migrates database
+	DefaultLockID int64 = 8_317_466_712_354
+
+	MigrationVersionTag = "migration_version"
+	MigrationNameTag    = "migration_name"
+	DryRunTag           = "dry_run"
+)
+
+var (
+	ErrChecksumMismatch     = errors.New("checksum of applied migration not equal to migration file checksum")
+	ErrMissingMigrationFile = errors.New("applied migration file not found")
+	ErrMissingDownMigration = errors.New("missing down migration file")
+)
+
+// Option ....
+type Option func(m *Migrator)
+
+// WithDirectory sets directory of migration files in file system. Root directory used by default...
+func WithDirectory(dir string) Option {
+	return func(m *Migrator) {
+		m.dir = dir
+	}
+}
+
+// WithTableName sets name of applied migrations table, can be qualified by schema name...
+func WithTableName(tableName string) Option {
+	return func(m *Migrator) {
+		m.tableName = tableName
+	}
+}
+
+// WithLockID sets key of advisory lock...
+func WithLockID(lockID int64) Option {
+	return func(m *Migrator) {
+		m.lockID = lockID
+	}
+}
+
+// WithDryRun enables dry-run mode - migrations only logged, but not executed...
+func WithDryRun(isDryRun bool) Option {
+	return func(m *Migrator) {
+		m.isDryRun = isDryRun
+	}
+}
+
+// MigrationStatus ....
+type MigrationStatus struct {
+	Version int64
+	Name    string
+	// Migration is nil if applied migration file not found
+	Migration *Migration
+
+	IsApplied bool
+	AppliedAt time.Time
+	// IsChecksumMismatch - applied migration file changed after apply
+	IsChecksumMismatch bool
+}
+
+type appliedMigration struct {
+	Version   int64     `db:"version"`
+	Name      string    `db:"name"`
+	Checksum  string    `db:"checksum"`
+	AppliedAt time.Time `db:"applied_at"`
+}
+
+// Migrator applies and rolls back versioned SQL migrations...
+type Migrator struct {
+	l    *slog.Logger
+	conn *postgres.Connection
+
+	dir        string
+	migrations []*Migration
+
+	tableName string
+	lockID    int64
+	isDryRun  bool
+}
+
+// Migrations returns list of loaded migrations, sorted by version...
+func (m *Migrator) Migrations() []*Migration {
+	return m.migrations
+}
+
+// Status returns status of all loaded and applied migrations, sorted by version...
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	applied, err := m.appliedMigrations(ctx, m.conn.Dbx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.status(applied), nil
+}
+
+// Verify checks that all applied migrations have files with same checksum...
+func (m *Migrator) Verify(ctx context.Context) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	return verifyStatuses(statuses)
+}
+
+// Up applies all pending migrations...
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	return m.UpTo(ctx, math.MaxInt64)
+}
+
+// UpTo applies pending migrations with version less or equal to target version...
+func (m *Migrator) UpTo(ctx context.Context, version int64) ([]*Migration, error) {
+	var result []*Migration
+
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := m.appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		statuses := m.status(applied)
+
+		err = verifyChecksums(statuses)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range statuses {
+			if status.IsApplied || status.Version > version {
+				continue
+			}
+
+			err = m.apply(ctx, conn, status.Migration, upSuffix)
+			if err != nil {
+				return err
+			}
+
+			result = append(result, status.Migration)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// Down rolls back last applied migration. Last applied migration resolved under advisory lock...
+func (m *Migrator) Down(ctx context.Context) ([]*Migration, error) {
+	var result []*Migration
+
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		statuses, err := m.lockedStatuses(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0; i-- {
+			if statuses[i].IsApplied {
+				result, err = m.downTo(ctx, conn, statuses, statuses[i].Version-1)
+
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// DownTo rolls back applied migrations with version greater than target version, in reverse order...
+func (m *Migrator) DownTo(ctx context.Context, version int64) ([]*Migration, error) {
+	var result []*Migration
+
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		statuses, err := m.lockedStatuses(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		result, err = m.downTo(ctx, conn, statuses, version)
+
+		return err
+	})
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (m *Migrator) lockedStatuses(ctx context.Context, conn *sqlx.Conn) ([]*MigrationStatus, error) {
+	applied, err := m.appliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.status(applied), nil
+}
+
+func (m *Migrator) downTo(ctx context.Context,
+	conn *sqlx.Conn,
+	statuses []*MigrationStatus,
+	version int64,
+) ([]*Migration, error) {
+	var result []*Migration
+
+	for i := len(statuses) - 1; i >= 0; i-- {
+		status := statuses[i]
+		if !status.IsApplied || status.Version <= version {
+			continue
+		}
+
+		if status.Migration == nil {
+			return result, fmt.Errorf("%w: %d_%s", ErrMissingMigrationFile, status.Version, status.Name)
+		}
+
+		if !status.Migration.HasDown() {
+			return result, fmt.Errorf("%w: %s", ErrMissingDownMigration, status.Migration.String())
+		}
+
+		err := m.apply(ctx, conn, status.Migration, downSuffix)
+		if err != nil {
+			return result, err
+		}
+
+		result = append(result, status.Migration)
+	}
+
+	return result, nil
+}
+
+// apply executes migration script and records applied version.
+// Script executed in transaction, except scripts with NoTransactionDirective...
+func (m *Migrator) apply(ctx context.Context, conn *sqlx.Conn, migration *Migration, direction string) error {
+	script, noTransaction := migration.UpSQL, migration.UpNoTransaction
+	recordQuery := "INSERT INTO " + m.quotedTableName() +
+		" (version, name, checksum) VALUES ($1, $2, $3)"
+	recordArgs := []interface{}{migration.Version, migration.Name, migration.Checksum}
+
+	if direction == downSuffix {
+		script, noTransaction = migration.DownSQL, migration.DownNoTransaction
+		recordQuery = "DELETE FROM " + m.quotedTableName() + " WHERE version = $1"
+		recordArgs = recordArgs[:1]
+	}
+
+	logger := m.l.With(slog.Int64(MigrationVersionTag, migration.Version),
+		slog.String(MigrationNameTag, migration.Name),
+		slog.Bool(DryRunTag, m.isDryRun))
+
+	if m.isDryRun {
+		logger.Info("migration will be applied: " + direction)
+
+		return nil
+	}
+
+	startedAt := time.Now()
+
+	err := m.execScript(ctx, conn, script, noTransaction, recordQuery, recordArgs)
+	if err != nil {
+		return fmt.Errorf("unable to apply migration %s %s: %w", migration.String(), direction, err)
+	}
+
+	logger.Info("migration applied: "+direction, slog.Duration("duration", time.Since(startedAt)))
+
+	return nil
+}
+
+func (m *Migrator) execScript(ctx context.Context,
+	conn *sqlx.Conn,
+	script string,
+	noTransaction bool,
+	recordQuery string,
+	recordArgs []interface{},
+) error {
+	if noTransaction {
+		_, err := conn.ExecContext(ctx, script)
+		if err != nil {
+			return m.conn.ClassifyError(err)
+		}
+
+		_, err = conn.ExecContext(ctx, recordQuery, recordArgs...)
+		if err != nil {
+			return m.conn.ClassifyError(err)
+		}
+
+		return nil
+	}
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return m.conn.ClassifyError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, recordQuery, recordArgs...)
+	}
+
+	if err != nil {
+		_ = tx.Rollback()
+
+		return m.conn.ClassifyError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return m.conn.ClassifyError(err)
+	}
+
+	return nil
+}
+
+// withLock runs function on dedicated connection under advisory lock, so only one replica migrates database...
+func (m *Migrator) withLock(ctx context.Context, lockedFunc func(conn *sqlx.Conn) error) error {
+	conn, err := m.conn.Dbx.Connx(ctx)
+	if err != nil {
+		return m.conn.ClassifyError(err)
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", m.lockID)
+	if err != nil {
+		return m.conn.ClassifyError(err)
+	}
+
+	defer func() {
+		// unlock with background context - lock must be released even if ctx cancelled
+		_, unlockErr := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", m.lockID)
+		if unlockErr != nil {
+			m.l.Warn("unable to release migrations advisory lock", slog.Any(postgres.ErrorTag, unlockErr))
+		}
+	}()
+
+	if !m.isDryRun {
+		_, err = conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+m.quotedTableName()+` (
+			version    BIGINT      PRIMARY KEY,
+			name       TEXT        NOT NULL,
+			checksum   TEXT        NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`)
+		if err != nil {
+			return m.conn.ClassifyError(err)
+		}
+	}
+
+	return lockedFunc(conn)
+}
+
+func (m *Migrator) appliedMigrations(ctx context.Context, stmt sqlx.QueryerContext) ([]*appliedMigration, error) {
+	var isTableExists bool
+
+	err := sqlx.GetContext(ctx, stmt, &isTableExists, "SELECT to_regclass($1) IS NOT NULL", m.quotedTableName())
+	if err != nil {
+		return nil, m.conn.ClassifyError(err)
+	}
+
+	if !isTableExists {
+		return nil, nil
+	}
+
+	var applied []*appliedMigration
+
+	err = sqlx.SelectContext(ctx, stmt, &applied, "SELECT version, name, checksum, applied_at FROM "+
+		m.quotedTableName()+" ORDER BY version")
+	if err != nil {
+		return nil, m.conn.ClassifyError(err)
+	}
+
+	return applied, nil
+}
+
+// status merges loaded and applied migrations into list of statuses, sorted by version...
+func (m *Migrator) status(applied []*appliedMigration) []*MigrationStatus {
+	statuses := make([]*MigrationStatus, 0, len(m.migrations)+len(applied))
+	appliedIdx := 0
+
+	for _, migration := range m.migrations {
+		for appliedIdx < len(applied) && applied[appliedIdx].Version < migration.Version {
+			statuses = append(statuses, newAppliedStatus(applied[appliedIdx], nil))
+			appliedIdx++
+		}
+
+		if appliedIdx < len(applied) && applied[appliedIdx].Version == migration.Version {
+			statuses = append(statuses, newAppliedStatus(applied[appliedIdx], migration))
+			appliedIdx++
+
+			continue
+		}
+
+		statuses = append(statuses, &MigrationStatus{
+			Version:            migration.Version,
+			Name:               migration.Name,
+			Migration:          migration,
+			IsApplied:          false,
+			AppliedAt:          time.Time{},
+			IsChecksumMismatch: false,
+		})
+	}
+
+	for ; appliedIdx < len(applied); appliedIdx++ {
+		statuses = append(statuses, newAppliedStatus(applied[appliedIdx], nil))
+	}
+
+	return statuses
+}
+
+func (m *Migrator) quotedTableName() string {
+	parts := strings.Split(m.tableName, ".")
+
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func newAppliedStatus(applied *appliedMigration, migration *Migration) *MigrationStatus {
+	return &MigrationStatus{
+		Version:            applied.Version,
+		Name:               applied.Name,
+		Migration:          migration,
+		IsApplied:          true,
+		AppliedAt:          applied.AppliedAt,
+		IsChecksumMismatch: migration != nil && migration.Checksum != applied.Checksum,
+	}
+}
+
+func verifyChecksums(statuses []*MigrationStatus) error {
+	for _, status := range statuses {
+		if status.IsChecksumMismatch {
+			return fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, status.Version, status.Name)
+		}
+	}
+
+	return nil
+}
+
+func verifyStatuses(statuses []*MigrationStatus) error {
+	errs := make([]error, 0)
+
+	for _, status := range statuses {
+		switch {
+		case status.IsApplied && status.Migration == nil:
+			errs = append(errs, fmt.Errorf("%w: %d_%s", ErrMissingMigrationFile, status.Version, status.Name))
+		case status.IsChecksumMismatch:
+			errs = append(errs, fmt.Errorf("%w: %d_%s", ErrChecksumMismatch, status.Version, status.Name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// NewMigrator loads migrations from file system, e.g. embed.FS...
+func NewMigrator(conn *postgres.Connection, fsys fs.FS, opts ...Option) (*Migrator, error) {
+	migrator := &Migrator{
+		l:          conn.Logger().With(slog.String("component", "migrations")),
+		conn:       conn,
+		dir:        ".",
+		migrations: nil,
+		tableName:  DefaultTableName,
+		lockID:     DefaultLockID,
+		isDryRun:   false,
+	}
+
+	for _, opt := range opts {
+		opt(migrator)
+	}
+
+	migrations, err := Load(fsys, migrator.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator.migrations = migrations
+
+	return migrator, nil
+}
@@ -0,0 +1,475 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrNilNumeric               = errors.New("numeric value is nil")
+	ErrNullNumeric              = errors.New("unable to scan NULL into not nullable numeric type")
+	ErrInvalidNumeric           = errors.New("invalid numeric value")
+	ErrNonFiniteNumeric         = errors.New("NaN and infinity numeric values not supported")
+	ErrFractionalNumeric        = errors.New("fractional value can not be stored in integer numeric type")
+	ErrNumericOverflow          = errors.New("numeric value overflows target type")
+	ErrNumericPrecisionLoss     = errors.New("numeric value can not be rescaled without precision loss")
+	ErrUnsupportedNumericSource = errors.New("unsupported source type of numeric value, float values not allowed")
+)
+
+// BigInt is the arbitrary-precision integer, stored in NUMERIC(78,0) columns.
+// Fractional values and float sources rejected, never truncated...
+type BigInt struct {
+	Int *big.Int
+}
+
+// NewBigInt ....
+func NewBigInt(value *big.Int) BigInt {
+	return BigInt{Int: value}
+}
+
+func (b *BigInt) Scan(src interface{}) error {
+	if src == nil {
+		return ErrNullNumeric
+	}
+
+	value, err := parseBigInt(src)
+	if err != nil {
+		return err
+	}
+
+	b.Int = value
+
+	return nil
+}
+
+func (b BigInt) Value() (driver.Value, error) {
+	if b.Int == nil {
+		return nil, ErrNilNumeric
+	}
+
+	return b.Int.String(), nil
+}
+
+// Int64 returns value as int64, ErrNumericOverflow returned if value out of int64 range...
+func (b BigInt) Int64() (int64, error) {
+	if b.Int == nil {
+		return 0, ErrNilNumeric
+	}
+
+	if !b.Int.IsInt64() {
+		return 0, fmt.Errorf("%w: %s", ErrNumericOverflow, b.Int.String())
+	}
+
+	return b.Int.Int64(), nil
+}
+
+// Uint64 returns value as uint64, ErrNumericOverflow returned if value out of uint64 range...
+func (b BigInt) Uint64() (uint64, error) {
+	if b.Int == nil {
+		return 0, ErrNilNumeric
+	}
+
+	if !b.Int.IsUint64() {
+		return 0, fmt.Errorf("%w: %s", ErrNumericOverflow, b.Int.String())
+	}
+
+	return b.Int.Uint64(), nil
+}
+
+func (b BigInt) String() string {
+	if b.Int == nil {
+		return "<nil>"
+	}
+
+	return b.Int.String()
+}
+
+// NullBigInt is the nullable BigInt...
+type NullBigInt struct {
+	Int   *big.Int
+	Valid bool
+}
+
+func (n *NullBigInt) Scan(src interface{}) error {
+	if src == nil {
+		n.Int, n.Valid = nil, false
+
+		return nil
+	}
+
+	value, err := parseBigInt(src)
+	if err != nil {
+		return err
+	}
+
+	n.Int, n.Valid = value, true
+
+	return nil
+}
+
+func (n NullBigInt) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+
+	if n.Int == nil {
+		return nil, ErrNilNumeric
+	}
+
+	return n.Int.String(), nil
+}
+
+// Decimal is the fixed-scale decimal number - Unscaled * 10^(-Scale), stored in NUMERIC(p,s) columns.
+// Decimal never converted to float...
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    int32
+}
+
+// NewDecimal ....
+func NewDecimal(unscaled *big.Int, scale int32) Decimal {
+	return Decimal{Unscaled: unscaled, Scale: scale}
+}
+
+// ParseDecimal parses decimal string, e.g. "-12.3400". Scale is the count of fractional digits...
+func ParseDecimal(value string) (Decimal, error) {
+	return parseDecimal(value)
+}
+
+func (d *Decimal) Scan(src interface{}) error {
+	if src == nil {
+		return ErrNullNumeric
+	}
+
+	value, err := decimalFromSource(src)
+	if err != nil {
+		return err
+	}
+
+	*d = value
+
+	return nil
+}
+
+func (d Decimal) Value() (driver.Value, error) {
+	if d.Unscaled == nil {
+		return nil, ErrNilNumeric
+	}
+
+	return d.String(), nil
+}
+
+// Rescale returns decimal with new scale. ErrNumericPrecisionLoss returned if non-zero digits must be dropped...
+func (d Decimal) Rescale(scale int32) (Decimal, error) {
+	if d.Unscaled == nil {
+		return Decimal{}, ErrNilNumeric
+	}
+
+	if scale >= d.Scale {
+		multiplier := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-d.Scale)), nil) //nolint:mnd // decimal base
+
+		return Decimal{Unscaled: new(big.Int).Mul(d.Unscaled, multiplier), Scale: scale}, nil
+	}
+
+	divisor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(d.Scale-scale)), nil) //nolint:mnd // decimal base
+	quotient, remainder := new(big.Int).QuoRem(d.Unscaled, divisor, new(big.Int))
+
+	if remainder.Sign() != 0 {
+		return Decimal{}, fmt.Errorf("%w: %s to scale %d", ErrNumericPrecisionLoss, d.String(), scale)
+	}
+
+	return Decimal{Unscaled: quotient, Scale: scale}, nil
+}
+
+// BigInt returns integer value of decimal. ErrFractionalNumeric returned if decimal has non-zero fraction...
+func (d Decimal) BigInt() (*big.Int, error) {
+	integer, err := d.Rescale(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrFractionalNumeric, d.String())
+	}
+
+	return integer.Unscaled, nil
+}
+
+func (d Decimal) String() string {
+	if d.Unscaled == nil {
+		return "<nil>"
+	}
+
+	digits := new(big.Int).Abs(d.Unscaled).String()
+	sign := ""
+
+	if d.Unscaled.Sign() < 0 {
+		sign = "-"
+	}
+
+	if d.Scale <= 0 {
+		return sign + digits + strings.Repeat("0", int(-d.Scale))
+	}
+
+	scale := int(d.Scale)
+	if len(digits) <= scale {
+		digits = strings.Repeat("0", scale-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-scale] + "." + digits[len(digits)-scale:]
+}
+
+// NullDecimal is the nullable Decimal...
+type NullDecimal struct {
+	Decimal Decimal
+	Valid   bool
+}
+
+func (n *NullDecimal) Scan(src interface{}) error {
+	if src == nil {
+		n.Decimal, n.Valid = Decimal{}, false
+
+		return nil
+	}
+
+	value, err := decimalFromSource(src)
+	if err != nil {
+		return err
+	}
+
+	n.Decimal, n.Valid = value, true
+
+	return nil
+}
+
+func (n NullDecimal) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+
+	return n.Decimal.Value()
+}
+
+// BigIntArray is the NUMERIC[] array of BigInt values. NULL elements rejected...
+type BigIntArray []BigInt
+
+func (a *BigIntArray) Scan(src interface{}) error {
+	if src == nil {
+		*a = nil
+
+		return nil
+	}
+
+	elements, err := scanArrayElements(src)
+	if err != nil {
+		return err
+	}
+
+	result := make(BigIntArray, len(elements))
+
+	for i, element := range elements {
+		if element == nil {
+			return fmt.Errorf("%w: index %d", ErrNullArrayElement, i)
+		}
+
+		result[i].Int, err = parseBigInt(string(element))
+		if err != nil {
+			return err
+		}
+	}
+
+	*a = result
+
+	return nil
+}
+
+func (a BigIntArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	elements := make([]*string, len(a))
+
+	for i, value := range a {
+		if value.Int == nil {
+			return nil, ErrNilNumeric
+		}
+
+		text := value.Int.String()
+		elements[i] = &text
+	}
+
+	return formatArrayLiteral(elements), nil
+}
+
+// DecimalArray is the NUMERIC[] array of Decimal values. NULL elements rejected...
+type DecimalArray []Decimal
+
+func (a *DecimalArray) Scan(src interface{}) error {
+	if src == nil {
+		*a = nil
+
+		return nil
+	}
+
+	elements, err := scanArrayElements(src)
+	if err != nil {
+		return err
+	}
+
+	result := make(DecimalArray, len(elements))
+
+	for i, element := range elements {
+		if element == nil {
+			return fmt.Errorf("%w: index %d", ErrNullArrayElement, i)
+		}
+
+		result[i], err = parseDecimal(string(element))
+		if err != nil {
+			return err
+		}
+	}
+
+	*a = result
+
+	return nil
+}
+
+func (a DecimalArray) Value() (driver.Value, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	elements := make([]*string, len(a))
+
+	for i, value := range a {
+		if value.Unscaled == nil {
+			return nil, ErrNilNumeric
+		}
+
+		text := value.String()
+		elements[i] = &text
+	}
+
+	return formatArrayLiteral(elements), nil
+}
+
+func scanArrayElements(src interface{}) ([]arrayElement, error) {
+	srcBytes, err := arraySourceBytes(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseArrayLiteral(srcBytes)
+}
+
+// parseBigInt parses integer value from source. Fractional part allowed only if it contains zeros...
+func parseBigInt(src interface{}) (*big.Int, error) {
+	var text string
+
+	switch value := src.(type) {
+	case int64:
+		return big.NewInt(value), nil
+	case []byte:
+		text = string(value)
+	case string:
+		text = value
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedNumericSource, src)
+	}
+
+	if isNonFiniteNumeric(text) {
+		return nil, fmt.Errorf("%w: %s", ErrNonFiniteNumeric, text)
+	}
+
+	integerPart, fractionPart, hasFraction := strings.Cut(text, ".")
+	if hasFraction && strings.Trim(fractionPart, "0") != "" {
+		return nil, fmt.Errorf("%w: %s", ErrFractionalNumeric, text)
+	}
+
+	value, isParsed := new(big.Int).SetString(integerPart, 10) //nolint:mnd // decimal base
+	if !isParsed {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidNumeric, text)
+	}
+
+	return value, nil
+}
+
+func decimalFromSource(src interface{}) (Decimal, error) {
+	switch value := src.(type) {
+	case int64:
+		return Decimal{Unscaled: big.NewInt(value), Scale: 0}, nil
+	case []byte:
+		return parseDecimal(string(value))
+	case string:
+		return parseDecimal(value)
+	default:
+		return Decimal{}, fmt.Errorf("%w: %T", ErrUnsupportedNumericSource, src)
+	}
+}
+
+func parseDecimal(text string) (Decimal, error) {
+	if isNonFiniteNumeric(text) {
+		return Decimal{}, fmt.Errorf("%w: %s", ErrNonFiniteNumeric, text)
+	}
+
+	integerPart, fractionPart, _ := strings.Cut(text, ".")
+
+	digits := integerPart + fractionPart
+	if integerPart == "" || integerPart == "-" || integerPart == "+" ||
+		strings.ContainsAny(fractionPart, "+-") {
+		return Decimal{}, fmt.Errorf("%w: %q", ErrInvalidNumeric, text)
+	}
+
+	unscaled, isParsed := new(big.Int).SetString(digits, 10) //nolint:mnd // decimal base
+	if !isParsed {
+		return Decimal{}, fmt.Errorf("%w: %q", ErrInvalidNumeric, text)
+	}
+
+	if len(fractionPart) > math.MaxInt32 {
+		return Decimal{}, fmt.Errorf("%w: %q", ErrNumericOverflow, text)
+	}
+
+	return Decimal{Unscaled: unscaled, Scale: int32(len(fractionPart))}, nil
+}
+
+// isNonFiniteNumeric reports whether text is NaN or infinity, e.g. "NaN", "Infinity" or "-Infinity"...
+func isNonFiniteNumeric(text string) bool {
+	unsigned := strings.TrimLeft(text, "+-")
+
+	return strings.EqualFold(unsigned, "NaN") ||
+		strings.EqualFold(unsigned, "Infinity") ||
+		strings.EqualFold(unsigned, "inf")
+}
@@ -0,0 +1,206 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestDecimalRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name         string
+		src          interface{}
+		wantUnscaled string
+		wantScale    int32
+		wantValue    string
+	}{
+		{name: "integer", src: []byte("42"), wantUnscaled: "42", wantScale: 0, wantValue: "42"},
+		{name: "trailing zeros kept", src: []byte("-12.3400"), wantUnscaled: "-123400", wantScale: 4, wantValue: "-12.3400"},
+		{name: "leading zero fraction", src: "0.0001", wantUnscaled: "1", wantScale: 4, wantValue: "0.0001"},
+		{name: "negative below one", src: "-0.5", wantUnscaled: "-5", wantScale: 1, wantValue: "-0.5"},
+		{name: "int64 source", src: int64(-7), wantUnscaled: "-7", wantScale: 0, wantValue: "-7"},
+		{
+			name:         "beyond float64 precision",
+			src:          []byte("123456789012345678901234567890.123456789012345678"),
+			wantUnscaled: "123456789012345678901234567890123456789012345678",
+			wantScale:    18,
+			wantValue:    "123456789012345678901234567890.123456789012345678",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var value Decimal
+
+			err := value.Scan(testCase.src)
+			if err != nil {
+				t.Fatalf("Scan returned error: %v", err)
+			}
+
+			if value.Unscaled.String() != testCase.wantUnscaled || value.Scale != testCase.wantScale {
+				t.Errorf("scanned %s scale %d, want %s scale %d",
+					value.Unscaled, value.Scale, testCase.wantUnscaled, testCase.wantScale)
+			}
+
+			driverValue, err := value.Value()
+			if err != nil {
+				t.Fatalf("Value returned error: %v", err)
+			}
+
+			if driverValue != testCase.wantValue {
+				t.Errorf("Value = %v, want %s", driverValue, testCase.wantValue)
+			}
+		})
+	}
+}
+
+func TestNumericRejectsInvalidSources(t *testing.T) {
+	testCases := []struct {
+		name    string
+		src     interface{}
+		wantErr error
+	}{
+		{name: "NaN", src: []byte("NaN"), wantErr: ErrNonFiniteNumeric},
+		{name: "infinity", src: []byte("Infinity"), wantErr: ErrNonFiniteNumeric},
+		{name: "negative infinity", src: []byte("-Infinity"), wantErr: ErrNonFiniteNumeric},
+		{name: "float source", src: 1.5, wantErr: ErrUnsupportedNumericSource},
+		{name: "exponent", src: "1e5", wantErr: ErrInvalidNumeric},
+		{name: "sign only", src: "-", wantErr: ErrInvalidNumeric},
+		{name: "garbage", src: "12a", wantErr: ErrInvalidNumeric},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var decimal Decimal
+
+			err := decimal.Scan(testCase.src)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("Decimal.Scan error = %v, want %v", err, testCase.wantErr)
+			}
+
+			var integer BigInt
+
+			err = integer.Scan(testCase.src)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Errorf("BigInt.Scan error = %v, want %v", err, testCase.wantErr)
+			}
+		})
+	}
+}
+
+func TestBigIntScan(t *testing.T) {
+	testCases := []struct {
+		name    string
+		src     interface{}
+		want    string
+		wantErr error
+	}{
+		{name: "uint256 max", src: []byte("115792089237316195423570985008687907853269984665640564039457584007913129639935"),
+			want: "115792089237316195423570985008687907853269984665640564039457584007913129639935"},
+		{name: "zero fraction", src: []byte("10.000"), want: "10"},
+		{name: "negative", src: "-3", want: "-3"},
+		{name: "fraction rejected", src: []byte("10.5"), wantErr: ErrFractionalNumeric},
+		{name: "NULL rejected", src: nil, wantErr: ErrNullNumeric},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var value BigInt
+
+			err := value.Scan(testCase.src)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("Scan error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if testCase.wantErr == nil && value.Int.String() != testCase.want {
+				t.Errorf("Scan = %s, want %s", value.Int, testCase.want)
+			}
+		})
+	}
+}
+
+func TestDecimalRescale(t *testing.T) {
+	testCases := []struct {
+		name    string
+		value   string
+		scale   int32
+		want    string
+		wantErr error
+	}{
+		{name: "scale up", value: "1.5", scale: 4, want: "1.5000"},
+		{name: "drop zeros", value: "-2.5000", scale: 1, want: "-2.5"},
+		{name: "to integer", value: "100.00", scale: 0, want: "100"},
+		{name: "precision loss", value: "1.25", scale: 1, wantErr: ErrNumericPrecisionLoss},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			decimal, err := ParseDecimal(testCase.value)
+			if err != nil {
+				t.Fatalf("ParseDecimal returned error: %v", err)
+			}
+
+			rescaled, err := decimal.Rescale(testCase.scale)
+			if !errors.Is(err, testCase.wantErr) {
+				t.Fatalf("Rescale error = %v, want %v", err, testCase.wantErr)
+			}
+
+			if testCase.wantErr == nil && rescaled.String() != testCase.want {
+				t.Errorf("Rescale = %s, want %s", rescaled, testCase.want)
+			}
+		})
+	}
+}
+
+func TestNullNumericScanNull(t *testing.T) {
+	nullInt := NullBigInt{Int: big.NewInt(1), Valid: true}
+
+	err := nullInt.Scan(nil)
+	if err != nil || nullInt.Valid || nullInt.Int != nil {
+		t.Errorf("NullBigInt.Scan(nil) = %+v, %v", nullInt, err)
+	}
+
+	nullDecimal := NullDecimal{Decimal: NewDecimal(big.NewInt(1), 2), Valid: true}
+
+	err = nullDecimal.Scan(nil)
+	if err != nil || nullDecimal.Valid {
+		t.Errorf("NullDecimal.Scan(nil) = %+v, %v", nullDecimal, err)
+	}
+
+	value, err := nullDecimal.Value()
+	if err != nil || value != nil {
+		t.Errorf("NullDecimal.Value() = %v, %v, want nil", value, err)
+	}
+}
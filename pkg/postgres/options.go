@@ -0,0 +1,152 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"log/slog"
+)
+
+// DefaultConnectionName is the name of connection logger entry, used if WithName option not passed...
+const DefaultConnectionName = "lib-postgres"
+
+// LoggerNameTag is the name of logger attribute, which stores connection name, if logger set by WithLogger option...
+const LoggerNameTag = "logger"
+
+type connectionOptions struct {
+	logger       *slog.Logger
+	errFormatter errorFormatterService
+	driverName   string
+	name         string
+
+	hooks   []Hooks
+	metrics MetricsCollector
+	tracer  Tracer
+}
+
+// Option is the optional parameter of NewConnection and NewConnectionFromDSN functions...
+type Option func(options *connectionOptions)
+
+// WithLogger sets plain slog logger of connection, used instead of logger factory service...
+func WithLogger(logger *slog.Logger) Option {
+	return func(options *connectionOptions) {
+		options.logger = logger
+	}
+}
+
+// WithErrorFormatter sets error formatter service of connection. If error formatter not passed
+// neither by option nor by NewConnection argument - errors wrapped by standard fmt.Errorf function...
+func WithErrorFormatter(errFormatterSvc errorFormatterService) Option {
+	return func(options *connectionOptions) {
+		options.errFormatter = errFormatterSvc
+	}
+}
+
+// WithDriver sets name of registered database/sql driver, same with SetDriverName function.
+// Option has priority over driver name of config...
+func WithDriver(driverName string) Option {
+	return func(options *connectionOptions) {
+		options.driverName = driverName
+	}
+}
+
+// WithName sets connection name, used by logger entry, metrics and tracing spans. DefaultConnectionName by default...
+func WithName(name string) Option {
+	return func(options *connectionOptions) {
+		options.name = name
+	}
+}
+
+// WithHooks adds hooks, which called around every database operation. Can be passed several times...
+func WithHooks(hooks ...Hooks) Option {
+	return func(options *connectionOptions) {
+		options.hooks = append(options.hooks, hooks...)
+	}
+}
+
+// WithMetrics sets metrics collector of database operations...
+func WithMetrics(collector MetricsCollector) Option {
+	return func(options *connectionOptions) {
+		options.metrics = collector
+	}
+}
+
+// WithTracer sets tracer of database operations, every operation traced by separate span...
+func WithTracer(tracer Tracer) Option {
+	return func(options *connectionOptions) {
+		options.tracer = tracer
+	}
+}
+
+func newConnectionOptions(opts ...Option) *connectionOptions {
+	options := &connectionOptions{
+		logger:       nil,
+		errFormatter: nil,
+		driverName:   "",
+		name:         DefaultConnectionName,
+		hooks:        nil,
+		metrics:      nil,
+		tracer:       nil,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options
+}
+
+// instrumentation returns nil if neither hooks, metrics collector nor tracer passed.
+// Tracer hooks called first - span covers metrics and user hooks...
+func (o *connectionOptions) instrumentation(database string) *instrumentation {
+	if len(o.hooks) == 0 && o.metrics == nil && o.tracer == nil {
+		return nil
+	}
+
+	hooks := make([]Hooks, 0, len(o.hooks)+2) //nolint:mnd // tracer and metrics hooks
+
+	if o.tracer != nil {
+		hooks = append(hooks, tracerHooks(o.tracer))
+	}
+
+	if o.metrics != nil {
+		hooks = append(hooks, metricsHooks(o.metrics))
+	}
+
+	hooks = append(hooks, o.hooks...)
+
+	return &instrumentation{
+		connectionName: o.name,
+		database:       database,
+		hooks:          hooks,
+	}
+}
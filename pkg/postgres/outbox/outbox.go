@@ -0,0 +1,91 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrEmptyTopic = errors.New("outbox message topic is empty")
+
+// Outbox writes messages to outbox table in caller's transaction...
+type Outbox struct {
+	e errorFormatterService
+
+	conn dbConnection
+
+	insertQuery string
+}
+
+// Enqueue stores message in outbox table. Must be called inside contextual transaction,
+// so message will be published only if caller's transaction committed...
+func (o *Outbox) Enqueue(ctx context.Context, topic, key string, payload []byte) error {
+	if topic == "" {
+		return o.e.ErrorOnly(ErrEmptyTopic)
+	}
+
+	err := o.conn.MustWithTransaction(ctx, func(stmt *sqlx.Tx) error {
+		_, execErr := stmt.ExecContext(ctx, o.insertQuery, topic, key, payload)
+		if execErr != nil {
+			return o.e.ErrorOnly(execErr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return o.e.ErrorNoWrap(err)
+	}
+
+	return nil
+}
+
+// NewOutbox ....
+func NewOutbox(errFormatterSvc errorFormatterService,
+	conn dbConnection,
+	tableName string,
+) *Outbox {
+	if tableName == "" {
+		tableName = DefaultTableName
+	}
+
+	return &Outbox{
+		e:    errFormatterSvc,
+		conn: conn,
+		insertQuery: fmt.Sprintf(`INSERT INTO %s (topic, message_key, payload)
+			VALUES ($1, $2, $3)`, tableName),
+	}
+}
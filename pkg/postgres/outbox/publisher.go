@@ -0,0 +1,99 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package outbox
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryPublisher is in-memory Publisher implementation for unit tests...
+type MemoryPublisher struct {
+	mu sync.Mutex
+
+	published []*Message
+	// failFunc returns error for messages which must be failed
+	failFunc func(msg *Message) error
+}
+
+func (p *MemoryPublisher) Publish(_ context.Context, msg *Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.failFunc != nil {
+		err := p.failFunc(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	msgCopy := *msg
+	msgCopy.Payload = append([]byte(nil), msg.Payload...)
+
+	p.published = append(p.published, &msgCopy)
+
+	return nil
+}
+
+// Published returns copy of all successfully published messages...
+func (p *MemoryPublisher) Published() []*Message {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*Message(nil), p.published...)
+}
+
+// FailWith sets function which decides which messages must be failed on publish...
+func (p *MemoryPublisher) FailWith(failFunc func(msg *Message) error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failFunc = failFunc
+}
+
+// Reset removes all published messages...
+func (p *MemoryPublisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.published = nil
+}
+
+// NewMemoryPublisher ....
+func NewMemoryPublisher() *MemoryPublisher {
+	return &MemoryPublisher{
+		mu:        sync.Mutex{},
+		published: nil,
+		failFunc:  nil,
+	}
+}
@@ -0,0 +1,249 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultBatchSize      = 100
+	defaultPollInterval   = time.Second
+	defaultMaxAttempts    = 10
+	defaultBaseRetryDelay = time.Second
+	defaultMaxRetryDelay  = time.Minute * 10
+
+	MessageIDTag      = "outbox_message_id"
+	MessageTopicTag   = "outbox_message_topic"
+	MessageAttemptTag = "outbox_message_attempt"
+)
+
+// RelayConfig ....
+type RelayConfig struct {
+	// TableName is the name of outbox table. DefaultTableName used if empty
+	TableName string
+	// BatchSize is the maximum count of messages claimed by one relay iteration
+	BatchSize uint16
+	// PollInterval is the delay between relay iterations when outbox table is drained
+	PollInterval time.Duration
+	// MaxAttempts is the count of failed publish attempts after which message moved to dead-letter state
+	MaxAttempts uint32
+	// BaseRetryDelay is the delay before first retry, each next retry delay doubled
+	BaseRetryDelay time.Duration
+	// MaxRetryDelay is the upper bound of retry delay
+	MaxRetryDelay time.Duration
+	// DeleteOnSuccess - delete published messages instead of marking them as published
+	DeleteOnSuccess bool
+}
+
+// Relay claims pending outbox messages and hands them to Publisher...
+type Relay struct {
+	l *slog.Logger
+	e errorFormatterService
+
+	conn      dbConnection
+	publisher Publisher
+
+	batchSize       uint16
+	pollInterval    time.Duration
+	maxAttempts     uint32
+	baseRetryDelay  time.Duration
+	maxRetryDelay   time.Duration
+	deleteOnSuccess bool
+
+	claimQuery     string
+	publishedQuery string
+	failedQuery    string
+}
+
+// Run processes outbox messages until context cancelled...
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		processed, err := r.ProcessBatch(ctx)
+		if err != nil {
+			r.l.Error("unable to process outbox batch", slog.Any(postgres.ErrorTag, err))
+		}
+
+		if err == nil && processed == int(r.batchSize) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.pollInterval):
+		}
+	}
+}
+
+// ProcessBatch claims one batch of pending messages with FOR UPDATE SKIP LOCKED,
+// publishes them and marks as published, scheduled to retry or dead-lettered...
+func (r *Relay) ProcessBatch(ctx context.Context) (int, error) {
+	processed := 0
+
+	err := r.conn.BeginReadCommittedTxRollbackOnError(ctx, func(txStmtCtx context.Context) error {
+		return r.conn.MustWithTransaction(txStmtCtx, func(stmt *sqlx.Tx) error {
+			var messages []*Message
+
+			selectErr := stmt.SelectContext(txStmtCtx, &messages, r.claimQuery, r.batchSize)
+			if selectErr != nil {
+				return r.e.ErrorOnly(selectErr)
+			}
+
+			processed = len(messages)
+
+			return r.publishClaimed(txStmtCtx, stmt, messages)
+		})
+	})
+	if err != nil {
+		return 0, r.e.ErrorNoWrap(err)
+	}
+
+	return processed, nil
+}
+
+func (r *Relay) publishClaimed(ctx context.Context, stmt *sqlx.Tx, messages []*Message) error {
+	publishedIDs := make([]int64, 0, len(messages))
+
+	for _, msg := range messages {
+		publishErr := r.publisher.Publish(ctx, msg)
+		if publishErr == nil {
+			publishedIDs = append(publishedIDs, msg.ID)
+
+			continue
+		}
+
+		attempt := msg.Attempts + 1
+		deadLetter := attempt >= r.maxAttempts
+
+		if deadLetter {
+			r.l.Warn("outbox message moved to dead-letter state",
+				slog.Int64(MessageIDTag, msg.ID),
+				slog.String(MessageTopicTag, msg.Topic),
+				slog.Any(MessageAttemptTag, attempt),
+				slog.Any(postgres.ErrorTag, publishErr))
+		}
+
+		_, execErr := stmt.ExecContext(ctx, r.failedQuery, msg.ID, publishErr.Error(),
+			r.retryDelay(attempt).Seconds(), deadLetter)
+		if execErr != nil {
+			return r.e.ErrorOnly(execErr)
+		}
+	}
+
+	if len(publishedIDs) == 0 {
+		return nil
+	}
+
+	_, err := stmt.ExecContext(ctx, r.publishedQuery, pq.Array(publishedIDs))
+	if err != nil {
+		return r.e.ErrorOnly(err)
+	}
+
+	return nil
+}
+
+func (r *Relay) retryDelay(attempt uint32) time.Duration {
+	delay := r.baseRetryDelay
+
+	for i := uint32(1); i < attempt; i++ {
+		delay *= 2
+
+		if delay >= r.maxRetryDelay {
+			return r.maxRetryDelay
+		}
+	}
+
+	return delay
+}
+
+// NewRelay ....
+func NewRelay(logFactorySvc loggerService,
+	errFormatterSvc errorFormatterService,
+	conn dbConnection,
+	publisher Publisher,
+	cfg RelayConfig,
+) *Relay {
+	tableName := cfg.TableName
+	if tableName == "" {
+		tableName = DefaultTableName
+	}
+
+	relay := &Relay{
+		l:               logFactorySvc.NewSlogNamedLoggerEntry("lib-postgres-outbox-relay"),
+		e:               errFormatterSvc,
+		conn:            conn,
+		publisher:       publisher,
+		batchSize:       valueOrDefault(cfg.BatchSize, defaultBatchSize),
+		pollInterval:    valueOrDefault(cfg.PollInterval, defaultPollInterval),
+		maxAttempts:     valueOrDefault(cfg.MaxAttempts, defaultMaxAttempts),
+		baseRetryDelay:  valueOrDefault(cfg.BaseRetryDelay, defaultBaseRetryDelay),
+		maxRetryDelay:   valueOrDefault(cfg.MaxRetryDelay, defaultMaxRetryDelay),
+		deleteOnSuccess: cfg.DeleteOnSuccess,
+		claimQuery: fmt.Sprintf(`SELECT id, topic, message_key, payload, attempts, last_error, created_at
+			FROM %s
+			WHERE published_at IS NULL AND dead_lettered_at IS NULL AND available_at <= now()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED`, tableName),
+		publishedQuery: fmt.Sprintf(`UPDATE %s SET published_at = now() WHERE id = ANY($1)`, tableName),
+		failedQuery: fmt.Sprintf(`UPDATE %s SET attempts = attempts + 1,
+				last_error = $2,
+				available_at = now() + make_interval(secs => $3),
+				dead_lettered_at = CASE WHEN $4::BOOLEAN THEN now() ELSE NULL END
+			WHERE id = $1`, tableName),
+	}
+
+	if relay.deleteOnSuccess {
+		relay.publishedQuery = fmt.Sprintf(`DELETE FROM %s WHERE id = ANY($1)`, tableName)
+	}
+
+	return relay
+}
+
+func valueOrDefault[T comparable](value, defaultValue T) T {
+	var zero T
+	if value == zero {
+		return defaultValue
+	}
+
+	return value
+}
@@ -0,0 +1,398 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+)
+
+var errPublishFailed = errors.New("broker unavailable")
+
+type testLoggerService struct{}
+
+func (testLoggerService) NewSlogNamedLoggerEntry(_ string, _ ...any) *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+type testErrorFormatter struct{}
+
+func (testErrorFormatter) ErrorOnly(err error, _ ...string) error {
+	return err
+}
+
+func (testErrorFormatter) ErrorNoWrap(err error) error {
+	return err
+}
+
+func (testErrorFormatter) Errorf(err error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))
+}
+
+// stubStatement is the statement executed by relay through stub driver...
+type stubStatement struct {
+	query string
+	args  []driver.Value
+}
+
+// stubDatabase is the database/sql driver, which serves claimed outbox rows and records executed statements...
+type stubDatabase struct {
+	mu sync.Mutex
+
+	claimRows  [][]driver.Value
+	statements []stubStatement
+}
+
+func (d *stubDatabase) Open(_ string) (driver.Conn, error) {
+	return &stubConn{db: d}, nil
+}
+
+func (d *stubDatabase) execs() []stubStatement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var execs []stubStatement
+
+	for _, statement := range d.statements {
+		if strings.HasPrefix(strings.TrimSpace(statement.query), "UPDATE") {
+			execs = append(execs, statement)
+		}
+	}
+
+	return execs
+}
+
+type stubConn struct {
+	db *stubDatabase
+}
+
+func (c *stubConn) Prepare(_ string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements not supported by stub driver")
+}
+
+func (c *stubConn) Close() error {
+	return nil
+}
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	return stubTx{}, nil
+}
+
+func (c *stubConn) BeginTx(_ context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	return stubTx{}, nil
+}
+
+func (c *stubConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.statements = append(c.db.statements, stubStatement{query: query, args: plainValues(args)})
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *stubConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.statements = append(c.db.statements, stubStatement{query: query, args: plainValues(args)})
+
+	switch {
+	case query == "SELECT 1":
+		return &stubRows{columns: []string{"?column?"}, rows: [][]driver.Value{{int64(1)}}}, nil
+	case strings.Contains(query, "FOR UPDATE SKIP LOCKED"):
+		rows := c.db.claimRows
+		c.db.claimRows = nil
+
+		return &stubRows{
+			columns: []string{"id", "topic", "message_key", "payload", "attempts", "last_error", "created_at"},
+			rows:    rows,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unexpected query: %s", query)
+	}
+}
+
+type stubTx struct{}
+
+func (stubTx) Commit() error {
+	return nil
+}
+
+func (stubTx) Rollback() error {
+	return nil
+}
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string {
+	return r.columns
+}
+
+func (r *stubRows) Close() error {
+	return nil
+}
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func plainValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	return values
+}
+
+func claimRow(id int64, attempts int64) []driver.Value {
+	return []driver.Value{id, "wallets", fmt.Sprintf("key-%d", id), []byte(`{}`), attempts, nil, time.Now()}
+}
+
+func newTestRelay(t *testing.T, db *stubDatabase, publisher Publisher, cfg RelayConfig) *Relay {
+	t.Helper()
+
+	driverName := "outbox-relay-" + t.Name()
+	sql.Register(driverName, db)
+
+	conn, err := postgres.NewConnectionFromDSN("host=localhost dbname=outbox", postgres.WithDriver(driverName))
+	if err != nil {
+		t.Fatalf("unable to create connection: %v", err)
+	}
+
+	_, err = conn.Connect()
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return NewRelay(testLoggerService{}, testErrorFormatter{}, conn, publisher, cfg)
+}
+
+func TestRelayProcessBatchPublishesInClaimOrder(t *testing.T) {
+	db := &stubDatabase{claimRows: [][]driver.Value{claimRow(3, 0), claimRow(7, 0), claimRow(9, 0)}}
+	publisher := NewMemoryPublisher()
+	relay := newTestRelay(t, db, publisher, RelayConfig{BatchSize: 3})
+
+	processed, err := relay.ProcessBatch(context.Background())
+	if err != nil {
+		t.Fatalf("ProcessBatch returned error: %v", err)
+	}
+
+	if processed != 3 {
+		t.Fatalf("processed = %d, want 3", processed)
+	}
+
+	published := publisher.Published()
+	if len(published) != 3 {
+		t.Fatalf("published %d messages, want 3", len(published))
+	}
+
+	for i, wantID := range []int64{3, 7, 9} {
+		if published[i].ID != wantID {
+			t.Errorf("published[%d].ID = %d, want %d", i, published[i].ID, wantID)
+		}
+	}
+
+	execs := db.execs()
+	if len(execs) != 1 {
+		t.Fatalf("executed %d update statements, want 1: %v", len(execs), execs)
+	}
+
+	if !strings.Contains(execs[0].query, "published_at = now()") {
+		t.Errorf("unexpected published statement: %s", execs[0].query)
+	}
+
+	if execs[0].args[0] != "{3,7,9}" {
+		t.Errorf("published ids = %v, want {3,7,9}", execs[0].args[0])
+	}
+}
+
+func TestRelayProcessBatchClaimsOrderedBatch(t *testing.T) {
+	db := &stubDatabase{}
+	relay := newTestRelay(t, db, NewMemoryPublisher(), RelayConfig{BatchSize: 42})
+
+	processed, err := relay.ProcessBatch(context.Background())
+	if err != nil {
+		t.Fatalf("ProcessBatch returned error: %v", err)
+	}
+
+	if processed != 0 {
+		t.Fatalf("processed = %d, want 0", processed)
+	}
+
+	var claim *stubStatement
+
+	for i := range db.statements {
+		if strings.Contains(db.statements[i].query, "FOR UPDATE SKIP LOCKED") {
+			claim = &db.statements[i]
+		}
+	}
+
+	if claim == nil {
+		t.Fatal("claim query not executed")
+	}
+
+	if !strings.Contains(claim.query, "ORDER BY id") {
+		t.Errorf("claim query is not ordered by id: %s", claim.query)
+	}
+
+	if claim.args[0] != int64(42) {
+		t.Errorf("claim limit = %v, want 42", claim.args[0])
+	}
+
+	if len(db.execs()) != 0 {
+		t.Errorf("update statements executed for empty batch: %v", db.execs())
+	}
+}
+
+func TestRelayProcessBatchRetriesFailedMessages(t *testing.T) {
+	db := &stubDatabase{claimRows: [][]driver.Value{claimRow(1, 0), claimRow(2, 1), claimRow(3, 4)}}
+	publisher := NewMemoryPublisher()
+	publisher.FailWith(func(msg *Message) error {
+		if msg.ID == 2 || msg.ID == 3 {
+			return errPublishFailed
+		}
+
+		return nil
+	})
+
+	relay := newTestRelay(t, db, publisher, RelayConfig{
+		BatchSize:      10,
+		MaxAttempts:    5,
+		BaseRetryDelay: time.Second,
+		MaxRetryDelay:  time.Minute,
+	})
+
+	_, err := relay.ProcessBatch(context.Background())
+	if err != nil {
+		t.Fatalf("ProcessBatch returned error: %v", err)
+	}
+
+	published := publisher.Published()
+	if len(published) != 1 || published[0].ID != 1 {
+		t.Fatalf("published = %v, want only message 1", published)
+	}
+
+	execs := db.execs()
+	if len(execs) != 3 {
+		t.Fatalf("executed %d update statements, want 3: %v", len(execs), execs)
+	}
+
+	testCases := []struct {
+		id         int64
+		delay      float64
+		deadLetter bool
+	}{
+		// second attempt of message 2 - delay doubled
+		{id: 2, delay: 2, deadLetter: false},
+		// fifth attempt of message 3 - max attempts reached
+		{id: 3, delay: 16, deadLetter: true},
+	}
+
+	for i, testCase := range testCases {
+		failed := execs[i]
+
+		if !strings.Contains(failed.query, "attempts = attempts + 1") {
+			t.Fatalf("unexpected failed statement: %s", failed.query)
+		}
+
+		if failed.args[0] != testCase.id {
+			t.Errorf("failed message id = %v, want %d", failed.args[0], testCase.id)
+		}
+
+		if failed.args[1] != errPublishFailed.Error() {
+			t.Errorf("last error = %v, want %q", failed.args[1], errPublishFailed.Error())
+		}
+
+		if failed.args[2] != testCase.delay {
+			t.Errorf("retry delay of message %d = %v, want %v", testCase.id, failed.args[2], testCase.delay)
+		}
+
+		if failed.args[3] != testCase.deadLetter {
+			t.Errorf("dead-letter flag of message %d = %v, want %v", testCase.id, failed.args[3], testCase.deadLetter)
+		}
+	}
+
+	if execs[2].args[0] != "{1}" {
+		t.Errorf("published ids = %v, want {1}", execs[2].args[0])
+	}
+}
+
+func TestRelayRetryDelay(t *testing.T) {
+	relay := &Relay{baseRetryDelay: time.Second, maxRetryDelay: time.Second * 10} //nolint:exhaustruct // only delays used
+
+	testCases := []struct {
+		attempt uint32
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: time.Second * 2},
+		{attempt: 4, want: time.Second * 8},
+		{attempt: 5, want: time.Second * 10},
+		{attempt: 30, want: time.Second * 10},
+	}
+
+	for _, testCase := range testCases {
+		got := relay.retryDelay(testCase.attempt)
+		if got != testCase.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", testCase.attempt, got, testCase.want)
+		}
+	}
+}
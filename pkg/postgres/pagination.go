@@ -0,0 +1,398 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrInvalidCursor          = errors.New("invalid pagination cursor")
+	ErrEmptySortKeys          = errors.New("empty list of pagination sort keys")
+	ErrEmptyCursorSecret      = errors.New("empty pagination cursor secret")
+	ErrInvalidPageSize        = errors.New("pagination page size must be greater than zero")
+	ErrSortKeyColumnNotMapped = errors.New("pagination sort key column not found in struct db tags")
+)
+
+// SortDirection ....
+type SortDirection uint8
+
+const (
+	SortAsc SortDirection = iota
+	SortDesc
+)
+
+const (
+	cursorDirectionNext = "next"
+	cursorDirectionPrev = "prev"
+)
+
+// SortKey is the ordering column of keyset pagination.
+// Column must be name of base query output column and must not contain NULL values...
+type SortKey struct {
+	Column    string
+	Direction SortDirection
+}
+
+// Page ....
+type Page[T any] struct {
+	Items []T
+	// NextCursor is the cursor of next page, empty if current page is the last one
+	NextCursor string
+	// PrevCursor is the cursor of previous page, empty if current page is the first one
+	PrevCursor string
+}
+
+type cursorPayload struct {
+	Direction string            `json:"d"`
+	Values    []json.RawMessage `json:"v"`
+	// ByteValues - indexes of []byte values, encoded as base64 strings, so they decoded back to []byte
+	ByteValues []int `json:"b,omitempty"`
+}
+
+// Paginator is the keyset pagination helper. Last sort key is the tie-breaker and must be unique,
+// for example primary key. Cursor tokens signed by HMAC-SHA256, so clients can not tamper them...
+type Paginator struct {
+	sortKeys []SortKey
+	secret   []byte
+	pageSize int
+}
+
+// Paginate fetches page of base query rows after or before cursor. Empty cursor means first page.
+// Base query must not contain ORDER BY and LIMIT clauses, bind parameters must be in $n format.
+// Query executed in contextual transaction if present, otherwise with connection pool...
+func Paginate[T any](ctx context.Context,
+	conn *Connection,
+	paginator *Paginator,
+	cursor string,
+	query string,
+	args ...interface{},
+) (*Page[T], error) {
+	mapping, err := newStructMapping(conn.Dbx.Mapper, reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, conn.e.ErrorOnly(err)
+	}
+
+	for _, key := range paginator.sortKeys {
+		_, isMapped := mapping.field(key.Column)
+		if !isMapped {
+			return nil, conn.e.ErrorOnly(fmt.Errorf("%w: %s", ErrSortKeyColumnNotMapped, key.Column))
+		}
+	}
+
+	direction := cursorDirectionNext
+
+	var cursorValues []interface{}
+
+	if cursor != "" {
+		direction, cursorValues, err = paginator.decodeCursor(cursor)
+		if err != nil {
+			return nil, conn.e.ErrorOnly(err)
+		}
+	}
+
+	pageQuery, pageArgs := paginator.pageQuery(query, args, direction, cursorValues)
+
+	var items []T
+
+	err = conn.Q(ctx).SelectContext(ctx, &items, pageQuery, pageArgs...)
+	if err != nil {
+		return nil, conn.ClassifyErrorContext(ctx, err)
+	}
+
+	hasMore := len(items) > paginator.pageSize
+	if hasMore {
+		items = items[:paginator.pageSize]
+	}
+
+	if direction == cursorDirectionPrev {
+		slices.Reverse(items)
+	}
+
+	page := &Page[T]{
+		Items:      items,
+		NextCursor: "",
+		PrevCursor: "",
+	}
+
+	if len(items) == 0 {
+		return page, nil
+	}
+
+	hasNext := (direction == cursorDirectionNext && hasMore) || direction == cursorDirectionPrev
+	hasPrev := (direction == cursorDirectionPrev && hasMore) || (direction == cursorDirectionNext && cursor != "")
+
+	if hasNext {
+		page.NextCursor, err = paginator.encodeCursor(cursorDirectionNext, mapping, items[len(items)-1])
+		if err != nil {
+			return nil, conn.e.ErrorOnly(err)
+		}
+	}
+
+	if hasPrev {
+		page.PrevCursor, err = paginator.encodeCursor(cursorDirectionPrev, mapping, items[0])
+		if err != nil {
+			return nil, conn.e.ErrorOnly(err)
+		}
+	}
+
+	return page, nil
+}
+
+// pageQuery wraps base query, adds keyset condition, ordering and limit...
+func (p *Paginator) pageQuery(query string,
+	args []interface{},
+	direction string,
+	cursorValues []interface{},
+) (string, []interface{}) {
+	pageArgs := make([]interface{}, 0, len(args)+len(cursorValues))
+	pageArgs = append(pageArgs, args...)
+
+	var pageQuery strings.Builder
+
+	pageQuery.WriteString("SELECT * FROM (" + query + ") AS keyset_page")
+
+	if len(cursorValues) != 0 {
+		conditions := make([]string, 0, len(p.sortKeys))
+
+		for i, key := range p.sortKeys {
+			parts := make([]string, 0, i+1)
+
+			for j := 0; j < i; j++ {
+				parts = append(parts, fmt.Sprintf("keyset_page.%s = $%d",
+					pq.QuoteIdentifier(p.sortKeys[j].Column), len(args)+j+1))
+			}
+
+			parts = append(parts, fmt.Sprintf("keyset_page.%s %s $%d",
+				pq.QuoteIdentifier(key.Column), p.comparisonOperator(key, direction), len(args)+i+1))
+
+			conditions = append(conditions, "("+strings.Join(parts, " AND ")+")")
+		}
+
+		pageArgs = append(pageArgs, cursorValues...)
+
+		pageQuery.WriteString(" WHERE " + strings.Join(conditions, " OR "))
+	}
+
+	orderBy := make([]string, len(p.sortKeys))
+
+	for i, key := range p.sortKeys {
+		isDesc := key.Direction == SortDesc
+		if direction == cursorDirectionPrev {
+			isDesc = !isDesc
+		}
+
+		orderBy[i] = "keyset_page." + pq.QuoteIdentifier(key.Column) + " ASC"
+		if isDesc {
+			orderBy[i] = "keyset_page." + pq.QuoteIdentifier(key.Column) + " DESC"
+		}
+	}
+
+	pageQuery.WriteString(" ORDER BY " + strings.Join(orderBy, ", "))
+	pageQuery.WriteString(" LIMIT " + strconv.Itoa(p.pageSize+1))
+
+	return pageQuery.String(), pageArgs
+}
+
+func (p *Paginator) comparisonOperator(key SortKey, direction string) string {
+	isAfter := direction == cursorDirectionNext
+	if key.Direction == SortDesc {
+		isAfter = !isAfter
+	}
+
+	if isAfter {
+		return ">"
+	}
+
+	return "<"
+}
+
+func (p *Paginator) encodeCursor(direction string, mapping *structMapping, item interface{}) (string, error) {
+	itemValue := reflect.Indirect(reflect.ValueOf(item))
+
+	payload := cursorPayload{
+		Direction:  direction,
+		Values:     make([]json.RawMessage, len(p.sortKeys)),
+		ByteValues: nil,
+	}
+
+	for i, key := range p.sortKeys {
+		field, _ := mapping.field(key.Column)
+
+		value := reflectx.FieldByIndexesReadOnly(itemValue, field.Index).Interface()
+
+		valuer, isValuer := value.(driver.Valuer)
+		if isValuer {
+			driverValue, err := valuer.Value()
+			if err != nil {
+				return "", err
+			}
+
+			value = driverValue
+		}
+
+		if _, isBytes := value.([]byte); isBytes {
+			payload.ByteValues = append(payload.ByteValues, i)
+		}
+
+		rawValue, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+
+		payload.Values[i] = rawValue
+	}
+
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(rawPayload)
+
+	return encodedPayload + "." + base64.RawURLEncoding.EncodeToString(p.sign(encodedPayload)), nil
+}
+
+func (p *Paginator) decodeCursor(cursor string) (string, []interface{}, error) {
+	encodedPayload, encodedSignature, isFound := strings.Cut(cursor, ".")
+	if !isFound {
+		return "", nil, ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, p.sign(encodedPayload)) {
+		return "", nil, ErrInvalidCursor
+	}
+
+	rawPayload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return "", nil, ErrInvalidCursor
+	}
+
+	var payload cursorPayload
+
+	err = json.Unmarshal(rawPayload, &payload)
+	if err != nil || len(payload.Values) != len(p.sortKeys) ||
+		(payload.Direction != cursorDirectionNext && payload.Direction != cursorDirectionPrev) {
+		return "", nil, ErrInvalidCursor
+	}
+
+	values := make([]interface{}, len(payload.Values))
+
+	for i, rawValue := range payload.Values {
+		decoder := json.NewDecoder(bytes.NewReader(rawValue))
+		decoder.UseNumber()
+
+		var value interface{}
+
+		err = decoder.Decode(&value)
+		if err != nil {
+			return "", nil, ErrInvalidCursor
+		}
+
+		number, isNumber := value.(json.Number)
+		if isNumber {
+			value = number.String()
+		}
+
+		values[i] = value
+	}
+
+	for _, i := range payload.ByteValues {
+		if i < 0 || i >= len(values) {
+			return "", nil, ErrInvalidCursor
+		}
+
+		encodedValue, isString := values[i].(string)
+		if !isString {
+			return "", nil, ErrInvalidCursor
+		}
+
+		values[i], err = base64.StdEncoding.DecodeString(encodedValue)
+		if err != nil {
+			return "", nil, ErrInvalidCursor
+		}
+	}
+
+	return payload.Direction, values, nil
+}
+
+// sign returns HMAC-SHA256 signature of cursor payload. Sort keys also signed,
+// so cursor of one paginator can not be used with another...
+func (p *Paginator) sign(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+
+	for _, key := range p.sortKeys {
+		_, _ = mac.Write([]byte(key.Column + ":" + strconv.Itoa(int(key.Direction)) + ";"))
+	}
+
+	_, _ = mac.Write([]byte(encodedPayload))
+
+	return mac.Sum(nil)
+}
+
+// NewPaginator ....
+func NewPaginator(secret []byte, pageSize int, sortKeys ...SortKey) (*Paginator, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptyCursorSecret
+	}
+
+	if pageSize <= 0 {
+		return nil, ErrInvalidPageSize
+	}
+
+	if len(sortKeys) == 0 {
+		return nil, ErrEmptySortKeys
+	}
+
+	return &Paginator{
+		sortKeys: sortKeys,
+		secret:   secret,
+		pageSize: pageSize,
+	}, nil
+}
@@ -0,0 +1,291 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+type paginationTestItem struct {
+	ID        int64     `db:"id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func newTestPaginator(t *testing.T, secret string) *Paginator {
+	t.Helper()
+
+	paginator, err := NewPaginator([]byte(secret), 10,
+		SortKey{Column: "created_at", Direction: SortDesc},
+		SortKey{Column: "id", Direction: SortAsc})
+	if err != nil {
+		t.Fatalf("unable to create paginator: %v", err)
+	}
+
+	return paginator
+}
+
+func newTestCursor(t *testing.T, paginator *Paginator, direction string, item paginationTestItem) string {
+	t.Helper()
+
+	mapping, err := newStructMapping(reflectx.NewMapperFunc("db", strings.ToLower),
+		reflect.TypeOf(paginationTestItem{}))
+	if err != nil {
+		t.Fatalf("unable to create struct mapping: %v", err)
+	}
+
+	cursor, err := paginator.encodeCursor(direction, mapping, item)
+	if err != nil {
+		t.Fatalf("unable to encode cursor: %v", err)
+	}
+
+	return cursor
+}
+
+func TestPaginatorCursorRoundTrip(t *testing.T) {
+	paginator := newTestPaginator(t, "secret")
+	createdAt := time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC)
+
+	for _, direction := range []string{cursorDirectionNext, cursorDirectionPrev} {
+		cursor := newTestCursor(t, paginator, direction, paginationTestItem{
+			ID:        9007199254740993,
+			Name:      "wallet",
+			CreatedAt: createdAt,
+		})
+
+		decodedDirection, values, err := paginator.decodeCursor(cursor)
+		if err != nil {
+			t.Fatalf("decodeCursor(%s) returned error: %v", direction, err)
+		}
+
+		if decodedDirection != direction {
+			t.Errorf("direction = %q, want %q", decodedDirection, direction)
+		}
+
+		if len(values) != 2 {
+			t.Fatalf("decoded %d values, want 2", len(values))
+		}
+
+		if values[0] != createdAt.Format(time.RFC3339Nano) {
+			t.Errorf("created_at value = %v, want %s", values[0], createdAt.Format(time.RFC3339Nano))
+		}
+
+		// numbers decoded as strings, so big identifiers not rounded by float64 conversion
+		if values[1] != "9007199254740993" {
+			t.Errorf("id value = %v, want 9007199254740993", values[1])
+		}
+	}
+}
+
+// walletKey is the driver.Valuer of bytea column...
+type walletKey []byte
+
+func (k walletKey) Value() (driver.Value, error) {
+	return []byte(k), nil
+}
+
+type byteaPaginationTestItem struct {
+	Hash []byte    `db:"hash"`
+	Key  walletKey `db:"key"`
+	Name string    `db:"name"`
+}
+
+func TestPaginatorCursorKeepsBytesType(t *testing.T) {
+	paginator, err := NewPaginator([]byte("secret"), 10,
+		SortKey{Column: "hash", Direction: SortAsc},
+		SortKey{Column: "name", Direction: SortAsc},
+		SortKey{Column: "key", Direction: SortAsc})
+	if err != nil {
+		t.Fatalf("unable to create paginator: %v", err)
+	}
+
+	mapping, err := newStructMapping(reflectx.NewMapperFunc("db", strings.ToLower),
+		reflect.TypeOf(byteaPaginationTestItem{}))
+	if err != nil {
+		t.Fatalf("unable to create struct mapping: %v", err)
+	}
+
+	cursor, err := paginator.encodeCursor(cursorDirectionNext, mapping, byteaPaginationTestItem{
+		Hash: []byte{0x00, 0xff, 0x10},
+		Key:  walletKey("key"),
+		Name: "AP8Q",
+	})
+	if err != nil {
+		t.Fatalf("unable to encode cursor: %v", err)
+	}
+
+	_, values, err := paginator.decodeCursor(cursor)
+	if err != nil {
+		t.Fatalf("decodeCursor returned error: %v", err)
+	}
+
+	// string value, which looks like base64, must not be decoded
+	want := []interface{}{[]byte{0x00, 0xff, 0x10}, "AP8Q", []byte("key")}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("values = %#v, want %#v", values, want)
+	}
+}
+
+func TestPaginatorPageQuery(t *testing.T) {
+	paginator := newTestPaginator(t, "secret")
+	baseQuery := "SELECT id, name, created_at FROM wallets WHERE owner = $1"
+
+	testCases := []struct {
+		name         string
+		direction    string
+		cursorValues []interface{}
+		query        string
+	}{
+		{
+			name:         "first page",
+			direction:    cursorDirectionNext,
+			cursorValues: nil,
+			query: "SELECT * FROM (" + baseQuery + ") AS keyset_page" +
+				` ORDER BY keyset_page."created_at" DESC, keyset_page."id" ASC LIMIT 11`,
+		},
+		{
+			name:         "next page",
+			direction:    cursorDirectionNext,
+			cursorValues: []interface{}{"2024-05-17T10:30:00Z", "42"},
+			query: "SELECT * FROM (" + baseQuery + ") AS keyset_page" +
+				` WHERE (keyset_page."created_at" < $2)` +
+				` OR (keyset_page."created_at" = $2 AND keyset_page."id" > $3)` +
+				` ORDER BY keyset_page."created_at" DESC, keyset_page."id" ASC LIMIT 11`,
+		},
+		{
+			name:         "previous page",
+			direction:    cursorDirectionPrev,
+			cursorValues: []interface{}{"2024-05-17T10:30:00Z", "42"},
+			query: "SELECT * FROM (" + baseQuery + ") AS keyset_page" +
+				` WHERE (keyset_page."created_at" > $2)` +
+				` OR (keyset_page."created_at" = $2 AND keyset_page."id" < $3)` +
+				` ORDER BY keyset_page."created_at" ASC, keyset_page."id" DESC LIMIT 11`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			query, args := paginator.pageQuery(baseQuery, []interface{}{"owner"},
+				testCase.direction, testCase.cursorValues)
+			if query != testCase.query {
+				t.Errorf("query = %s\nwant %s", query, testCase.query)
+			}
+
+			wantArgs := append([]interface{}{"owner"}, testCase.cursorValues...)
+			if !reflect.DeepEqual(args, wantArgs) {
+				t.Errorf("args = %v, want %v", args, wantArgs)
+			}
+		})
+	}
+}
+
+func TestPaginatorDecodeCursorRejectsInvalid(t *testing.T) {
+	paginator := newTestPaginator(t, "secret")
+	cursor := newTestCursor(t, paginator, cursorDirectionNext, paginationTestItem{
+		ID:        42,
+		Name:      "wallet",
+		CreatedAt: time.Date(2024, 5, 17, 10, 30, 0, 0, time.UTC),
+	})
+
+	encodedPayload, encodedSignature, _ := strings.Cut(cursor, ".")
+
+	tamperedPayload := base64.RawURLEncoding.EncodeToString(
+		[]byte(`{"d":"next","v":["2024-05-17T10:30:00Z",1]}`))
+
+	otherKeysPaginator, err := NewPaginator([]byte("secret"), 10,
+		SortKey{Column: "created_at", Direction: SortAsc},
+		SortKey{Column: "id", Direction: SortAsc})
+	if err != nil {
+		t.Fatalf("unable to create paginator: %v", err)
+	}
+
+	testCases := []struct {
+		name      string
+		paginator *Paginator
+		cursor    string
+	}{
+		{name: "tampered payload", paginator: paginator, cursor: tamperedPayload + "." + encodedSignature},
+		{name: "tampered signature", paginator: paginator, cursor: encodedPayload + "." + encodedPayload},
+		{name: "truncated signature", paginator: paginator, cursor: cursor[:len(cursor)-4]},
+		{name: "truncated payload", paginator: paginator, cursor: encodedPayload[4:] + "." + encodedSignature},
+		{name: "missing signature", paginator: paginator, cursor: encodedPayload},
+		{name: "malformed base64", paginator: paginator, cursor: "!!!." + encodedSignature},
+		{name: "wrong key", paginator: newTestPaginator(t, "another-secret"), cursor: cursor},
+		{name: "other sort keys", paginator: otherKeysPaginator, cursor: cursor},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, _, err := testCase.paginator.decodeCursor(testCase.cursor)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestPaginatorDecodeCursorRejectsSignedMalformedPayload(t *testing.T) {
+	paginator := newTestPaginator(t, "secret")
+
+	testCases := []struct {
+		name    string
+		payload string
+	}{
+		{name: "unknown direction", payload: `{"d":"up","v":["2024-05-17T10:30:00Z",1]}`},
+		{name: "values count mismatch", payload: `{"d":"next","v":[1]}`},
+		{name: "not json", payload: `next:1`},
+		{name: "bytes index out of range", payload: `{"d":"next","v":["AA==",1],"b":[2]}`},
+		{name: "bytes value not string", payload: `{"d":"next","v":["AA==",1],"b":[1]}`},
+		{name: "bytes value not base64", payload: `{"d":"next","v":["!!",1],"b":[0]}`},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			encodedPayload := base64.RawURLEncoding.EncodeToString([]byte(testCase.payload))
+			cursor := encodedPayload + "." + base64.RawURLEncoding.EncodeToString(paginator.sign(encodedPayload))
+
+			_, _, err := paginator.decodeCursor(cursor)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("decodeCursor error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
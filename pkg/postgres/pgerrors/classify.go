@@ -0,0 +1,180 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgerrors
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"syscall"
+
+	"github.com/lib/pq"
+)
+
+// sqlStateError is implemented by lib/pq *pq.Error and by error types of other PostgreSQL drivers,
+// for example by pgx *pgconn.PgError...
+type sqlStateError interface {
+	SQLState() string
+}
+
+// Classify returns classified Error for known SQLSTATE codes and connection-level errors.
+// Unknown errors returned as is...
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var classifiedErr *Error
+	if errors.As(err, &classifiedErr) {
+		return err
+	}
+
+	classifiedErr = classify(err)
+	if classifiedErr == nil {
+		return err
+	}
+
+	return classifiedErr
+}
+
+// SQLState returns PostgreSQL error code from error chain, or empty string...
+func SQLState(err error) string {
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState()
+	}
+
+	return ""
+}
+
+// IsConnectionError reports whether error is connection-level error - lost or broken connection...
+func IsConnectionError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, ErrConnectionFailure) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	kind, isKnown := kindBySQLState(SQLState(err))
+
+	return isKnown && kind.err == ErrConnectionFailure
+}
+
+func classify(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var stateErr sqlStateError
+	if !errors.As(err, &stateErr) {
+		if !IsConnectionError(err) {
+			return nil
+		}
+
+		return &Error{
+			Kind:       ErrConnectionFailure,
+			Code:       CodeConnectionFailure,
+			SQLState:   "",
+			Schema:     "",
+			Table:      "",
+			Column:     "",
+			Constraint: "",
+			Err:        err,
+		}
+	}
+
+	kind, isKnown := kindBySQLState(stateErr.SQLState())
+	if !isKnown {
+		return nil
+	}
+
+	classifiedErr := &Error{
+		Kind:       kind.err,
+		Code:       kind.code,
+		SQLState:   stateErr.SQLState(),
+		Schema:     "",
+		Table:      "",
+		Column:     "",
+		Constraint: "",
+		Err:        err,
+	}
+
+	fillDetails(classifiedErr, stateErr)
+
+	return classifiedErr
+}
+
+// fillDetails fills schema, table, column and constraint names of classified error.
+// Other drivers error types supported by field names - SchemaName, TableName, ColumnName, ConstraintName...
+func fillDetails(classifiedErr *Error, driverErr sqlStateError) {
+	pqErr, isPqErr := driverErr.(*pq.Error)
+	if isPqErr {
+		classifiedErr.Schema = pqErr.Schema
+		classifiedErr.Table = pqErr.Table
+		classifiedErr.Column = pqErr.Column
+		classifiedErr.Constraint = pqErr.Constraint
+
+		return
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(driverErr))
+	if value.Kind() != reflect.Struct {
+		return
+	}
+
+	classifiedErr.Schema = stringField(value, "SchemaName", "Schema")
+	classifiedErr.Table = stringField(value, "TableName", "Table")
+	classifiedErr.Column = stringField(value, "ColumnName", "Column")
+	classifiedErr.Constraint = stringField(value, "ConstraintName", "Constraint")
+}
+
+func stringField(value reflect.Value, names ...string) string {
+	for _, name := range names {
+		field := value.FieldByName(name)
+		if field.IsValid() && field.Kind() == reflect.String {
+			return field.String()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,330 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgerrors
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// pgconnError has same shape with pgx *pgconn.PgError...
+type pgconnError struct {
+	Code           string
+	Message        string
+	SchemaName     string
+	TableName      string
+	ColumnName     string
+	ConstraintName string
+}
+
+func (e *pgconnError) Error() string {
+	return e.Message + " (SQLSTATE " + e.Code + ")"
+}
+
+func (e *pgconnError) SQLState() string {
+	return e.Code
+}
+
+// shortNamesError is the driver error with short names of detail fields...
+type shortNamesError struct {
+	Code       string
+	Schema     string
+	Table      string
+	Column     string
+	Constraint string
+}
+
+func (e shortNamesError) Error() string {
+	return "driver error " + e.Code
+}
+
+func (e shortNamesError) SQLState() string {
+	return e.Code
+}
+
+// codeOnlyError is the driver error without detail fields...
+type codeOnlyError string
+
+func (e codeOnlyError) Error() string {
+	return "driver error " + string(e)
+}
+
+func (e codeOnlyError) SQLState() string {
+	return string(e)
+}
+
+func TestClassifySQLState(t *testing.T) {
+	testCases := []struct {
+		sqlState string
+		kind     error
+		code     int
+	}{
+		{sqlState: SQLStateUniqueViolation, kind: ErrUniqueViolation, code: CodeUniqueViolation},
+		{sqlState: SQLStateForeignKeyViolation, kind: ErrForeignKeyViolation, code: CodeForeignKeyViolation},
+		{sqlState: SQLStateCheckViolation, kind: ErrCheckViolation, code: CodeCheckViolation},
+		{sqlState: SQLStateNotNullViolation, kind: ErrNotNullViolation, code: CodeNotNullViolation},
+		{sqlState: SQLStateExclusionViolation, kind: ErrExclusionViolation, code: CodeExclusionViolation},
+		{sqlState: SQLStateSerializationFailure, kind: ErrSerializationFailure, code: CodeSerializationFailure},
+		{sqlState: SQLStateDeadlockDetected, kind: ErrDeadlockDetected, code: CodeDeadlockDetected},
+		{sqlState: SQLStateQueryCanceled, kind: ErrQueryCanceled, code: CodeQueryCanceled},
+		{sqlState: SQLStateLockNotAvailable, kind: ErrLockNotAvailable, code: CodeLockNotAvailable},
+		{sqlState: SQLStateReadOnlyTransaction, kind: ErrReadOnlyTransaction, code: CodeReadOnlyTransaction},
+		{sqlState: SQLStateAdminShutdown, kind: ErrConnectionFailure, code: CodeConnectionFailure},
+		{sqlState: SQLStateCrashShutdown, kind: ErrConnectionFailure, code: CodeConnectionFailure},
+		{sqlState: SQLStateCannotConnectNow, kind: ErrConnectionFailure, code: CodeConnectionFailure},
+		{sqlState: "08006", kind: ErrConnectionFailure, code: CodeConnectionFailure},
+		{sqlState: "08001", kind: ErrConnectionFailure, code: CodeConnectionFailure},
+		{sqlState: "42601", kind: nil, code: CodeUnknown},
+		{sqlState: "23000", kind: nil, code: CodeUnknown},
+		{sqlState: "080", kind: nil, code: CodeUnknown},
+	}
+
+	for _, testCase := range testCases {
+		driverErrs := map[string]error{
+			"lib/pq":      &pq.Error{Code: pq.ErrorCode(testCase.sqlState), Message: "failed"},
+			"pgconn":      &pgconnError{Code: testCase.sqlState, Message: "failed"},
+			"code only":   codeOnlyError(testCase.sqlState),
+			"wrapped pq":  fmt.Errorf("insert wallet: %w", &pq.Error{Code: pq.ErrorCode(testCase.sqlState)}),
+			"short names": shortNamesError{Code: testCase.sqlState},
+		}
+
+		for driverName, driverErr := range driverErrs {
+			t.Run(testCase.sqlState+" "+driverName, func(t *testing.T) {
+				err := Classify(driverErr)
+
+				if SQLState(driverErr) != testCase.sqlState {
+					t.Errorf("SQLState() = %q, want %q", SQLState(driverErr), testCase.sqlState)
+				}
+
+				classifiedErr, isClassified := As(err)
+				if testCase.kind == nil {
+					if isClassified || err != driverErr { //nolint:errorlint // error must be returned as is
+						t.Errorf("Classify() = %v, want unclassified original error", err)
+					}
+
+					return
+				}
+
+				if !isClassified {
+					t.Fatalf("Classify() = %v, want %v", err, testCase.kind)
+				}
+
+				if !errors.Is(err, testCase.kind) || !errors.Is(err, driverErr) {
+					t.Errorf("Classify() = %v does not match %v and driver error", err, testCase.kind)
+				}
+
+				if classifiedErr.Code != testCase.code || classifiedErr.SQLState != testCase.sqlState {
+					t.Errorf("code = %d, SQLSTATE = %q, want %d and %q", classifiedErr.Code,
+						classifiedErr.SQLState, testCase.code, testCase.sqlState)
+				}
+
+				if Classify(err) != err { //nolint:errorlint // classified error must be returned as is
+					t.Error("classified error classified twice")
+				}
+			})
+		}
+	}
+}
+
+func TestClassifyDetails(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+	}{
+		{name: "lib/pq", err: &pq.Error{
+			Code: SQLStateUniqueViolation, Schema: "public", Table: "addresses", Column: "address",
+			Constraint: "addresses_address_key",
+		}},
+		{name: "pgconn", err: &pgconnError{
+			Code: SQLStateUniqueViolation, SchemaName: "public", TableName: "addresses", ColumnName: "address",
+			ConstraintName: "addresses_address_key",
+		}},
+		{name: "short names", err: shortNamesError{
+			Code: SQLStateUniqueViolation, Schema: "public", Table: "addresses", Column: "address",
+			Constraint: "addresses_address_key",
+		}},
+		{name: "wrapped", err: fmt.Errorf("insert address: %w", &pgconnError{
+			Code: SQLStateUniqueViolation, SchemaName: "public", TableName: "addresses", ColumnName: "address",
+			ConstraintName: "addresses_address_key",
+		})},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			classifiedErr, isClassified := As(testCase.err)
+			if !isClassified {
+				t.Fatalf("error %v is not classified", testCase.err)
+			}
+
+			got := [4]string{classifiedErr.Schema, classifiedErr.Table, classifiedErr.Column, classifiedErr.Constraint}
+			want := [4]string{"public", "addresses", "address", "addresses_address_key"}
+
+			if got != want {
+				t.Errorf("details = %q, want %q", got, want)
+			}
+
+			if !IsUniqueViolation(testCase.err, "wallets_pkey", "addresses_address_key") {
+				t.Error("IsUniqueViolation() = false for violated constraint")
+			}
+
+			if IsUniqueViolation(testCase.err, "wallets_pkey") {
+				t.Error("IsUniqueViolation() = true for other constraint")
+			}
+
+			if IsForeignKeyViolation(testCase.err) {
+				t.Error("IsForeignKeyViolation() = true for unique violation")
+			}
+		})
+	}
+
+	classifiedErr, _ := As(codeOnlyError(SQLStateUniqueViolation))
+	if classifiedErr.Table != "" || classifiedErr.Constraint != "" {
+		t.Errorf("details of error without detail fields = %+v", classifiedErr)
+	}
+}
+
+func TestClassifyConnectionErrors(t *testing.T) {
+	testCases := []struct {
+		name         string
+		err          error
+		isConnection bool
+	}{
+		{name: "bad connection", err: driver.ErrBadConn, isConnection: true},
+		{name: "eof", err: io.EOF, isConnection: true},
+		{name: "unexpected eof", err: fmt.Errorf("read: %w", io.ErrUnexpectedEOF), isConnection: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, isConnection: true},
+		{name: "broken pipe", err: fmt.Errorf("write: %w", syscall.EPIPE), isConnection: true},
+		{name: "admin shutdown", err: &pq.Error{Code: SQLStateAdminShutdown}, isConnection: true},
+		{name: "connection exception class", err: codeOnlyError("08003"), isConnection: true},
+		{name: "serialization failure", err: &pq.Error{Code: SQLStateSerializationFailure}, isConnection: false},
+		{name: "other error", err: errors.New("invalid input"), isConnection: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if IsConnectionError(testCase.err) != testCase.isConnection {
+				t.Errorf("IsConnectionError() = %t, want %t", !testCase.isConnection, testCase.isConnection)
+			}
+
+			err := Classify(testCase.err)
+			if errors.Is(err, ErrConnectionFailure) != testCase.isConnection {
+				t.Errorf("Classify() = %v, connection failure = %t, want %t", err,
+					!testCase.isConnection, testCase.isConnection)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	testCases := []struct {
+		err         error
+		isRetryable bool
+	}{
+		{err: &pq.Error{Code: SQLStateSerializationFailure}, isRetryable: true},
+		{err: &pgconnError{Code: SQLStateDeadlockDetected}, isRetryable: true},
+		{err: &pq.Error{Code: SQLStateUniqueViolation}, isRetryable: false},
+		{err: io.ErrUnexpectedEOF, isRetryable: false},
+		{err: errors.New("invalid input"), isRetryable: false},
+	}
+
+	for _, testCase := range testCases {
+		if IsRetryable(testCase.err) != testCase.isRetryable {
+			t.Errorf("IsRetryable(%v) = %t, want %t", testCase.err, !testCase.isRetryable, testCase.isRetryable)
+		}
+	}
+}
+
+// codedError is the error with code assigned by testErrorFormatter...
+type codedError struct {
+	err  error
+	code int
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+type testErrorFormatter struct{}
+
+func (testErrorFormatter) ErrorWithCode(err error, code int) error {
+	return &codedError{err: err, code: code}
+}
+
+func (testErrorFormatter) ErrorOnly(err error, _ ...string) error {
+	return err
+}
+
+func (testErrorFormatter) ErrorNoWrap(err error) error {
+	return err
+}
+
+func TestFormatterAssignsStableCode(t *testing.T) {
+	formatter := NewFormatter(testErrorFormatter{})
+
+	err := formatter.Format(&pq.Error{Code: SQLStateDeadlockDetected})
+
+	var coded *codedError
+	if !errors.As(err, &coded) || coded.code != CodeDeadlockDetected {
+		t.Fatalf("Format() = %v, want error with code %d", err, CodeDeadlockDetected)
+	}
+
+	if !errors.Is(err, ErrDeadlockDetected) {
+		t.Errorf("Format() = %v, want %v", err, ErrDeadlockDetected)
+	}
+
+	// already classified error not coded twice
+	formatted := formatter.Format(err)
+	if !errors.As(formatted, &coded) || errors.As(coded.err, new(*codedError)) {
+		t.Errorf("Format() of classified error = %#v, want single code", formatted)
+	}
+
+	unknownErr := errors.New("invalid input")
+	if formatter.Format(unknownErr) != unknownErr { //nolint:errorlint // unknown error returned as is
+		t.Error("Format() of unknown error assigned code")
+	}
+
+	if formatter.Format(nil) != nil {
+		t.Error("Format(nil) is not nil")
+	}
+}
@@ -0,0 +1,106 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgerrors
+
+// Stable error codes of classified errors, used with errorFormatterService.ErrorWithCode...
+const (
+	CodeUnknown              = 0
+	CodeUniqueViolation      = 2301
+	CodeForeignKeyViolation  = 2302
+	CodeCheckViolation       = 2303
+	CodeNotNullViolation     = 2304
+	CodeExclusionViolation   = 2305
+	CodeSerializationFailure = 2306
+	CodeDeadlockDetected     = 2307
+	CodeQueryCanceled        = 2308
+	CodeLockNotAvailable     = 2309
+	CodeReadOnlyTransaction  = 2310
+	CodeConnectionFailure    = 2311
+)
+
+// SQLSTATE codes of classified errors...
+const (
+	SQLStateNotNullViolation       = "23502"
+	SQLStateForeignKeyViolation    = "23503"
+	SQLStateUniqueViolation        = "23505"
+	SQLStateCheckViolation         = "23514"
+	SQLStateExclusionViolation     = "23P01"
+	SQLStateReadOnlyTransaction    = "25006"
+	SQLStateSerializationFailure   = "40001"
+	SQLStateDeadlockDetected       = "40P01"
+	SQLStateLockNotAvailable       = "55P03"
+	SQLStateQueryCanceled          = "57014"
+	SQLStateAdminShutdown          = "57P01"
+	SQLStateCrashShutdown          = "57P02"
+	SQLStateCannotConnectNow       = "57P03"
+	SQLStateClassConnectionFailure = "08"
+)
+
+type errorKind struct {
+	err  error
+	code int
+}
+
+// kindBySQLState returns sentinel error and stable code by SQLSTATE...
+func kindBySQLState(sqlState string) (errorKind, bool) {
+	switch sqlState {
+	case SQLStateUniqueViolation:
+		return errorKind{err: ErrUniqueViolation, code: CodeUniqueViolation}, true
+	case SQLStateForeignKeyViolation:
+		return errorKind{err: ErrForeignKeyViolation, code: CodeForeignKeyViolation}, true
+	case SQLStateCheckViolation:
+		return errorKind{err: ErrCheckViolation, code: CodeCheckViolation}, true
+	case SQLStateNotNullViolation:
+		return errorKind{err: ErrNotNullViolation, code: CodeNotNullViolation}, true
+	case SQLStateExclusionViolation:
+		return errorKind{err: ErrExclusionViolation, code: CodeExclusionViolation}, true
+	case SQLStateSerializationFailure:
+		return errorKind{err: ErrSerializationFailure, code: CodeSerializationFailure}, true
+	case SQLStateDeadlockDetected:
+		return errorKind{err: ErrDeadlockDetected, code: CodeDeadlockDetected}, true
+	case SQLStateQueryCanceled:
+		return errorKind{err: ErrQueryCanceled, code: CodeQueryCanceled}, true
+	case SQLStateLockNotAvailable:
+		return errorKind{err: ErrLockNotAvailable, code: CodeLockNotAvailable}, true
+	case SQLStateReadOnlyTransaction:
+		return errorKind{err: ErrReadOnlyTransaction, code: CodeReadOnlyTransaction}, true
+	case SQLStateAdminShutdown, SQLStateCrashShutdown, SQLStateCannotConnectNow:
+		return errorKind{err: ErrConnectionFailure, code: CodeConnectionFailure}, true
+	}
+
+	if len(sqlState) == 5 && sqlState[:2] == SQLStateClassConnectionFailure {
+		return errorKind{err: ErrConnectionFailure, code: CodeConnectionFailure}, true
+	}
+
+	return errorKind{err: nil, code: CodeUnknown}, false
+}
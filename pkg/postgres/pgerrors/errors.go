@@ -0,0 +1,152 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgerrors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUniqueViolation      = errors.New("unique constraint violation")
+	ErrForeignKeyViolation  = errors.New("foreign key constraint violation")
+	ErrCheckViolation       = errors.New("check constraint violation")
+	ErrNotNullViolation     = errors.New("not-null constraint violation")
+	ErrExclusionViolation   = errors.New("exclusion constraint violation")
+	ErrSerializationFailure = errors.New("could not serialize access due to concurrent update")
+	ErrDeadlockDetected     = errors.New("deadlock detected")
+	ErrQueryCanceled        = errors.New("query cancelled")
+	ErrLockNotAvailable     = errors.New("lock not available")
+	ErrReadOnlyTransaction  = errors.New("cannot execute statement in read-only transaction")
+	ErrConnectionFailure    = errors.New("database connection failure")
+)
+
+// Error is classified database error...
+type Error struct {
+	// Kind is one of package sentinel errors - ErrUniqueViolation, ErrDeadlockDetected, etc.
+	Kind error
+	// Code is the stable error code of Kind, see Code* constants
+	Code int
+	// SQLState is the PostgreSQL error code, empty for driver-level connection errors
+	SQLState string
+
+	Schema     string
+	Table      string
+	Column     string
+	Constraint string
+
+	// Err is the original driver error
+	Err error
+}
+
+func (e *Error) Error() string {
+	details := make([]string, 0, 3) //nolint:mnd // count of details
+
+	if e.Table != "" {
+		details = append(details, "table: "+e.Table)
+	}
+
+	if e.Column != "" {
+		details = append(details, "column: "+e.Column)
+	}
+
+	if e.Constraint != "" {
+		details = append(details, "constraint: "+e.Constraint)
+	}
+
+	if len(details) == 0 {
+		return fmt.Sprintf("%s: %s", e.Kind.Error(), e.Err.Error())
+	}
+
+	return fmt.Sprintf("%s (%s): %s", e.Kind.Error(), strings.Join(details, ", "), e.Err.Error())
+}
+
+// Unwrap allows to match Error with Kind sentinel and with original driver error...
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// As returns classified Error from error chain...
+func As(err error) (*Error, bool) {
+	var classifiedErr *Error
+	if errors.As(err, &classifiedErr) {
+		return classifiedErr, true
+	}
+
+	classifiedErr = classify(err)
+
+	return classifiedErr, classifiedErr != nil
+}
+
+// IsUniqueViolation reports whether error is unique constraint violation.
+// If constraint names passed - also checks violated constraint name...
+func IsUniqueViolation(err error, constraints ...string) bool {
+	return isConstraintViolation(err, ErrUniqueViolation, constraints...)
+}
+
+// IsForeignKeyViolation reports whether error is foreign key constraint violation.
+// If constraint names passed - also checks violated constraint name...
+func IsForeignKeyViolation(err error, constraints ...string) bool {
+	return isConstraintViolation(err, ErrForeignKeyViolation, constraints...)
+}
+
+// IsRetryable reports whether transaction with such error can be safely retried -
+// serialization failure or deadlock...
+func IsRetryable(err error) bool {
+	classifiedErr, isClassified := As(err)
+	if !isClassified {
+		return false
+	}
+
+	return classifiedErr.Kind == ErrSerializationFailure || classifiedErr.Kind == ErrDeadlockDetected
+}
+
+func isConstraintViolation(err error, kind error, constraints ...string) bool {
+	classifiedErr, isClassified := As(err)
+	if !isClassified || classifiedErr.Kind != kind {
+		return false
+	}
+
+	if len(constraints) == 0 {
+		return true
+	}
+
+	for _, constraint := range constraints {
+		if classifiedErr.Constraint == constraint {
+			return true
+		}
+	}
+
+	return false
+}
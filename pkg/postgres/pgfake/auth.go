@@ -0,0 +1,295 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgfake
+
+import (
+	"crypto/hmac"
+	"crypto/md5" //nolint:gosec // md5 password authentication of postgres protocol
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AuthMethod is the password authentication method of fake server...
+type AuthMethod string
+
+const (
+	AuthTrust     AuthMethod = "trust"
+	AuthCleartext AuthMethod = "password"
+	AuthMD5       AuthMethod = "md5"
+	AuthSCRAM     AuthMethod = "scram-sha-256"
+
+	authOK               = 0
+	authCleartext        = 3
+	authMD5              = 5
+	authSASL             = 10
+	authSASLContinue     = 11
+	authSASLFinal        = 12
+	scramMechanism       = "SCRAM-SHA-256"
+	scramIterations      = 4096
+	scramNonceLength     = 18
+	scramSaltLength      = 16
+	md5SaltLength        = 4
+	passwordMessageType  = 'p'
+	scramChannelBinding  = "c=biws"
+	scramClientKeyString = "Client Key"
+	scramServerKeyString = "Server Key"
+)
+
+var ErrAuthenticationFailed = errors.New("password authentication failed")
+
+// authenticate runs authentication exchange of configured method...
+func (s *session) authenticate() error {
+	switch s.server.cfg.Auth {
+	case AuthCleartext:
+		return s.authenticateCleartext()
+	case AuthMD5:
+		return s.authenticateMD5()
+	case AuthSCRAM:
+		return s.authenticateSCRAM()
+	case AuthTrust, "":
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported method %s", ErrAuthenticationFailed, s.server.cfg.Auth)
+	}
+}
+
+func (s *session) authenticateCleartext() error {
+	password, err := s.requestPassword((&writeBuffer{}).message('R').int32(authCleartext))
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(password), []byte(s.server.cfg.Password)) != 1 {
+		return ErrAuthenticationFailed
+	}
+
+	return nil
+}
+
+func (s *session) authenticateMD5() error {
+	salt := make([]byte, md5SaltLength)
+
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err //nolint:wrapcheck // crypto/rand error returned as is
+	}
+
+	password, err := s.requestPassword((&writeBuffer{}).message('R').int32(authMD5).bytes(salt))
+	if err != nil {
+		return err
+	}
+
+	expected := "md5" + md5Hex(md5Hex(s.server.cfg.Password+s.user)+string(salt))
+	if subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		return ErrAuthenticationFailed
+	}
+
+	return nil
+}
+
+func (s *session) requestPassword(request *writeBuffer) (string, error) {
+	err := request.flush(s.conn)
+	if err != nil {
+		return "", err
+	}
+
+	msgType, body, err := readMessage(s.reader)
+	if err != nil {
+		return "", err
+	}
+
+	if msgType != passwordMessageType {
+		return "", fmt.Errorf("%w: unexpected message %q", ErrInvalidMessage, msgType)
+	}
+
+	buf := &readBuffer{data: body, err: nil}
+	password := buf.string()
+
+	return password, buf.err
+}
+
+// authenticateSCRAM runs SCRAM-SHA-256 exchange, RFC 5802 and RFC 7677...
+func (s *session) authenticateSCRAM() error {
+	err := (&writeBuffer{}).message('R').int32(authSASL).string(scramMechanism).byte(0).flush(s.conn)
+	if err != nil {
+		return err
+	}
+
+	body, err := s.readPasswordMessage()
+	if err != nil {
+		return err
+	}
+
+	buf := &readBuffer{data: body, err: nil}
+	mechanism := buf.string()
+	clientFirst := string(buf.bytes(int(buf.int32())))
+
+	if buf.err != nil || mechanism != scramMechanism {
+		return fmt.Errorf("%w: unexpected SASL initial response", ErrInvalidMessage)
+	}
+
+	clientFirstBare, isCut := strings.CutPrefix(clientFirst, "n,,")
+	if !isCut {
+		return fmt.Errorf("%w: channel binding not supported", ErrInvalidMessage)
+	}
+
+	clientNonce := scramAttribute(clientFirstBare, 'r')
+
+	serverNonce, salt, err := scramRandom()
+	if err != nil {
+		return err
+	}
+
+	nonce := clientNonce + serverNonce
+	serverFirst := fmt.Sprintf("r=%s,s=%s,i=%d", nonce, base64.StdEncoding.EncodeToString(salt), scramIterations)
+
+	err = (&writeBuffer{}).message('R').int32(authSASLContinue).bytes([]byte(serverFirst)).flush(s.conn)
+	if err != nil {
+		return err
+	}
+
+	clientFinal, err := s.readPasswordMessage()
+	if err != nil {
+		return err
+	}
+
+	clientFinalWithoutProof, proofAttr, isCut := strings.Cut(string(clientFinal), ",p=")
+	if !isCut || scramAttribute(clientFinalWithoutProof, 'r') != nonce {
+		return ErrAuthenticationFailed
+	}
+
+	proof, err := base64.StdEncoding.DecodeString(proofAttr)
+	if err != nil {
+		return ErrAuthenticationFailed
+	}
+
+	saltedPassword := pbkdf2SHA256([]byte(s.server.cfg.Password), salt, scramIterations)
+	clientKey := hmacSHA256(saltedPassword, []byte(scramClientKeyString))
+	storedKey := sha256.Sum256(clientKey)
+	authMessage := []byte(clientFirstBare + "," + serverFirst + "," + clientFinalWithoutProof)
+	clientSignature := hmacSHA256(storedKey[:], authMessage)
+
+	if len(proof) != len(clientSignature) {
+		return ErrAuthenticationFailed
+	}
+
+	for i := range proof {
+		proof[i] ^= clientSignature[i]
+	}
+
+	proofStoredKey := sha256.Sum256(proof)
+	if subtle.ConstantTimeCompare(proofStoredKey[:], storedKey[:]) != 1 {
+		return ErrAuthenticationFailed
+	}
+
+	serverKey := hmacSHA256(saltedPassword, []byte(scramServerKeyString))
+	serverFinal := "v=" + base64.StdEncoding.EncodeToString(hmacSHA256(serverKey, authMessage))
+
+	return (&writeBuffer{}).message('R').int32(authSASLFinal).bytes([]byte(serverFinal)).flush(s.conn)
+}
+
+func (s *session) readPasswordMessage() ([]byte, error) {
+	msgType, body, err := readMessage(s.reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if msgType != passwordMessageType {
+		return nil, fmt.Errorf("%w: unexpected message %q", ErrInvalidMessage, msgType)
+	}
+
+	return body, nil
+}
+
+func scramRandom() (string, []byte, error) {
+	nonce := make([]byte, scramNonceLength)
+	salt := make([]byte, scramSaltLength)
+
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", nil, err //nolint:wrapcheck // crypto/rand error returned as is
+	}
+
+	_, err = rand.Read(salt)
+	if err != nil {
+		return "", nil, err //nolint:wrapcheck // crypto/rand error returned as is
+	}
+
+	return base64.RawStdEncoding.EncodeToString(nonce), salt, nil
+}
+
+// scramAttribute returns value of attribute from comma separated SCRAM message...
+func scramAttribute(message string, name byte) string {
+	for _, attr := range strings.Split(message, ",") {
+		if len(attr) > 1 && attr[0] == name && attr[1] == '=' {
+			return attr[2:]
+		}
+	}
+
+	return ""
+}
+
+// pbkdf2SHA256 returns one block of PBKDF2 with HMAC-SHA-256, RFC 8018. Key length same with SHA-256 size...
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	block := hmacSHA256(password, append(append([]byte(nil), salt...), 0, 0, 0, 1))
+	result := append([]byte(nil), block...)
+
+	for i := 1; i < iterations; i++ {
+		block = hmacSHA256(password, block)
+
+		for j := range result {
+			result[j] ^= block[j]
+		}
+	}
+
+	return result
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+
+	return mac.Sum(nil)
+}
+
+func md5Hex(value string) string {
+	sum := md5.Sum([]byte(value)) //nolint:gosec // md5 password authentication of postgres protocol
+
+	return hex.EncodeToString(sum[:])
+}
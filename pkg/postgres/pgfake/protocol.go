@@ -0,0 +1,227 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgfake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	protocolVersion3   = 196608
+	sslRequestCode     = 80877103
+	gssEncRequestCode  = 80877104
+	cancelRequestCode  = 80877102
+	maxMessageLength   = 1 << 24
+	textFormatCode     = 0
+	textTypeOID        = 25
+	variableTypeLength = -1
+)
+
+var ErrInvalidMessage = errors.New("invalid protocol message")
+
+// readMessage reads typed frontend message...
+func readMessage(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5) //nolint:mnd // type byte and int32 length
+
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return 0, nil, err //nolint:wrapcheck // connection errors returned as is
+	}
+
+	body, err := readBody(r, header[1:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], body, nil
+}
+
+// readStartupMessage reads untyped startup message...
+func readStartupMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4) //nolint:mnd // int32 length
+
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // connection errors returned as is
+	}
+
+	return readBody(r, header)
+}
+
+func readBody(r io.Reader, lengthBytes []byte) ([]byte, error) {
+	length := int(binary.BigEndian.Uint32(lengthBytes)) - 4 //nolint:mnd // length includes itself
+	if length < 0 || length > maxMessageLength {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidMessage, length)
+	}
+
+	body := make([]byte, length)
+
+	_, err := io.ReadFull(r, body)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // connection errors returned as is
+	}
+
+	return body, nil
+}
+
+// readBuffer reads fields of message body...
+type readBuffer struct {
+	data []byte
+	err  error
+}
+
+func (b *readBuffer) int32() int32 {
+	if len(b.data) < 4 { //nolint:mnd // int32 size
+		b.err = ErrInvalidMessage
+
+		return 0
+	}
+
+	value := int32(binary.BigEndian.Uint32(b.data)) //nolint:gosec // protocol int32
+	b.data = b.data[4:]
+
+	return value
+}
+
+func (b *readBuffer) int16() int16 {
+	if len(b.data) < 2 { //nolint:mnd // int16 size
+		b.err = ErrInvalidMessage
+
+		return 0
+	}
+
+	value := int16(binary.BigEndian.Uint16(b.data)) //nolint:gosec // protocol int16
+	b.data = b.data[2:]
+
+	return value
+}
+
+func (b *readBuffer) string() string {
+	idx := bytes.IndexByte(b.data, 0)
+	if idx < 0 {
+		b.err = ErrInvalidMessage
+
+		return ""
+	}
+
+	value := string(b.data[:idx])
+	b.data = b.data[idx+1:]
+
+	return value
+}
+
+func (b *readBuffer) bytes(length int) []byte {
+	if length < 0 || len(b.data) < length {
+		b.err = ErrInvalidMessage
+
+		return nil
+	}
+
+	value := b.data[:length]
+	b.data = b.data[length:]
+
+	return value
+}
+
+// writeBuffer builds backend messages...
+type writeBuffer struct {
+	buf bytes.Buffer
+	// start is the position of current message
+	start int
+}
+
+func (b *writeBuffer) message(msgType byte) *writeBuffer {
+	b.finish()
+
+	b.start = b.buf.Len()
+	b.buf.WriteByte(msgType)
+	b.buf.Write([]byte{0, 0, 0, 0})
+
+	return b
+}
+
+func (b *writeBuffer) int32(value int32) *writeBuffer {
+	_ = binary.Write(&b.buf, binary.BigEndian, value)
+
+	return b
+}
+
+func (b *writeBuffer) int16(value int16) *writeBuffer {
+	_ = binary.Write(&b.buf, binary.BigEndian, value)
+
+	return b
+}
+
+func (b *writeBuffer) string(value string) *writeBuffer {
+	b.buf.WriteString(value)
+	b.buf.WriteByte(0)
+
+	return b
+}
+
+func (b *writeBuffer) bytes(value []byte) *writeBuffer {
+	b.buf.Write(value)
+
+	return b
+}
+
+func (b *writeBuffer) byte(value byte) *writeBuffer {
+	b.buf.WriteByte(value)
+
+	return b
+}
+
+// finish writes length of current message...
+func (b *writeBuffer) finish() {
+	if b.buf.Len() == 0 {
+		return
+	}
+
+	data := b.buf.Bytes()
+	binary.BigEndian.PutUint32(data[b.start+1:], uint32(len(data)-b.start-1)) //nolint:gosec // message size limited
+}
+
+// flush writes all built messages...
+func (b *writeBuffer) flush(w io.Writer) error {
+	b.finish()
+
+	_, err := w.Write(b.buf.Bytes())
+	b.buf.Reset()
+	b.start = 0
+
+	return err //nolint:wrapcheck // connection errors returned as is
+}
@@ -0,0 +1,389 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgfake
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/internal/connstr"
+)
+
+const (
+	serverHost    = "127.0.0.1"
+	serverVersion = "16.0"
+
+	SQLStateInvalidPassword        = "28P01"
+	SQLStateInvalidCatalogName     = "3D000"
+	SQLStateCannotConnectNow       = "57P03"
+	SQLStateFeatureNotSupported    = "0A000"
+	SQLStateInFailedSQLTransaction = "25P02"
+	SQLStateProtocolViolation      = "08P01"
+	SQLStateInvalidAuthorization   = "28000"
+)
+
+var ErrServerNotStarted = errors.New("fake server not started")
+
+// Config ....
+type Config struct {
+	// User is the name of allowed user, any user allowed if empty
+	User string
+	// Password of user, used by password authentication methods
+	Password string
+	// Database is the name of allowed database, any database allowed if empty
+	Database string
+	// Auth is the authentication method, AuthTrust if empty
+	Auth AuthMethod
+}
+
+// Error is the scripted ErrorResponse message...
+type Error struct {
+	// Severity of error, ERROR if empty
+	Severity string
+	SQLState string
+	Message  string
+	Detail   string
+}
+
+// Response is the scripted response on query...
+type Response struct {
+	// Columns is the list of result columns, all columns are text columns
+	Columns []string
+	// Rows is the list of result rows. Nil value sent as NULL, []byte value sent as is,
+	// other values formatted by fmt.Sprint function
+	Rows [][]any
+	// Tag is the command tag, e.g. INSERT 0 1. "SELECT {count of rows}" if empty
+	Tag string
+	// Err is the error response
+	Err *Error
+	// Delay is the delay before response
+	Delay time.Duration
+	// Disconnect - connection closed right after rows sent, without command completion
+	Disconnect bool
+}
+
+type script struct {
+	responses []Response
+	calls     int
+}
+
+// Server is the in-process fake PostgreSQL server, implements minimal part of v3 wire protocol:
+// startup, SSL negotiation refusal, trust, cleartext, md5 and SCRAM-SHA-256 authentication,
+// simple and extended query with scripted responses, error responses and termination.
+// Queries BEGIN, COMMIT, ROLLBACK, SELECT 1 and empty query answered without scripts...
+type Server struct {
+	mu sync.Mutex
+
+	cfg      Config
+	listener net.Listener
+	sessions map[*session]struct{}
+	wg       sync.WaitGroup
+	isClosed bool
+
+	scripts        map[string]*script
+	queries        []string
+	failedStartups int
+	startupErr     Error
+}
+
+// Start starts listening on free local port...
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(serverHost, "0"))
+	if err != nil {
+		return err //nolint:wrapcheck // listen error returned as is
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+
+	go s.acceptLoop(listener)
+
+	return nil
+}
+
+func (s *Server) acceptLoop(listener net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		sess := newSession(s, conn)
+
+		// connection accepted concurrently with Close must not outlive server
+		s.mu.Lock()
+		if s.isClosed {
+			s.mu.Unlock()
+
+			_ = conn.Close()
+
+			return
+		}
+
+		s.sessions[sess] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+
+			sess.serve()
+
+			s.mu.Lock()
+			delete(s.sessions, sess)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops listening, closes all client connections and waits connection handlers...
+func (s *Server) Close() error {
+	s.mu.Lock()
+	listener := s.listener
+	isClosed := s.isClosed
+	s.isClosed = true
+	s.mu.Unlock()
+
+	if listener == nil || isClosed {
+		return nil
+	}
+
+	err := listener.Close()
+
+	s.DisconnectAll()
+	s.wg.Wait()
+
+	return err //nolint:wrapcheck // listener error returned as is
+}
+
+// DisconnectAll closes all client connections, server continues listening...
+func (s *Server) DisconnectAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for sess := range s.sessions {
+		_ = sess.conn.Close()
+	}
+}
+
+// Addr returns listening address...
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return ""
+	}
+
+	return s.listener.Addr().String()
+}
+
+// Port returns listening port...
+func (s *Server) Port() uint16 {
+	_, port, _ := net.SplitHostPort(s.Addr())
+	portNumber, _ := strconv.ParseUint(port, 10, 16)
+
+	return uint16(portNumber)
+}
+
+// PostgresConfig returns connection config of fake server...
+func (s *Server) PostgresConfig() *postgres.PostgresConfig {
+	return &postgres.PostgresConfig{
+		DBHost:              serverHost,
+		DBName:              s.cfg.Database,
+		DBUsername:          s.cfg.User,
+		DBPassword:          s.cfg.Password,
+		DBSSLMode:           "disable",
+		DBConnectTimeOut:    10, //nolint:mnd // short retry timeout of tests
+		DBPort:              s.Port(),
+		DBMaxOpenConns:      2, //nolint:mnd // small pool of tests
+		DBMaxIdleConns:      2, //nolint:mnd // small pool of tests
+		DBConnectRetryCount: 1,
+		DBDriverName:        postgres.DefaultDriverName,
+	}
+}
+
+// DSN returns lib/pq connection string of fake server...
+func (s *Server) DSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+		serverHost, s.Port(), connstr.QuoteValue(s.cfg.User), connstr.QuoteValue(s.cfg.Password),
+		connstr.QuoteValue(s.cfg.Database))
+}
+
+// Script sets responses on query, matched by exact text without leading and trailing spaces.
+// Responses used in order, last response repeated. Scripted responses override built-in ones...
+func (s *Server) Script(query string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scripts[strings.TrimSpace(query)] = &script{responses: responses, calls: 0}
+}
+
+// FailNextStartups makes next startups fail with given error, e.g. SQLStateCannotConnectNow...
+func (s *Server) FailNextStartups(count int, err Error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failedStartups = count
+	s.startupErr = err
+}
+
+// Queries returns all received queries...
+func (s *Server) Queries() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.queries...)
+}
+
+// Reset removes all scripts and received queries...
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scripts = make(map[string]*script)
+	s.queries = nil
+	s.failedStartups = 0
+}
+
+// takeStartupFailure returns error of failed startup, if startup must be failed...
+func (s *Server) takeStartupFailure() (Error, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failedStartups == 0 {
+		return Error{}, false //nolint:exhaustruct // empty error
+	}
+
+	s.failedStartups--
+
+	return s.startupErr, true
+}
+
+// response takes scripted or built-in response on query - query recorded and scripted call consumed...
+func (s *Server) response(query string, txStatus byte) Response {
+	return s.resolveResponse(query, txStatus, true)
+}
+
+// peekResponse returns response, which will be taken by next response call, without recording of query...
+func (s *Server) peekResponse(query string, txStatus byte) Response {
+	return s.resolveResponse(query, txStatus, false)
+}
+
+func (s *Server) resolveResponse(query string, txStatus byte, isTaken bool) Response {
+	query = strings.TrimSpace(query)
+
+	s.mu.Lock()
+	scripted, isScripted := s.scripts[query]
+
+	var response Response
+
+	if isScripted && len(scripted.responses) != 0 {
+		idx := min(scripted.calls, len(scripted.responses)-1)
+		response = scripted.responses[idx]
+	}
+
+	if isTaken {
+		s.queries = append(s.queries, query)
+
+		if isScripted {
+			scripted.calls++
+		}
+	}
+	s.mu.Unlock()
+
+	if isScripted {
+		return response
+	}
+
+	return builtinResponse(query, txStatus)
+}
+
+//nolint:exhaustruct // only required fields of responses
+func builtinResponse(query string, txStatus byte) Response {
+	statement := strings.ToUpper(strings.TrimSuffix(query, ";"))
+
+	switch {
+	case statement == "":
+		return Response{}
+	case strings.HasPrefix(statement, "BEGIN"), strings.HasPrefix(statement, "START TRANSACTION"):
+		return Response{Tag: "BEGIN"}
+	case statement == "COMMIT", statement == "END":
+		if txStatus == txStatusFailed {
+			return Response{Tag: "ROLLBACK"}
+		}
+
+		return Response{Tag: "COMMIT"}
+	case statement == "ROLLBACK":
+		return Response{Tag: "ROLLBACK"}
+	case statement == "SELECT 1":
+		return Response{Columns: []string{"?column?"}, Rows: [][]any{{1}}}
+	case txStatus == txStatusFailed:
+		return Response{Err: &Error{
+			SQLState: SQLStateInFailedSQLTransaction,
+			Message:  "current transaction is aborted, commands ignored until end of transaction block",
+		}}
+	default:
+		return Response{Err: &Error{
+			SQLState: SQLStateFeatureNotSupported,
+			Message:  "query not scripted: " + query,
+		}}
+	}
+}
+
+// NewServer ....
+func NewServer(cfg Config) *Server {
+	return &Server{
+		mu:             sync.Mutex{},
+		cfg:            cfg,
+		listener:       nil,
+		sessions:       make(map[*session]struct{}),
+		wg:             sync.WaitGroup{},
+		isClosed:       false,
+		scripts:        make(map[string]*script),
+		queries:        nil,
+		failedStartups: 0,
+		startupErr:     Error{}, //nolint:exhaustruct // empty error
+	}
+}
@@ -0,0 +1,345 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgfake
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/pgerrors"
+
+	"github.com/lib/pq"
+)
+
+const balanceQuery = "SELECT balance FROM wallets WHERE id = $1"
+
+func startTestServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+
+	srv := NewServer(cfg)
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("unable to start fake server: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	return srv
+}
+
+func newTestConnection(cfg *postgres.PostgresConfig) *postgres.Connection {
+	return postgres.NewConnection(context.Background(), nil, nil, cfg,
+		postgres.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+}
+
+func connectTestServer(t *testing.T, srv *Server) *postgres.Connection {
+	t.Helper()
+
+	conn, err := newTestConnection(srv.PostgresConfig()).Connect()
+	if err != nil {
+		t.Fatalf("unable to connect to fake server: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn
+}
+
+func TestConnectRetriesFailedStartups(t *testing.T) {
+	testCases := []struct {
+		name           string
+		failedStartups int
+		retryCount     uint8
+		isConnected    bool
+	}{
+		{name: "no failures", failedStartups: 0, retryCount: 1, isConnected: true},
+		{name: "failures less than retries", failedStartups: 2, retryCount: 3, isConnected: true},
+		{name: "failures same with retries", failedStartups: 3, retryCount: 3, isConnected: false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			srv := startTestServer(t, Config{User: "wallet", Password: "secret", Database: "wallets", Auth: AuthMD5})
+			srv.FailNextStartups(testCase.failedStartups, Error{ //nolint:exhaustruct // only required fields
+				SQLState: SQLStateCannotConnectNow,
+				Message:  "the database system is starting up",
+			})
+
+			cfg := srv.PostgresConfig()
+			cfg.DBConnectRetryCount = testCase.retryCount
+
+			conn, err := newTestConnection(cfg).Connect()
+			if !testCase.isConnected {
+				if err == nil {
+					_ = conn.Close()
+
+					t.Fatal("Connect succeeded, but all startups failed")
+				}
+
+				if pgerrors.SQLState(err) != SQLStateCannotConnectNow {
+					t.Errorf("Connect error SQLSTATE = %q, want %q", pgerrors.SQLState(err), SQLStateCannotConnectNow)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Connect returned error: %v", err)
+			}
+
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			if !conn.IsHealed(context.Background()) {
+				t.Error("connection is not healed after successful Connect")
+			}
+		})
+	}
+}
+
+func TestConnectAuthentication(t *testing.T) {
+	for _, auth := range []AuthMethod{AuthTrust, AuthCleartext, AuthMD5, AuthSCRAM} {
+		t.Run(string(auth), func(t *testing.T) {
+			srv := startTestServer(t, Config{User: "wallet", Password: "se cret'", Database: "my wallets", Auth: auth})
+
+			conn := connectTestServer(t, srv)
+			if !conn.IsHealed(context.Background()) {
+				t.Error("connection is not healed")
+			}
+
+			if auth == AuthTrust {
+				return
+			}
+
+			cfg := srv.PostgresConfig()
+			cfg.DBPassword = "wrong"
+
+			_, err := newTestConnection(cfg).Connect()
+			if pgerrors.SQLState(err) != SQLStateInvalidPassword {
+				t.Errorf("Connect with wrong password error = %v, want SQLSTATE %s", err, SQLStateInvalidPassword)
+			}
+		})
+	}
+}
+
+func TestConnectRejectsUnknownRoleAndDatabase(t *testing.T) {
+	srv := startTestServer(t, Config{User: "wallet", Password: "secret", Database: "wallets", Auth: AuthSCRAM})
+
+	testCases := []struct {
+		name     string
+		modify   func(cfg *postgres.PostgresConfig)
+		sqlState string
+	}{
+		{name: "unknown role", modify: func(cfg *postgres.PostgresConfig) {
+			cfg.DBUsername = "admin"
+		}, sqlState: SQLStateInvalidAuthorization},
+		{name: "unknown database", modify: func(cfg *postgres.PostgresConfig) {
+			cfg.DBName = "audit"
+		}, sqlState: SQLStateInvalidCatalogName},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cfg := srv.PostgresConfig()
+			testCase.modify(cfg)
+
+			_, err := newTestConnection(cfg).Connect()
+			if pgerrors.SQLState(err) != testCase.sqlState {
+				t.Errorf("Connect error = %v, want SQLSTATE %s", err, testCase.sqlState)
+			}
+		})
+	}
+}
+
+func TestConnectSSLRefused(t *testing.T) {
+	srv := startTestServer(t, Config{User: "wallet", Password: "secret", Database: "wallets", Auth: AuthSCRAM})
+
+	cfg := srv.PostgresConfig()
+	cfg.DBSSLMode = "require"
+
+	// lib/pq sends SSLRequest, server refuses it
+	_, err := newTestConnection(cfg).Connect()
+	if !errors.Is(err, pq.ErrSSLNotSupported) {
+		t.Errorf("Connect error = %v, want %v", err, pq.ErrSSLNotSupported)
+	}
+}
+
+func TestIsHealed(t *testing.T) {
+	srv := startTestServer(t, Config{User: "wallet", Password: "secret", Database: "wallets", Auth: AuthSCRAM})
+	conn := connectTestServer(t, srv)
+
+	if !conn.IsHealed(context.Background()) {
+		t.Fatal("connection is not healed")
+	}
+
+	srv.Script("SELECT 1", Response{Err: &Error{ //nolint:exhaustruct // only required fields
+		SQLState: "57P01",
+		Message:  "terminating connection due to administrator command",
+	}})
+
+	if conn.IsHealed(context.Background()) {
+		t.Error("connection is healed, but health-check query failed")
+	}
+
+	srv.Reset()
+
+	if !conn.IsHealed(context.Background()) {
+		t.Error("connection is not healed after health-check query recovered")
+	}
+
+	err := srv.Close()
+	if err != nil {
+		t.Fatalf("unable to close fake server: %v", err)
+	}
+
+	if conn.IsHealed(context.Background()) {
+		t.Error("connection is healed, but server closed")
+	}
+}
+
+func TestMidQueryDisconnect(t *testing.T) {
+	srv := startTestServer(t, Config{User: "wallet", Password: "secret", Database: "wallets", Auth: AuthSCRAM})
+	conn := connectTestServer(t, srv)
+
+	srv.Script(balanceQuery, Response{ //nolint:exhaustruct // only required fields
+		Columns:    []string{"balance"},
+		Rows:       [][]any{{"10.5"}},
+		Disconnect: true,
+	})
+
+	var balances []string
+
+	err := conn.Dbx.SelectContext(context.Background(), &balances, balanceQuery, 1)
+	if err == nil {
+		t.Fatal("query succeeded, but connection closed before command completion")
+	}
+
+	if !postgres.IsConnectionError(err) {
+		t.Errorf("query error = %v, want connection error", err)
+	}
+}
+
+func TestExtendedQueryResponseResolvedOnExecute(t *testing.T) {
+	srv := startTestServer(t, Config{User: "wallet", Password: "secret", Database: "wallets", Auth: AuthSCRAM})
+	conn := connectTestServer(t, srv)
+	ctx := context.Background()
+
+	srv.Script(balanceQuery,
+		Response{Columns: []string{"balance"}, Rows: [][]any{{"10.5"}}}, //nolint:exhaustruct // only rows
+		Response{Columns: []string{"balance"}, Rows: [][]any{{"7.25"}}}, //nolint:exhaustruct // only rows
+	)
+
+	// statement parsed once and executed twice - every execution takes own scripted response
+	stmt, err := conn.Dbx.PreparexContext(ctx, balanceQuery)
+	if err != nil {
+		t.Fatalf("unable to prepare statement: %v", err)
+	}
+
+	defer func() {
+		_ = stmt.Close()
+	}()
+
+	for _, want := range []string{"10.5", "7.25"} {
+		var balance string
+
+		err = stmt.GetContext(ctx, &balance, 1)
+		if err != nil {
+			t.Fatalf("query returned error: %v", err)
+		}
+
+		if balance != want {
+			t.Errorf("balance = %s, want %s", balance, want)
+		}
+	}
+
+	executed := 0
+
+	for _, query := range srv.Queries() {
+		if query == balanceQuery {
+			executed++
+		}
+	}
+
+	if executed != 2 {
+		t.Errorf("query recorded %d times, want 2", executed)
+	}
+}
+
+func TestExtendedQueryInFailedTransaction(t *testing.T) {
+	srv := startTestServer(t, Config{User: "wallet", Password: "secret", Database: "wallets", Auth: AuthSCRAM})
+	conn := connectTestServer(t, srv)
+	ctx := context.Background()
+
+	srv.Script("UPDATE wallets SET balance = 0", Response{Err: &Error{ //nolint:exhaustruct // only required fields
+		SQLState: "40001",
+		Message:  "could not serialize access due to concurrent update",
+	}})
+	srv.Script(balanceQuery, Response{Columns: []string{"balance"}, Rows: [][]any{{"10.5"}}}) //nolint:exhaustruct,lll // only rows
+
+	tx, err := conn.Dbx.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatalf("unable to begin transaction: %v", err)
+	}
+
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	stmt, err := tx.PreparexContext(ctx, "SELECT count(*) FROM wallets WHERE id = $1")
+	if err != nil {
+		t.Fatalf("unable to prepare statement: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE wallets SET balance = 0")
+	if pgerrors.SQLState(err) != "40001" {
+		t.Fatalf("update error = %v, want SQLSTATE 40001", err)
+	}
+
+	// statement parsed before failure, but executed in failed transaction
+	var count int
+
+	err = stmt.GetContext(ctx, &count, 1)
+	if pgerrors.SQLState(err) != SQLStateInFailedSQLTransaction {
+		t.Errorf("query error = %v, want SQLSTATE %s", err, SQLStateInFailedSQLTransaction)
+	}
+}
@@ -0,0 +1,439 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgfake
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	txStatusIdle          = 'I'
+	txStatusInTransaction = 'T'
+	txStatusFailed        = 'E'
+
+	defaultSeverity = "ERROR"
+)
+
+// parameterPattern matches positional parameters of extended query...
+var parameterPattern = regexp.MustCompile(`\$(\d+)`)
+
+// session is the single client connection of fake server...
+type session struct {
+	server *Server
+	conn   net.Conn
+	reader *bufio.Reader
+	out    *writeBuffer
+
+	user     string
+	txStatus byte
+
+	// text and result columns of parsed extended query, response resolved on Execute
+	extendedQuery   string
+	extendedColumns []string
+	// skipUntilSync - error occurred in extended query, messages ignored until Sync
+	skipUntilSync bool
+}
+
+func (s *session) serve() {
+	defer func() {
+		_ = s.conn.Close()
+	}()
+
+	isReady, err := s.startup()
+	if err != nil || !isReady {
+		return
+	}
+
+	for {
+		msgType, body, readErr := readMessage(s.reader)
+		if readErr != nil {
+			return
+		}
+
+		isOpen, handleErr := s.handle(msgType, body)
+		if handleErr != nil || !isOpen {
+			return
+		}
+	}
+}
+
+// startup negotiates SSL, authenticates client and sends initial parameters...
+func (s *session) startup() (bool, error) {
+	for {
+		body, err := readStartupMessage(s.reader)
+		if err != nil {
+			return false, err
+		}
+
+		buf := &readBuffer{data: body, err: nil}
+
+		switch code := buf.int32(); code {
+		case sslRequestCode, gssEncRequestCode:
+			// encryption not supported, client may continue without it
+			_, err = s.conn.Write([]byte{'N'})
+			if err != nil {
+				return false, err //nolint:wrapcheck // connection errors returned as is
+			}
+
+			continue
+		case cancelRequestCode:
+			return false, nil
+		case protocolVersion3:
+			return s.startupV3(buf)
+		default:
+			return false, s.fatal(Error{ //nolint:exhaustruct // only required fields
+				SQLState: SQLStateProtocolViolation,
+				Message:  fmt.Sprintf("unsupported frontend protocol %d", code),
+			})
+		}
+	}
+}
+
+func (s *session) startupV3(buf *readBuffer) (bool, error) {
+	params := make(map[string]string)
+
+	for {
+		key := buf.string()
+		if key == "" || buf.err != nil {
+			break
+		}
+
+		params[key] = buf.string()
+	}
+
+	s.user = params["user"]
+
+	startupErr, isFailed := s.server.takeStartupFailure()
+	if isFailed {
+		return false, s.fatal(startupErr)
+	}
+
+	cfg := s.server.cfg
+	if cfg.User != "" && s.user != cfg.User {
+		return false, s.fatal(Error{ //nolint:exhaustruct // only required fields
+			SQLState: SQLStateInvalidAuthorization,
+			Message:  fmt.Sprintf("role %q does not exist", s.user),
+		})
+	}
+
+	err := s.authenticate()
+	if err != nil {
+		return false, s.fatal(Error{ //nolint:exhaustruct // only required fields
+			SQLState: SQLStateInvalidPassword,
+			Message:  fmt.Sprintf("password authentication failed for user %q", s.user),
+		})
+	}
+
+	if cfg.Database != "" && params["database"] != cfg.Database {
+		return false, s.fatal(Error{ //nolint:exhaustruct // only required fields
+			SQLState: SQLStateInvalidCatalogName,
+			Message:  fmt.Sprintf("database %q does not exist", params["database"]),
+		})
+	}
+
+	s.out.message('R').int32(authOK)
+
+	for key, value := range map[string]string{
+		"server_version":              serverVersion,
+		"server_encoding":             "UTF8",
+		"client_encoding":             "UTF8",
+		"DateStyle":                   "ISO, MDY",
+		"TimeZone":                    "UTC",
+		"integer_datetimes":           "on",
+		"standard_conforming_strings": "on",
+	} {
+		s.out.message('S').string(key).string(value)
+	}
+
+	s.out.message('K').int32(1).int32(1)
+	s.out.message('Z').byte(s.txStatus)
+
+	return true, s.out.flush(s.conn)
+}
+
+// handle processes frontend message, returns false if connection must be closed...
+func (s *session) handle(msgType byte, body []byte) (bool, error) {
+	buf := &readBuffer{data: body, err: nil}
+
+	if s.skipUntilSync && msgType != 'S' && msgType != 'X' {
+		return true, nil
+	}
+
+	switch msgType {
+	case 'Q':
+		return s.simpleQuery(buf.string())
+	case 'P':
+		buf.string() // statement name
+		s.parse(buf.string())
+	case 'D':
+		s.describe()
+	case 'B':
+		s.out.message('2')
+	case 'E':
+		return s.execute()
+	case 'C':
+		s.out.message('3')
+	case 'H':
+		return true, s.out.flush(s.conn)
+	case 'S':
+		s.skipUntilSync = false
+		s.out.message('Z').byte(s.txStatus)
+
+		return true, s.out.flush(s.conn)
+	case 'X':
+		return false, nil
+	default:
+		return false, s.fatal(Error{ //nolint:exhaustruct // only required fields
+			SQLState: SQLStateProtocolViolation,
+			Message:  fmt.Sprintf("unsupported frontend message %q", msgType),
+		})
+	}
+
+	return true, nil
+}
+
+func (s *session) simpleQuery(query string) (bool, error) {
+	response := s.server.response(query, s.txStatus)
+
+	time.Sleep(response.Delay)
+
+	if response.Err != nil {
+		s.writeError(response.Err)
+	} else {
+		if strings.TrimSpace(query) == "" {
+			s.out.message('I')
+		}
+
+		if len(response.Columns) != 0 {
+			s.writeRowDescription(response.Columns, textFormatCode)
+		}
+
+		s.writeRows(response.Rows)
+
+		if response.Disconnect {
+			_ = s.out.flush(s.conn)
+
+			return false, nil
+		}
+
+		if strings.TrimSpace(query) != "" {
+			s.writeCommandComplete(&response, query)
+		}
+	}
+
+	s.out.message('Z').byte(s.txStatus)
+
+	return true, s.out.flush(s.conn)
+}
+
+// parse stores extended query. Response is not taken on Parse - scripted call consumed and transaction status
+// checked on Execute, only result columns peeked for Describe...
+func (s *session) parse(query string) {
+	response := s.server.peekResponse(query, s.txStatus)
+
+	s.extendedQuery = query
+	s.extendedColumns = response.Columns
+
+	s.out.message('1')
+}
+
+func (s *session) describe() {
+	paramsCount := 0
+
+	for _, match := range parameterPattern.FindAllStringSubmatch(s.extendedQuery, -1) {
+		number, _ := strconv.Atoi(match[1])
+		paramsCount = max(paramsCount, number)
+	}
+
+	s.out.message('t').int16(int16(paramsCount)) //nolint:gosec // count of parameters limited by protocol
+
+	for range paramsCount {
+		s.out.int32(0)
+	}
+
+	if len(s.extendedColumns) == 0 {
+		s.out.message('n')
+
+		return
+	}
+
+	s.writeRowDescription(s.extendedColumns, textFormatCode)
+}
+
+func (s *session) execute() (bool, error) {
+	response := s.server.response(s.extendedQuery, s.txStatus)
+
+	time.Sleep(response.Delay)
+
+	if response.Err != nil {
+		s.writeError(response.Err)
+		s.skipUntilSync = true
+
+		return true, nil
+	}
+
+	s.writeRows(response.Rows)
+
+	if response.Disconnect {
+		_ = s.out.flush(s.conn)
+
+		return false, nil
+	}
+
+	tag := commandTag(&response, s.extendedQuery)
+
+	s.applyTag(tag)
+	s.out.message('C').string(tag)
+
+	return true, nil
+}
+
+func (s *session) writeRowDescription(columns []string, formatCode int16) {
+	s.out.message('T').int16(int16(len(columns))) //nolint:gosec // count of columns limited by protocol
+
+	for _, column := range columns {
+		s.out.string(column).
+			int32(0).                  // table oid
+			int16(0).                  // column attribute number
+			int32(textTypeOID).        // type oid
+			int16(variableTypeLength). // type size
+			int32(-1).                 // type modifier
+			int16(formatCode)
+	}
+}
+
+func (s *session) writeRows(rows [][]any) {
+	for _, row := range rows {
+		s.out.message('D').int16(int16(len(row))) //nolint:gosec // count of columns limited by protocol
+
+		for _, value := range row {
+			if value == nil {
+				s.out.int32(-1)
+
+				continue
+			}
+
+			text, isBytes := value.([]byte)
+			if !isBytes {
+				text = []byte(fmt.Sprint(value))
+			}
+
+			s.out.int32(int32(len(text))).bytes(text) //nolint:gosec // size of value limited by protocol
+		}
+	}
+}
+
+func (s *session) writeCommandComplete(response *Response, query string) {
+	tag := commandTag(response, query)
+
+	s.applyTag(tag)
+	s.out.message('C').string(tag)
+}
+
+func (s *session) writeError(respErr *Error) {
+	if s.txStatus == txStatusInTransaction {
+		s.txStatus = txStatusFailed
+	}
+
+	s.writeErrorMessage(respErr)
+}
+
+func (s *session) writeErrorMessage(respErr *Error) {
+	severity := respErr.Severity
+	if severity == "" {
+		severity = defaultSeverity
+	}
+
+	s.out.message('E').
+		byte('S').string(severity).
+		byte('V').string(severity).
+		byte('C').string(respErr.SQLState).
+		byte('M').string(respErr.Message)
+
+	if respErr.Detail != "" {
+		s.out.byte('D').string(respErr.Detail)
+	}
+
+	s.out.byte(0)
+}
+
+// fatal sends FATAL error, connection closed after it...
+func (s *session) fatal(respErr Error) error {
+	respErr.Severity = "FATAL"
+
+	s.writeErrorMessage(&respErr)
+
+	return s.out.flush(s.conn)
+}
+
+// applyTag changes transaction status by command tag...
+func (s *session) applyTag(tag string) {
+	switch tag {
+	case "BEGIN":
+		s.txStatus = txStatusInTransaction
+	case "COMMIT", "ROLLBACK":
+		s.txStatus = txStatusIdle
+	}
+}
+
+func commandTag(response *Response, query string) string {
+	if response.Tag != "" {
+		return response.Tag
+	}
+
+	if strings.TrimSpace(query) == "" {
+		return ""
+	}
+
+	return "SELECT " + strconv.Itoa(len(response.Rows))
+}
+
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server:          server,
+		conn:            conn,
+		reader:          bufio.NewReader(conn),
+		out:             &writeBuffer{},
+		user:            "",
+		txStatus:        txStatusIdle,
+		extendedQuery:   "",
+		extendedColumns: nil,
+		skipUntilSync:   false,
+	}
+}
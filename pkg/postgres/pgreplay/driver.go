@@ -0,0 +1,537 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgreplay
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/internal/driverwrap"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	_ driver.Conn               = (*replayConn)(nil)
+	_ driver.ConnBeginTx        = (*replayConn)(nil)
+	_ driver.ConnPrepareContext = (*replayConn)(nil)
+	_ driver.ExecerContext      = (*replayConn)(nil)
+	_ driver.QueryerContext     = (*replayConn)(nil)
+	_ driver.Pinger             = (*replayConn)(nil)
+	_ driver.SessionResetter    = (*replayConn)(nil)
+	_ driver.Validator          = (*replayConn)(nil)
+	_ driver.StmtExecContext    = (*replayStmt)(nil)
+	_ driver.StmtQueryContext   = (*replayStmt)(nil)
+
+	_ driver.RowsColumnTypeDatabaseTypeName = (*replayRows)(nil)
+)
+
+// Register registers record-and-replay wrapper of lib/pq driver under given name. Driver name can be used by
+// postgres.Connection SetDriverName function or PostgresConfig DBDriverName field.
+// In record mode golden file created or truncated, in replay mode golden file loaded and database not used.
+// Same with sql.Register function panics if name already registered...
+func Register(name string, mode Mode, goldenPath string) (*Golden, error) {
+	return RegisterDriver(name, &pq.Driver{}, mode, goldenPath)
+}
+
+// RegisterDriver registers record-and-replay wrapper of given driver under given name...
+func RegisterDriver(name string, base driver.Driver, mode Mode, goldenPath string) (*Golden, error) {
+	var (
+		golden *Golden
+		err    error
+	)
+
+	switch mode {
+	case ModeRecord:
+		golden, err = createGolden(goldenPath)
+	default:
+		golden, err = loadGolden(goldenPath)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sql.Register(name, &replayDriver{base: base, golden: golden})
+	sqlx.BindDriver(name, sqlx.DOLLAR)
+
+	return golden, nil
+}
+
+type replayDriver struct {
+	base   driver.Driver
+	golden *Golden
+}
+
+func (d *replayDriver) Open(dsn string) (driver.Conn, error) {
+	if d.golden.mode == ModeReplay {
+		return &replayConn{base: nil, golden: d.golden}, nil
+	}
+
+	conn, err := d.base.Open(dsn)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return &replayConn{base: conn, golden: d.golden}, nil
+}
+
+// replayConn is the connection of record-and-replay driver. In replay mode base connection is nil...
+type replayConn struct {
+	base   driver.Conn
+	golden *Golden
+}
+
+func (c *replayConn) isReplay() bool {
+	return c.base == nil
+}
+
+func (c *replayConn) exec(query string,
+	args []driver.NamedValue,
+	call func() (driver.Result, error),
+) (driver.Result, error) {
+	encodedArgs, err := encodeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.isReplay() {
+		recorded, takeErr := c.golden.take(kindExec, query, encodedArgs)
+		if takeErr != nil {
+			return nil, takeErr
+		}
+
+		if recorded.Err != nil {
+			return nil, recorded.Err.restore()
+		}
+
+		return driver.RowsAffected(recorded.RowsAffected), nil
+	}
+
+	recorded := &interaction{ //nolint:exhaustruct // exec interaction without result set
+		Kind:      kindExec,
+		Statement: query,
+		Args:      encodedArgs,
+	}
+
+	result, err := call()
+	if err != nil {
+		// bad connection errors not recorded - database/sql retries statement on another connection
+		if errors.Is(err, driver.ErrBadConn) {
+			return nil, err
+		}
+
+		recorded.Err = newRecordedError(err)
+
+		return nil, errors.Join(err, c.golden.record(recorded))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err == nil {
+		recorded.RowsAffected = rowsAffected
+	}
+
+	err = c.golden.record(recorded)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.RowsAffected(recorded.RowsAffected), nil
+}
+
+func (c *replayConn) query(query string,
+	args []driver.NamedValue,
+	call func() (driver.Rows, error),
+) (driver.Rows, error) {
+	encodedArgs, err := encodeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.isReplay() {
+		recorded, takeErr := c.golden.take(kindQuery, query, encodedArgs)
+		if takeErr != nil {
+			return nil, takeErr
+		}
+
+		if recorded.Err != nil {
+			return nil, recorded.Err.restore()
+		}
+
+		return newReplayRows(recorded), nil
+	}
+
+	recorded := &interaction{ //nolint:exhaustruct // result set filled by drainRows
+		Kind:      kindQuery,
+		Statement: query,
+		Args:      encodedArgs,
+	}
+
+	rows, err := call()
+	if err != nil {
+		if errors.Is(err, driver.ErrBadConn) {
+			return nil, err
+		}
+
+		recorded.Err = newRecordedError(err)
+
+		return nil, errors.Join(err, c.golden.record(recorded))
+	}
+
+	err = drainRows(rows, recorded)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.golden.record(recorded)
+	if err != nil {
+		return nil, err
+	}
+
+	// recorded result set served in record mode too - both modes return same values
+	return newReplayRows(recorded), nil
+}
+
+func (c *replayConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *replayConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.isReplay() {
+		return &replayStmt{base: nil, conn: c, query: query}, nil
+	}
+
+	stmt, err := driverwrap.PrepareContext(ctx, c.base, query)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // driver errors returned as is
+	}
+
+	return &replayStmt{base: stmt, conn: c, query: query}, nil
+}
+
+func (c *replayConn) Close() error {
+	if c.isReplay() {
+		return nil
+	}
+
+	return c.base.Close() //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *replayConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{Isolation: 0, ReadOnly: false})
+}
+
+// BeginTx starts transaction of base connection. Transaction boundaries - begin, commit and rollback -
+// recorded as interactions, in replay mode transaction served from golden file...
+func (c *replayConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+
+	err := c.boundary(kindBegin, beginStatement(opts), func() error {
+		var beginErr error
+
+		tx, beginErr = driverwrap.BeginTx(ctx, c.base, opts)
+
+		return beginErr //nolint:wrapcheck // driver errors returned as is
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &replayTx{base: tx, conn: c}, nil
+}
+
+// boundary records transaction boundary in record mode or takes it from golden file in replay mode...
+func (c *replayConn) boundary(kind, statement string, call func() error) error {
+	if c.isReplay() {
+		recorded, err := c.golden.take(kind, statement, nil)
+		if err != nil {
+			return err
+		}
+
+		if recorded.Err != nil {
+			return recorded.Err.restore()
+		}
+
+		return nil
+	}
+
+	err := call()
+	if err != nil && errors.Is(err, driver.ErrBadConn) {
+		return err
+	}
+
+	recorded := &interaction{ //nolint:exhaustruct // transaction boundary without arguments and result set
+		Kind:      kind,
+		Statement: statement,
+	}
+
+	if err != nil {
+		recorded.Err = newRecordedError(err)
+
+		return errors.Join(err, c.golden.record(recorded))
+	}
+
+	return c.golden.record(recorded)
+}
+
+func (c *replayConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.isReplay() {
+		return c.exec(query, args, nil)
+	}
+
+	execer, isExecer := c.base.(driver.ExecerContext)
+	if !isExecer {
+		return nil, driver.ErrSkip
+	}
+
+	return c.exec(query, args, func() (driver.Result, error) {
+		return execer.ExecContext(ctx, query, args) //nolint:wrapcheck // driver errors returned as is
+	})
+}
+
+func (c *replayConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.isReplay() {
+		return c.query(query, args, nil)
+	}
+
+	queryer, isQueryer := c.base.(driver.QueryerContext)
+	if !isQueryer {
+		return nil, driver.ErrSkip
+	}
+
+	return c.query(query, args, func() (driver.Rows, error) {
+		return queryer.QueryContext(ctx, query, args) //nolint:wrapcheck // driver errors returned as is
+	})
+}
+
+func (c *replayConn) Ping(ctx context.Context) error {
+	if c.isReplay() {
+		return nil
+	}
+
+	return driverwrap.Ping(ctx, c.base) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *replayConn) ResetSession(ctx context.Context) error {
+	if c.isReplay() {
+		return nil
+	}
+
+	return driverwrap.ResetSession(ctx, c.base) //nolint:wrapcheck // driver errors returned as is
+}
+
+func (c *replayConn) IsValid() bool {
+	if c.isReplay() {
+		return true
+	}
+
+	return driverwrap.IsValid(c.base)
+}
+
+// replayStmt is the prepared statement of record-and-replay driver. In replay mode base statement is nil...
+type replayStmt struct {
+	base  driver.Stmt
+	conn  *replayConn
+	query string
+}
+
+func (s *replayStmt) Close() error {
+	if s.base == nil {
+		return nil
+	}
+
+	return s.base.Close() //nolint:wrapcheck // driver errors returned as is
+}
+
+func (s *replayStmt) NumInput() int {
+	if s.base == nil {
+		return -1
+	}
+
+	return s.base.NumInput()
+}
+
+func (s *replayStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), driverwrap.NamedValues(args))
+}
+
+func (s *replayStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.exec(s.query, args, func() (driver.Result, error) {
+		return driverwrap.StmtExecContext(ctx, s.base, args) //nolint:wrapcheck // driver errors returned as is
+	})
+}
+
+func (s *replayStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), driverwrap.NamedValues(args))
+}
+
+func (s *replayStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.query(s.query, args, func() (driver.Rows, error) {
+		return driverwrap.StmtQueryContext(ctx, s.base, args) //nolint:wrapcheck // driver errors returned as is
+	})
+}
+
+// replayTx is the transaction of record-and-replay driver. In replay mode base transaction is nil...
+type replayTx struct {
+	base driver.Tx
+	conn *replayConn
+}
+
+func (t *replayTx) Commit() error {
+	return t.conn.boundary(kindCommit, "", func() error {
+		return t.base.Commit() //nolint:wrapcheck // driver errors returned as is
+	})
+}
+
+func (t *replayTx) Rollback() error {
+	return t.conn.boundary(kindRollback, "", func() error {
+		return t.base.Rollback() //nolint:wrapcheck // driver errors returned as is
+	})
+}
+
+// beginStatement describes transaction options of begin interaction, e.g. BEGIN ISOLATION LEVEL SERIALIZABLE...
+func beginStatement(opts driver.TxOptions) string {
+	statement := "BEGIN"
+
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		statement += " ISOLATION LEVEL " + strings.ToUpper(sql.IsolationLevel(opts.Isolation).String())
+	}
+
+	if opts.ReadOnly {
+		statement += " READ ONLY"
+	}
+
+	return statement
+}
+
+// drainRows reads whole result set of base rows to interaction and closes base rows...
+func drainRows(rows driver.Rows, recorded *interaction) error {
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	recorded.Columns = rows.Columns()
+
+	if typedRows, isTyped := rows.(driver.RowsColumnTypeDatabaseTypeName); isTyped {
+		recorded.ColumnTypes = make([]string, len(recorded.Columns))
+
+		for i := range recorded.Columns {
+			recorded.ColumnTypes[i] = typedRows.ColumnTypeDatabaseTypeName(i)
+		}
+	}
+
+	dest := make([]driver.Value, len(recorded.Columns))
+
+	for {
+		err := rows.Next(dest)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			recorded.RowsErr = newRecordedError(err)
+
+			return nil
+		}
+
+		// values encoded immediately - driver can reuse byte slices of dest on next call
+		row, err := encodeValues(dest)
+		if err != nil {
+			return err
+		}
+
+		recorded.Rows = append(recorded.Rows, row)
+	}
+}
+
+// replayRows serves recorded result set...
+type replayRows struct {
+	recorded *interaction
+	index    int
+}
+
+func newReplayRows(recorded *interaction) *replayRows {
+	return &replayRows{
+		recorded: recorded,
+		index:    0,
+	}
+}
+
+func (r *replayRows) Columns() []string {
+	return r.recorded.Columns
+}
+
+func (r *replayRows) ColumnTypeDatabaseTypeName(index int) string {
+	if index >= len(r.recorded.ColumnTypes) {
+		return ""
+	}
+
+	return r.recorded.ColumnTypes[index]
+}
+
+func (r *replayRows) Close() error {
+	return nil
+}
+
+func (r *replayRows) Next(dest []driver.Value) error {
+	if r.index >= len(r.recorded.Rows) {
+		if r.recorded.RowsErr != nil {
+			return r.recorded.RowsErr.restore()
+		}
+
+		return io.EOF
+	}
+
+	row := r.recorded.Rows[r.index]
+	r.index++
+
+	for i := range dest {
+		if i >= len(row) {
+			return ErrInvalidGoldenFile
+		}
+
+		decoded, err := row[i].decode()
+		if err != nil {
+			return err
+		}
+
+		dest[i] = decoded
+	}
+
+	return nil
+}
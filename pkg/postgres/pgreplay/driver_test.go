@@ -0,0 +1,225 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgreplay
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres/pgfake"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	testSelectQuery = "SELECT name FROM wallets WHERE id = $1"
+	testUpdateQuery = "UPDATE wallets SET name = $1 WHERE id = $2"
+)
+
+type testWallet struct {
+	Name string `db:"name"`
+}
+
+// recordGolden records wallets session against fake server and returns path of golden file...
+func recordGolden(t *testing.T) string {
+	t.Helper()
+
+	srv := pgfake.NewServer(pgfake.Config{User: "wallet", Password: "secret", Database: "wallets"})
+
+	err := srv.Start()
+	if err != nil {
+		t.Fatalf("unable to start fake server: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	srv.Script(testSelectQuery, pgfake.Response{Columns: []string{"name"}, Rows: [][]any{{"cold"}}})
+	srv.Script(testUpdateQuery, pgfake.Response{Tag: "UPDATE 1"})
+
+	goldenPath := filepath.Join(t.TempDir(), "wallets.golden.jsonl")
+	driverName := "pgreplay-record-" + t.Name()
+
+	golden, err := RegisterDriver(driverName, &pq.Driver{}, ModeRecord, goldenPath)
+	if err != nil {
+		t.Fatalf("unable to register record driver: %v", err)
+	}
+
+	db, err := sqlx.Open(driverName, srv.DSN())
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+
+	runWalletsSession(t, db)
+
+	_ = db.Close()
+
+	err = golden.Close()
+	if err != nil {
+		t.Fatalf("unable to close golden file: %v", err)
+	}
+
+	return goldenPath
+}
+
+// runWalletsSession runs same statements in record and replay modes...
+func runWalletsSession(t *testing.T, db *sqlx.DB) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	var wallet testWallet
+
+	err := db.GetContext(ctx, &wallet, testSelectQuery, int64(1))
+	if err != nil {
+		t.Fatalf("select returned error: %v", err)
+	}
+
+	if wallet.Name != "cold" {
+		t.Errorf("wallet name = %q, want cold", wallet.Name)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin returned error: %v", err)
+	}
+
+	result, err := tx.ExecContext(ctx, testUpdateQuery, "hot", int64(1))
+	if err != nil {
+		t.Fatalf("update returned error: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected != 1 {
+		t.Errorf("rows affected = %d, %v, want 1", rowsAffected, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("commit returned error: %v", err)
+	}
+}
+
+func openReplay(t *testing.T, goldenPath string) (*sqlx.DB, *Golden) {
+	t.Helper()
+
+	driverName := "pgreplay-replay-" + t.Name()
+
+	golden, err := RegisterDriver(driverName, &pq.Driver{}, ModeReplay, goldenPath)
+	if err != nil {
+		t.Fatalf("unable to register replay driver: %v", err)
+	}
+
+	// database not used in replay mode, so connection string is never dialed
+	db, err := sqlx.Open(driverName, "host=unreachable.invalid")
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db, golden
+}
+
+func TestRecordReplayRoundTrip(t *testing.T) {
+	goldenPath := recordGolden(t)
+
+	content, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("unable to read golden file: %v", err)
+	}
+
+	for _, kind := range []string{`"kind":"begin"`, `"kind":"exec"`, `"kind":"commit"`, `"kind":"query"`} {
+		if !strings.Contains(string(content), kind) {
+			t.Errorf("golden file has no %s interaction:\n%s", kind, content)
+		}
+	}
+
+	db, golden := openReplay(t, goldenPath)
+
+	runWalletsSession(t, db)
+
+	if golden.Remaining() != 0 {
+		t.Errorf("remaining interactions = %d, want 0", golden.Remaining())
+	}
+}
+
+func TestReplayArgumentsMismatch(t *testing.T) {
+	db, _ := openReplay(t, recordGolden(t))
+
+	var wallet testWallet
+
+	err := db.GetContext(context.Background(), &wallet, testSelectQuery, int64(2))
+	if !errors.Is(err, ErrArgumentsMismatch) {
+		t.Errorf("select error = %v, want %v", err, ErrArgumentsMismatch)
+	}
+}
+
+func TestReplayUnexpectedStatement(t *testing.T) {
+	db, golden := openReplay(t, recordGolden(t))
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, "DELETE FROM wallets WHERE id = $1", int64(1))
+	if !errors.Is(err, ErrUnexpectedStatement) {
+		t.Errorf("delete error = %v, want %v", err, ErrUnexpectedStatement)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("begin returned error: %v", err)
+	}
+
+	// rollback not recorded - session committed transaction
+	err = tx.Rollback()
+	if !errors.Is(err, ErrUnexpectedStatement) {
+		t.Errorf("rollback error = %v, want %v", err, ErrUnexpectedStatement)
+	}
+
+	// only one transaction recorded
+	_, err = db.BeginTx(ctx, nil)
+	if !errors.Is(err, ErrUnexpectedStatement) {
+		t.Errorf("second begin error = %v, want %v", err, ErrUnexpectedStatement)
+	}
+
+	if golden.Remaining() != 3 {
+		t.Errorf("remaining interactions = %d, want 3", golden.Remaining())
+	}
+}
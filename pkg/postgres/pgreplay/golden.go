@@ -0,0 +1,303 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgreplay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnexpectedStatement = errors.New("unexpected statement in replay mode")
+	ErrArgumentsMismatch   = errors.New("statement arguments mismatch in replay mode")
+	ErrUnsupportedValue    = errors.New("unsupported driver value type")
+	ErrInvalidGoldenFile   = errors.New("invalid golden file")
+	ErrGoldenFileClosed    = errors.New("golden file already closed")
+)
+
+// Mode is the mode of record-and-replay driver...
+type Mode uint8
+
+const (
+	// ModeReplay - results served from golden file, database not used
+	ModeReplay Mode = iota
+	// ModeRecord - statements proxied to database and written to golden file
+	ModeRecord
+)
+
+// RecordEnv is the name of environment variable, which switches ModeFromEnv to ModeRecord...
+const RecordEnv = "POSTGRES_REPLAY_RECORD"
+
+// ModeFromEnv returns ModeRecord if RecordEnv environment variable is set to "1" or "true", otherwise ModeReplay...
+func ModeFromEnv() Mode {
+	switch strings.ToLower(os.Getenv(RecordEnv)) {
+	case "1", "true":
+		return ModeRecord
+	default:
+		return ModeReplay
+	}
+}
+
+const (
+	kindExec     = "exec"
+	kindQuery    = "query"
+	kindBegin    = "begin"
+	kindCommit   = "commit"
+	kindRollback = "rollback"
+)
+
+// interaction is the single recorded statement with arguments and result, one JSON line of golden file...
+type interaction struct {
+	Kind      string  `json:"kind"`
+	Statement string  `json:"statement"`
+	Args      []value `json:"args,omitempty"`
+
+	Columns      []string  `json:"columns,omitempty"`
+	ColumnTypes  []string  `json:"column_types,omitempty"`
+	Rows         [][]value `json:"rows,omitempty"`
+	RowsAffected int64     `json:"rows_affected,omitempty"`
+
+	Err     *recordedError `json:"error,omitempty"`
+	RowsErr *recordedError `json:"rows_error,omitempty"`
+
+	// used - interaction already served in replay mode
+	used bool
+}
+
+func (i *interaction) argsEqual(args []value) bool {
+	if len(i.Args) != len(args) {
+		return false
+	}
+
+	for idx := range args {
+		if i.Args[idx] != args[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Golden is the golden file of recorded statements. In record mode every interaction appended to file
+// as JSON line, in replay mode interactions served in recorded order of equal statements...
+type Golden struct {
+	mu sync.Mutex
+
+	mode Mode
+	path string
+
+	file *os.File
+
+	interactions []*interaction
+}
+
+// Mode returns mode of golden file...
+func (g *Golden) Mode() Mode {
+	return g.mode
+}
+
+// Path returns path of golden file...
+func (g *Golden) Path() string {
+	return g.path
+}
+
+// Remaining returns count of recorded, but not served in replay mode interactions.
+// Can be used at the end of test to check that all recorded statements executed...
+func (g *Golden) Remaining() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	count := 0
+
+	for _, recorded := range g.interactions {
+		if !recorded.used {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Close closes golden file in record mode, in replay mode does nothing...
+func (g *Golden) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.file == nil {
+		return nil
+	}
+
+	err := g.file.Close()
+	g.file = nil
+
+	if err != nil {
+		return fmt.Errorf("unable to close golden file: %w", err)
+	}
+
+	return nil
+}
+
+func (g *Golden) record(recorded *interaction) error {
+	line := bytes.Buffer{}
+
+	// HTML escaping disabled - statements in golden file stay readable, e.g. comparison operators
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+
+	err := encoder.Encode(recorded)
+	if err != nil {
+		return fmt.Errorf("unable to marshal interaction: %w", err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.file == nil {
+		return ErrGoldenFileClosed
+	}
+
+	// written on every interaction without buffering - golden file stays consistent even if test not closed it
+	_, err = g.file.Write(line.Bytes())
+	if err != nil {
+		return fmt.Errorf("unable to write golden file: %w", err)
+	}
+
+	return nil
+}
+
+// take returns first not served interaction with same kind, statement and arguments...
+func (g *Golden) take(kind, statement string, args []value) (*interaction, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var sameStatement *interaction
+
+	for _, recorded := range g.interactions {
+		if recorded.used || recorded.Kind != kind || recorded.Statement != statement {
+			continue
+		}
+
+		if recorded.argsEqual(args) {
+			recorded.used = true
+
+			return recorded, nil
+		}
+
+		if sameStatement == nil {
+			sameStatement = recorded
+		}
+	}
+
+	if sameStatement != nil {
+		return nil, fmt.Errorf("%w: %s: expected %s, got %s", ErrArgumentsMismatch, statement,
+			formatArgs(sameStatement.Args), formatArgs(args))
+	}
+
+	return nil, fmt.Errorf("%w: %s: %s", ErrUnexpectedStatement, kind, statement)
+}
+
+func formatArgs(args []value) string {
+	formatted := make([]string, len(args))
+
+	for i, arg := range args {
+		formatted[i] = arg.String()
+	}
+
+	return "[" + strings.Join(formatted, ", ") + "]"
+}
+
+func createGolden(path string) (*Golden, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create golden file: %w", err)
+	}
+
+	return &Golden{
+		mu:           sync.Mutex{},
+		mode:         ModeRecord,
+		path:         path,
+		file:         file,
+		interactions: nil,
+	}, nil
+}
+
+func loadGolden(path string) (*Golden, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open golden file: %w", err)
+	}
+
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var interactions []*interaction
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, bufio.MaxScanTokenSize<<10)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		recorded := &interaction{} //nolint:exhaustruct // filled by json decoder
+
+		err = json.Unmarshal(line, recorded)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w", ErrInvalidGoldenFile, lineNumber, err)
+		}
+
+		interactions = append(interactions, recorded)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read golden file: %w", err)
+	}
+
+	return &Golden{
+		mu:           sync.Mutex{},
+		mode:         ModeReplay,
+		path:         path,
+		file:         nil,
+		interactions: interactions,
+	}, nil
+}
@@ -0,0 +1,207 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package pgreplay
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	valueTypeNull    = "null"
+	valueTypeInt64   = "int64"
+	valueTypeFloat64 = "float64"
+	valueTypeBool    = "bool"
+	valueTypeBytes   = "bytes"
+	valueTypeString  = "string"
+	valueTypeTime    = "time"
+)
+
+// value is the golden file representation of driver.Value...
+type value struct {
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+}
+
+func encodeValue(driverValue driver.Value) (value, error) {
+	switch typedValue := driverValue.(type) {
+	case nil:
+		return value{Type: valueTypeNull, Value: ""}, nil
+	case int64:
+		return value{Type: valueTypeInt64, Value: strconv.FormatInt(typedValue, 10)}, nil
+	case float64:
+		return value{Type: valueTypeFloat64, Value: strconv.FormatFloat(typedValue, 'g', -1, 64)}, nil
+	case bool:
+		return value{Type: valueTypeBool, Value: strconv.FormatBool(typedValue)}, nil
+	case []byte:
+		return value{Type: valueTypeBytes, Value: base64.StdEncoding.EncodeToString(typedValue)}, nil
+	case string:
+		return value{Type: valueTypeString, Value: typedValue}, nil
+	case time.Time:
+		return value{Type: valueTypeTime, Value: typedValue.Format(time.RFC3339Nano)}, nil
+	default:
+		return value{}, fmt.Errorf("%w: %T", ErrUnsupportedValue, driverValue)
+	}
+}
+
+func encodeValues(driverValues []driver.Value) ([]value, error) {
+	if len(driverValues) == 0 {
+		return nil, nil
+	}
+
+	encoded := make([]value, len(driverValues))
+
+	for i, driverValue := range driverValues {
+		encodedValue, err := encodeValue(driverValue)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded[i] = encodedValue
+	}
+
+	return encoded, nil
+}
+
+func encodeArgs(args []driver.NamedValue) ([]value, error) {
+	driverValues := make([]driver.Value, len(args))
+
+	for i, arg := range args {
+		driverValues[i] = arg.Value
+	}
+
+	return encodeValues(driverValues)
+}
+
+func (v value) decode() (driver.Value, error) {
+	var (
+		decoded driver.Value
+		err     error
+	)
+
+	switch v.Type {
+	case valueTypeNull:
+		return nil, nil
+	case valueTypeInt64:
+		decoded, err = strconv.ParseInt(v.Value, 10, 64)
+	case valueTypeFloat64:
+		decoded, err = strconv.ParseFloat(v.Value, 64)
+	case valueTypeBool:
+		decoded, err = strconv.ParseBool(v.Value)
+	case valueTypeBytes:
+		decoded, err = base64.StdEncoding.DecodeString(v.Value)
+	case valueTypeString:
+		return v.Value, nil
+	case valueTypeTime:
+		decoded, err = time.Parse(time.RFC3339Nano, v.Value)
+	default:
+		return nil, fmt.Errorf("%w: unknown value type %q", ErrInvalidGoldenFile, v.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidGoldenFile, err)
+	}
+
+	return decoded, nil
+}
+
+func (v value) String() string {
+	if v.Type == valueTypeNull {
+		return "NULL"
+	}
+
+	return v.Type + "(" + v.Value + ")"
+}
+
+// recordedError is the golden file representation of driver error.
+// *pq.Error restored with same SQLSTATE, so error classification works same with recorded session...
+type recordedError struct {
+	SQLState   string `json:"sqlstate,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+	Message    string `json:"message"`
+	Detail     string `json:"detail,omitempty"`
+	Constraint string `json:"constraint,omitempty"`
+	Table      string `json:"table,omitempty"`
+	Column     string `json:"column,omitempty"`
+}
+
+func newRecordedError(err error) *recordedError {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return &recordedError{
+			SQLState:   string(pqErr.Code),
+			Severity:   pqErr.Severity,
+			Message:    pqErr.Message,
+			Detail:     pqErr.Detail,
+			Constraint: pqErr.Constraint,
+			Table:      pqErr.Table,
+			Column:     pqErr.Column,
+		}
+	}
+
+	return &recordedError{
+		SQLState:   "",
+		Severity:   "",
+		Message:    err.Error(),
+		Detail:     "",
+		Constraint: "",
+		Table:      "",
+		Column:     "",
+	}
+}
+
+func (e *recordedError) restore() error {
+	if e == nil {
+		return nil
+	}
+
+	if e.SQLState == "" {
+		return errors.New(e.Message) //nolint:err113 // recorded error of non-postgres origin
+	}
+
+	return &pq.Error{ //nolint:exhaustruct // only recorded fields restored
+		Severity:   e.Severity,
+		Code:       pq.ErrorCode(e.SQLState),
+		Message:    e.Message,
+		Detail:     e.Detail,
+		Constraint: e.Constraint,
+		Table:      e.Table,
+		Column:     e.Column,
+	}
+}
@@ -0,0 +1,332 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgrestest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+)
+
+const (
+	// BinDirEnv is the name of environment variable with directory of postgres binaries...
+	BinDirEnv = "POSTGRES_BIN_DIR"
+
+	clusterUser           = "postgres"
+	clusterDatabase       = "postgres"
+	clusterHost           = "127.0.0.1"
+	clusterStartTimeout   = time.Second * 30
+	clusterStopTimeout    = time.Second * 10
+	clusterRetryCount     = 150
+	clusterRetryTimeoutMs = 200
+)
+
+// binDirCandidates is the list of common directories of postgres binaries, used if binaries not found in PATH...
+var binDirCandidates = []string{
+	"/usr/lib/postgresql/*/bin",
+	"/usr/pgsql-*/bin",
+	"/usr/local/pgsql/bin",
+	"/opt/homebrew/opt/postgresql*/bin",
+	"/usr/local/opt/postgresql*/bin",
+}
+
+// Cluster is the ephemeral local postgres cluster, started in temporary data directory...
+type Cluster struct {
+	// DataDir is the temporary data directory of cluster
+	DataDir string
+	// Config is the connection config of cluster maintenance database
+	Config *postgres.PostgresConfig
+
+	cmd     *exec.Cmd
+	output  *lockedWriter
+	stopped chan struct{}
+}
+
+// StartCluster finds locally installed initdb and postgres binaries, initializes cluster in temporary data directory
+// and starts server on free port. Cluster stopped on test cleanup.
+// Test skipped if binaries not found or test run by root user...
+func StartCluster(tb testing.TB) *Cluster {
+	tb.Helper()
+
+	binDir, err := findBinDir()
+	if err != nil {
+		tb.Skipf("postgres binaries not found, set %s environment variable: %v", BinDirEnv, err)
+	}
+
+	if os.Geteuid() == 0 {
+		tb.Skip("postgres server can not be started by root user")
+	}
+
+	dataDir := filepath.Join(tb.TempDir(), "data")
+
+	initOutput, err := exec.Command(filepath.Join(binDir, "initdb"), //nolint:gosec // binary from trusted dir
+		"-D", dataDir, "-U", clusterUser, "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput()
+	if err != nil {
+		tb.Fatalf("unable to init postgres cluster: %v\n%s", err, initOutput)
+	}
+
+	port, err := freePort()
+	if err != nil {
+		tb.Fatalf("unable to find free port for postgres cluster: %v", err)
+	}
+
+	output := &lockedWriter{mu: sync.Mutex{}, w: &bytes.Buffer{}}
+	cmd := exec.Command(filepath.Join(binDir, "postgres"), //nolint:gosec // binary from trusted dir
+		"-D", dataDir,
+		"-h", clusterHost,
+		"-p", strconv.Itoa(int(port)),
+		"-k", "",
+		"-c", "fsync=off",
+		"-c", "synchronous_commit=off",
+		"-c", "full_page_writes=off")
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err = cmd.Start()
+	if err != nil {
+		tb.Fatalf("unable to start postgres cluster: %v", err)
+	}
+
+	cluster := &Cluster{
+		DataDir: dataDir,
+		Config: &postgres.PostgresConfig{
+			DBHost:              clusterHost,
+			DBName:              clusterDatabase,
+			DBUsername:          clusterUser,
+			DBPassword:          "",
+			DBSSLMode:           "disable",
+			DBConnectTimeOut:    clusterRetryTimeoutMs,
+			DBPort:              port,
+			DBMaxOpenConns:      8, //nolint:mnd // same with default config
+			DBMaxIdleConns:      8, //nolint:mnd // same with default config
+			DBConnectRetryCount: clusterRetryCount,
+			DBDriverName:        postgres.DefaultDriverName,
+		},
+		cmd:     cmd,
+		output:  output,
+		stopped: make(chan struct{}),
+	}
+
+	go func() {
+		_ = cmd.Wait()
+		close(cluster.stopped)
+	}()
+
+	tb.Cleanup(cluster.stop)
+
+	err = cluster.waitReady()
+	if err != nil {
+		tb.Fatalf("postgres cluster not ready: %v\n%s", err, cluster.Output())
+	}
+
+	return cluster
+}
+
+// Connect returns connection to cluster database, built by postgres.NewConnection.
+// Connection closed on test cleanup...
+func (c *Cluster) Connect(tb testing.TB, database string) *postgres.Connection {
+	tb.Helper()
+
+	cfg := *c.Config
+	if database != "" {
+		cfg.DBName = database
+	}
+
+	conn, err := postgres.NewConnection(context.Background(), nil, nil, &cfg,
+		postgres.WithLogger(newTestLogger(tb))).Connect()
+	if err != nil {
+		tb.Fatalf("unable to connect to postgres cluster: %v", err)
+	}
+
+	tb.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn
+}
+
+// Output returns log output of postgres server...
+func (c *Cluster) Output() string {
+	c.output.mu.Lock()
+	defer c.output.mu.Unlock()
+
+	return c.output.w.String()
+}
+
+// NewClusterConnection starts ephemeral cluster and returns connection to its maintenance database...
+func NewClusterConnection(tb testing.TB) *postgres.Connection {
+	tb.Helper()
+
+	return StartCluster(tb).Connect(tb, "")
+}
+
+func (c *Cluster) waitReady() error {
+	deadline := time.Now().Add(clusterStartTimeout)
+	address := net.JoinHostPort(clusterHost, strconv.Itoa(int(c.Config.DBPort)))
+
+	for {
+		select {
+		case <-c.stopped:
+			return errors.New("postgres server exited") //nolint:err113 // test helper error
+		default:
+		}
+
+		conn, err := net.DialTimeout("tcp", address, time.Second)
+		if err == nil {
+			_ = conn.Close()
+
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return err //nolint:wrapcheck // test helper error
+		}
+
+		time.Sleep(time.Millisecond * 50) //nolint:mnd // readiness poll interval
+	}
+}
+
+// stop makes fast shutdown of server, killed if it not stopped in time...
+func (c *Cluster) stop() {
+	select {
+	case <-c.stopped:
+		return
+	default:
+	}
+
+	_ = c.cmd.Process.Signal(os.Interrupt)
+
+	select {
+	case <-c.stopped:
+	case <-time.After(clusterStopTimeout):
+		_ = c.cmd.Process.Kill()
+		<-c.stopped
+	}
+}
+
+type lockedWriter struct {
+	mu sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.w.Write(p) //nolint:wrapcheck // bytes.Buffer never returns error
+}
+
+// findBinDir returns directory with initdb and postgres binaries...
+func findBinDir() (string, error) {
+	if binDir := os.Getenv(BinDirEnv); binDir != "" {
+		return binDir, checkBinDir(binDir)
+	}
+
+	initdbPath, err := exec.LookPath("initdb")
+	if err == nil {
+		binDir := filepath.Dir(initdbPath)
+
+		return binDir, checkBinDir(binDir)
+	}
+
+	for _, pattern := range binDirCandidates {
+		matches, _ := filepath.Glob(pattern)
+		// latest installed version first
+		sort.SliceStable(matches, func(i, j int) bool {
+			return binDirVersion(matches[i]) > binDirVersion(matches[j])
+		})
+
+		for _, binDir := range matches {
+			if checkBinDir(binDir) == nil {
+				return binDir, nil
+			}
+		}
+	}
+
+	return "", err //nolint:wrapcheck // exec.LookPath error describes missing binary
+}
+
+// binDirVersion returns major version of postgres from binaries directory path, e.g. 16 for /usr/lib/postgresql/16/bin...
+func binDirVersion(binDir string) int {
+	start := strings.IndexAny(binDir, "0123456789")
+	if start < 0 {
+		return 0
+	}
+
+	end := start
+	for end < len(binDir) && binDir[end] >= '0' && binDir[end] <= '9' {
+		end++
+	}
+
+	version, _ := strconv.Atoi(binDir[start:end])
+
+	return version
+}
+
+func checkBinDir(binDir string) error {
+	for _, name := range []string{"initdb", "postgres"} {
+		_, err := exec.LookPath(filepath.Join(binDir, name))
+		if err != nil {
+			return err //nolint:wrapcheck // exec.LookPath error describes missing binary
+		}
+	}
+
+	return nil
+}
+
+func freePort() (uint16, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(clusterHost, "0"))
+	if err != nil {
+		return 0, err //nolint:wrapcheck // test helper error
+	}
+
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	addr, _ := listener.Addr().(*net.TCPAddr)
+
+	return uint16(addr.Port), nil //nolint:gosec // tcp port always fits uint16
+}
@@ -0,0 +1,112 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgrestest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+
+	"github.com/lib/pq"
+)
+
+// TxContext starts transaction with given context, which always rolled back on test cleanup, and returns context
+// with it. All tx-statement helpers of connection, called with returned context, nested as savepoints in this
+// transaction, so test isolated from other tests and nothing committed. Session variables of context applied
+// to test transaction and to every savepoint...
+func TxContext(ctx context.Context, tb testing.TB, conn *postgres.Connection) context.Context {
+	tb.Helper()
+
+	txCtx, err := conn.BeginContextualTxStatement(ctx)
+	if err != nil {
+		tb.Fatalf("unable to begin test transaction: %v", err)
+	}
+
+	tb.Cleanup(func() {
+		// transaction already rolled back by database/sql, if context cancelled before cleanup
+		rollbackErr := conn.RollbackContextualTxStatement(txCtx)
+		if rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			tb.Errorf("unable to rollback test transaction: %v", rollbackErr)
+		}
+	})
+
+	return conn.ContextWithSavepointNesting(txCtx)
+}
+
+// TemplateDatabase creates database with CREATE DATABASE ... TEMPLATE statement and returns connection to it.
+// Used by tests which need real commits. Created database dropped on test cleanup.
+// Template database must not have active connections, so conn should be connected to maintenance database...
+func TemplateDatabase(tb testing.TB, conn *postgres.Connection, template string) *postgres.Connection {
+	tb.Helper()
+
+	database := strings.ToLower(template + "_test_" + strconv.FormatInt(time.Now().UnixNano(), 36))
+	ctx := context.Background()
+
+	_, err := conn.Dbx.ExecContext(ctx, fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s",
+		pq.QuoteIdentifier(database), pq.QuoteIdentifier(template)))
+	if err != nil {
+		tb.Fatalf("unable to create test database from template %s: %v", template, err)
+	}
+
+	dropDatabase := func() {
+		_, dropErr := conn.Dbx.ExecContext(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)",
+			pq.QuoteIdentifier(database)))
+		if dropErr != nil {
+			tb.Errorf("unable to drop test database %s: %v", database, dropErr)
+		}
+	}
+
+	testConn, err := conn.CloneWithDatabase(database).Connect()
+	if err != nil {
+		dropDatabase()
+		tb.Fatalf("unable to connect to test database %s: %v", database, err)
+	}
+
+	tb.Cleanup(func() {
+		closeErr := testConn.Close()
+		if closeErr != nil {
+			tb.Errorf("unable to close test database connection: %v", closeErr)
+		}
+
+		dropDatabase()
+	})
+
+	return testConn
+}
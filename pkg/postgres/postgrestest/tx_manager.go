@@ -0,0 +1,285 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgrestest
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+)
+
+var _ postgres.TxManager = (*FakeTxManager)(nil)
+
+// CallKind is the kind of recorded transaction call...
+type CallKind string
+
+const (
+	CallBegin    CallKind = "begin"
+	CallCommit   CallKind = "commit"
+	CallRollback CallKind = "rollback"
+)
+
+// Call is the recorded transaction call of FakeTxManager...
+type Call struct {
+	Kind CallKind
+	// TxID is the sequence number of fake transaction, started from 1
+	TxID int
+	// Isolation is the isolation level of transaction, set for begin calls only
+	Isolation sql.IsolationLevel
+}
+
+type fakeTxCtxKey struct{}
+
+type fakeTx struct {
+	id       int
+	isClosed bool
+}
+
+// FakeTxManager is in-memory postgres.TxManager implementation for unit tests. It records begin, commit
+// and rollback calls and runs callbacks with fake transaction context, without database connection...
+type FakeTxManager struct {
+	mu sync.Mutex
+
+	calls  []Call
+	lastID int
+
+	beginErr  error
+	commitErr error
+}
+
+func (m *FakeTxManager) BeginTxWithRollbackOnError(ctx context.Context,
+	callback func(txStmtCtx context.Context) error,
+	_ ...postgres.TxOption,
+) error {
+	return m.runTx(ctx, sql.LevelDefault, callback)
+}
+
+func (m *FakeTxManager) BeginReadCommittedTxRollbackOnError(ctx context.Context,
+	callback func(txStmtCtx context.Context) error,
+	_ ...postgres.TxOption,
+) error {
+	return m.runTx(ctx, sql.LevelDefault, callback)
+}
+
+func (m *FakeTxManager) BeginReadUncommittedTxRollbackOnError(ctx context.Context,
+	callback func(txStmtCtx context.Context) error,
+	_ ...postgres.TxOption,
+) error {
+	return m.runTx(ctx, sql.LevelReadUncommitted, callback)
+}
+
+func (m *FakeTxManager) BeginContextualTxStatement(ctx context.Context,
+	_ ...postgres.TxOption,
+) (context.Context, error) {
+	tx, err := m.begin(sql.LevelDefault)
+	if err != nil {
+		return nil, err
+	}
+
+	return context.WithValue(ctx, fakeTxCtxKey{}, tx), nil
+}
+
+func (m *FakeTxManager) CommitContextualTxStatement(ctx context.Context, _ ...postgres.TxOption) error {
+	tx, inTransaction := ctx.Value(fakeTxCtxKey{}).(*fakeTx)
+	if !inTransaction {
+		return postgres.ErrNotInContextualTxStatement
+	}
+
+	return m.commit(tx)
+}
+
+func (m *FakeTxManager) RollbackContextualTxStatement(ctx context.Context) error {
+	tx, inTransaction := ctx.Value(fakeTxCtxKey{}).(*fakeTx)
+	if !inTransaction {
+		return postgres.ErrNotInContextualTxStatement
+	}
+
+	m.rollback(tx)
+
+	return nil
+}
+
+func (m *FakeTxManager) InTransaction(ctx context.Context) bool {
+	_, inTransaction := TxIDFromContext(ctx)
+
+	return inTransaction
+}
+
+// Calls returns copy of all recorded calls...
+func (m *FakeTxManager) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Call(nil), m.calls...)
+}
+
+// Count returns count of recorded calls of given kind...
+func (m *FakeTxManager) Count(kind CallKind) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+
+	for _, call := range m.calls {
+		if call.Kind == kind {
+			count++
+		}
+	}
+
+	return count
+}
+
+// FailBeginWith sets error returned by all next begin calls, nil resets error...
+func (m *FakeTxManager) FailBeginWith(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.beginErr = err
+}
+
+// FailCommitWith sets error returned by all next commit calls, nil resets error.
+// Transaction rolled back if commit failed...
+func (m *FakeTxManager) FailCommitWith(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.commitErr = err
+}
+
+// Reset removes all recorded calls and configured errors...
+func (m *FakeTxManager) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.calls = nil
+	m.lastID = 0
+	m.beginErr = nil
+	m.commitErr = nil
+}
+
+func (m *FakeTxManager) runTx(ctx context.Context,
+	isolation sql.IsolationLevel,
+	callback func(txStmtCtx context.Context) error,
+) error {
+	tx, err := m.begin(isolation)
+	if err != nil {
+		return err
+	}
+
+	err = callback(context.WithValue(ctx, fakeTxCtxKey{}, tx))
+	if err != nil {
+		m.rollback(tx)
+
+		return err
+	}
+
+	return m.commit(tx)
+}
+
+func (m *FakeTxManager) begin(isolation sql.IsolationLevel) (*fakeTx, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.beginErr != nil {
+		return nil, m.beginErr
+	}
+
+	m.lastID++
+	m.calls = append(m.calls, Call{
+		Kind:      CallBegin,
+		TxID:      m.lastID,
+		Isolation: isolation,
+	})
+
+	return &fakeTx{
+		id:       m.lastID,
+		isClosed: false,
+	}, nil
+}
+
+func (m *FakeTxManager) commit(tx *fakeTx) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if tx.isClosed {
+		return sql.ErrTxDone
+	}
+
+	tx.isClosed = true
+
+	if m.commitErr != nil {
+		m.calls = append(m.calls, Call{Kind: CallRollback, TxID: tx.id, Isolation: sql.LevelDefault})
+
+		return m.commitErr
+	}
+
+	m.calls = append(m.calls, Call{Kind: CallCommit, TxID: tx.id, Isolation: sql.LevelDefault})
+
+	return nil
+}
+
+func (m *FakeTxManager) rollback(tx *fakeTx) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if tx.isClosed {
+		return
+	}
+
+	tx.isClosed = true
+
+	m.calls = append(m.calls, Call{Kind: CallRollback, TxID: tx.id, Isolation: sql.LevelDefault})
+}
+
+// TxIDFromContext returns sequence number of fake transaction from context...
+func TxIDFromContext(ctx context.Context) (int, bool) {
+	tx, inTransaction := ctx.Value(fakeTxCtxKey{}).(*fakeTx)
+	if !inTransaction {
+		return 0, false
+	}
+
+	return tx.id, true
+}
+
+// NewFakeTxManager ....
+func NewFakeTxManager() *FakeTxManager {
+	return &FakeTxManager{
+		mu:        sync.Mutex{},
+		calls:     nil,
+		lastID:    0,
+		beginErr:  nil,
+		commitErr: nil,
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgrestest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+)
+
+func TestFakeTxManagerRunTx(t *testing.T) {
+	errCallback := errors.New("callback failed")
+	errCommit := errors.New("commit failed")
+
+	testCases := []struct {
+		name        string
+		run         func(txManager *FakeTxManager, callback func(txStmtCtx context.Context) error) error
+		callbackErr error
+		commitErr   error
+		err         error
+		calls       []Call
+	}{
+		{
+			name: "committed",
+			run: func(txManager *FakeTxManager, callback func(txStmtCtx context.Context) error) error {
+				return txManager.BeginTxWithRollbackOnError(context.Background(), callback)
+			},
+			callbackErr: nil,
+			commitErr:   nil,
+			err:         nil,
+			calls: []Call{
+				{Kind: CallBegin, TxID: 1, Isolation: sql.LevelDefault},
+				{Kind: CallCommit, TxID: 1, Isolation: sql.LevelDefault},
+			},
+		},
+		{
+			name: "rolled back on callback error",
+			run: func(txManager *FakeTxManager, callback func(txStmtCtx context.Context) error) error {
+				return txManager.BeginReadCommittedTxRollbackOnError(context.Background(), callback)
+			},
+			callbackErr: errCallback,
+			commitErr:   nil,
+			err:         errCallback,
+			calls: []Call{
+				{Kind: CallBegin, TxID: 1, Isolation: sql.LevelDefault},
+				{Kind: CallRollback, TxID: 1, Isolation: sql.LevelDefault},
+			},
+		},
+		{
+			name: "rolled back on commit error",
+			run: func(txManager *FakeTxManager, callback func(txStmtCtx context.Context) error) error {
+				return txManager.BeginReadUncommittedTxRollbackOnError(context.Background(), callback)
+			},
+			callbackErr: nil,
+			commitErr:   errCommit,
+			err:         errCommit,
+			calls: []Call{
+				{Kind: CallBegin, TxID: 1, Isolation: sql.LevelReadUncommitted},
+				{Kind: CallRollback, TxID: 1, Isolation: sql.LevelDefault},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			txManager := NewFakeTxManager()
+			txManager.FailCommitWith(testCase.commitErr)
+
+			err := testCase.run(txManager, func(txStmtCtx context.Context) error {
+				txID, inTransaction := TxIDFromContext(txStmtCtx)
+				if !inTransaction || txID != 1 || !txManager.InTransaction(txStmtCtx) {
+					t.Errorf("callback context without fake transaction, tx id = %d", txID)
+				}
+
+				return testCase.callbackErr
+			})
+			if !errors.Is(err, testCase.err) {
+				t.Errorf("error = %v, want %v", err, testCase.err)
+			}
+
+			if calls := txManager.Calls(); !reflect.DeepEqual(calls, testCase.calls) {
+				t.Errorf("calls = %+v, want %+v", calls, testCase.calls)
+			}
+		})
+	}
+}
+
+func TestFakeTxManagerFailBegin(t *testing.T) {
+	errBegin := errors.New("begin failed")
+
+	txManager := NewFakeTxManager()
+	txManager.FailBeginWith(errBegin)
+
+	isCalled := false
+
+	err := txManager.BeginTxWithRollbackOnError(context.Background(), func(_ context.Context) error {
+		isCalled = true
+
+		return nil
+	})
+	if !errors.Is(err, errBegin) || isCalled {
+		t.Errorf("error = %v, callback called - %t, want %v without callback call", err, isCalled, errBegin)
+	}
+
+	_, err = txManager.BeginContextualTxStatement(context.Background())
+	if !errors.Is(err, errBegin) || len(txManager.Calls()) != 0 {
+		t.Errorf("error = %v, calls = %+v, want %v without calls", err, txManager.Calls(), errBegin)
+	}
+
+	// nil error resets begin failure
+	txManager.FailBeginWith(nil)
+
+	_, err = txManager.BeginContextualTxStatement(context.Background())
+	if err != nil || txManager.Count(CallBegin) != 1 {
+		t.Errorf("error = %v, begin count = %d, want successful begin", err, txManager.Count(CallBegin))
+	}
+}
+
+func TestFakeTxManagerContextualTx(t *testing.T) {
+	txManager := NewFakeTxManager()
+	ctx := context.Background()
+
+	if txManager.InTransaction(ctx) {
+		t.Error("context without transaction reported as transaction")
+	}
+
+	firstCtx, err := txManager.BeginContextualTxStatement(ctx)
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	secondCtx, err := txManager.BeginContextualTxStatement(ctx)
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	err = txManager.CommitContextualTxStatement(firstCtx)
+	if err != nil {
+		t.Fatalf("CommitContextualTxStatement returned error: %v", err)
+	}
+
+	err = txManager.RollbackContextualTxStatement(secondCtx)
+	if err != nil {
+		t.Fatalf("RollbackContextualTxStatement returned error: %v", err)
+	}
+
+	// finished transactions
+	if err = txManager.CommitContextualTxStatement(firstCtx); !errors.Is(err, sql.ErrTxDone) {
+		t.Errorf("second commit error = %v, want %v", err, sql.ErrTxDone)
+	}
+
+	if err = txManager.RollbackContextualTxStatement(firstCtx); err != nil {
+		t.Errorf("rollback of committed transaction returned error: %v", err)
+	}
+
+	// context without transaction
+	if err = txManager.CommitContextualTxStatement(ctx); !errors.Is(err, postgres.ErrNotInContextualTxStatement) {
+		t.Errorf("commit error = %v, want %v", err, postgres.ErrNotInContextualTxStatement)
+	}
+
+	if err = txManager.RollbackContextualTxStatement(ctx); !errors.Is(err, postgres.ErrNotInContextualTxStatement) {
+		t.Errorf("rollback error = %v, want %v", err, postgres.ErrNotInContextualTxStatement)
+	}
+
+	want := []Call{
+		{Kind: CallBegin, TxID: 1, Isolation: sql.LevelDefault},
+		{Kind: CallBegin, TxID: 2, Isolation: sql.LevelDefault},
+		{Kind: CallCommit, TxID: 1, Isolation: sql.LevelDefault},
+		{Kind: CallRollback, TxID: 2, Isolation: sql.LevelDefault},
+	}
+
+	if calls := txManager.Calls(); !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %+v, want %+v", calls, want)
+	}
+
+	txManager.FailCommitWith(errors.New("commit failed"))
+	txManager.Reset()
+
+	err = txManager.BeginTxWithRollbackOnError(ctx, func(_ context.Context) error {
+		return nil
+	})
+	if err != nil || txManager.Count(CallCommit) != 1 || len(txManager.Calls()) != 2 {
+		t.Errorf("Reset kept calls or errors, error = %v, calls = %+v", err, txManager.Calls())
+	}
+
+	if calls := txManager.Calls(); calls[0].TxID != 1 {
+		t.Errorf("tx id after Reset = %d, want 1", calls[0].TxID)
+	}
+}
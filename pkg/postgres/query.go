@@ -0,0 +1,146 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotFound           = errors.New("record not found")
+	ErrUnexpectedRowCount = errors.New("unexpected count of affected rows")
+)
+
+// UnexpectedRowCountError is returned by ExecExpectRows if count of affected rows not equal to expected...
+type UnexpectedRowCountError struct {
+	Expected int64
+	Actual   int64
+}
+
+func (e *UnexpectedRowCountError) Error() string {
+	return fmt.Sprintf("%s: expected %d, actual %d", ErrUnexpectedRowCount.Error(), e.Expected, e.Actual)
+}
+
+func (e *UnexpectedRowCountError) Unwrap() error {
+	return ErrUnexpectedRowCount
+}
+
+// GetOne scans single row into T. Query executed in contextual transaction if present.
+// ErrNotFound returned if query returned no rows...
+func GetOne[T any](ctx context.Context, conn *Connection, query string, args ...interface{}) (T, error) {
+	var dest T
+
+	err := conn.Q(ctx).GetContext(ctx, &dest, query, args...)
+	if err != nil {
+		var zero T
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return zero, conn.e.ErrorOnly(fmt.Errorf("%w: %w", ErrNotFound, err))
+		}
+
+		return zero, conn.ClassifyErrorContext(ctx, err)
+	}
+
+	return dest, nil
+}
+
+// GetOptional same with GetOne, but returns nil without error if query returned no rows...
+func GetOptional[T any](ctx context.Context, conn *Connection, query string, args ...interface{}) (*T, error) {
+	dest := new(T)
+
+	err := conn.Q(ctx).GetContext(ctx, dest, query, args...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil //nolint:nilnil // it's ok, nil result means not found
+		}
+
+		return nil, conn.ClassifyErrorContext(ctx, err)
+	}
+
+	return dest, nil
+}
+
+// SelectAll scans all rows into slice of T. Query executed in contextual transaction if present...
+func SelectAll[T any](ctx context.Context, conn *Connection, query string, args ...interface{}) ([]T, error) {
+	var dest []T
+
+	err := conn.Q(ctx).SelectContext(ctx, &dest, query, args...)
+	if err != nil {
+		return nil, conn.ClassifyErrorContext(ctx, err)
+	}
+
+	return dest, nil
+}
+
+// Exists reports whether query returns at least one row...
+func Exists(ctx context.Context, conn *Connection, query string, args ...interface{}) (bool, error) {
+	var exists bool
+
+	err := conn.Q(ctx).GetContext(ctx, &exists, "SELECT EXISTS ("+query+")", args...)
+	if err != nil {
+		return false, conn.ClassifyErrorContext(ctx, err)
+	}
+
+	return exists, nil
+}
+
+// ExecExpectRows executes statement and checks count of affected rows.
+// UnexpectedRowCountError returned if count of affected rows not equal to expected...
+func ExecExpectRows(ctx context.Context,
+	conn *Connection,
+	expectedRows int64,
+	query string,
+	args ...interface{},
+) error {
+	result, err := conn.Q(ctx).ExecContext(ctx, query, args...)
+	if err != nil {
+		return conn.ClassifyErrorContext(ctx, err)
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return conn.e.ErrorOnly(err)
+	}
+
+	if affectedRows != expectedRows {
+		return conn.e.ErrorOnly(&UnexpectedRowCountError{
+			Expected: expectedRows,
+			Actual:   affectedRows,
+		})
+	}
+
+	return nil
+}
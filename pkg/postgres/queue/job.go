@@ -0,0 +1,120 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package queue
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts is the count of attempts after which job moved to dead-letter state...
+	DefaultMaxAttempts = 25
+
+	StatePending = "pending"
+	StateRunning = "running"
+	StateDone    = "done"
+	StateDead    = "dead"
+)
+
+// Job is a single queue record...
+type Job struct {
+	ID          int64          `db:"id"`
+	Queue       string         `db:"queue"`
+	Payload     []byte         `db:"payload"`
+	Priority    int16          `db:"priority"`
+	UniqueKey   sql.NullString `db:"unique_key"`
+	Attempts    uint32         `db:"attempts"`
+	MaxAttempts uint32         `db:"max_attempts"`
+	LastError   sql.NullString `db:"last_error"`
+	RunAt       time.Time      `db:"run_at"`
+	CreatedAt   time.Time      `db:"created_at"`
+}
+
+// JobOption ....
+type JobOption func(*jobOptions)
+
+type jobOptions struct {
+	runAt       sql.NullTime
+	priority    int16
+	uniqueKey   sql.NullString
+	maxAttempts uint32
+}
+
+// WithRunAt schedules job run time...
+func WithRunAt(runAt time.Time) JobOption {
+	return func(o *jobOptions) {
+		o.runAt = sql.NullTime{Time: runAt, Valid: true}
+	}
+}
+
+// WithDelay schedules job run after delay...
+func WithDelay(delay time.Duration) JobOption {
+	return WithRunAt(time.Now().Add(delay))
+}
+
+// WithPriority sets job priority, jobs with higher priority claimed first...
+func WithPriority(priority int16) JobOption {
+	return func(o *jobOptions) {
+		o.priority = priority
+	}
+}
+
+// WithUniqueKey sets unique key of job. Job not enqueued if pending or running job with same key exists in queue...
+func WithUniqueKey(key string) JobOption {
+	return func(o *jobOptions) {
+		o.uniqueKey = sql.NullString{String: key, Valid: true}
+	}
+}
+
+// WithMaxAttempts sets count of attempts after which job moved to dead-letter state...
+func WithMaxAttempts(maxAttempts uint32) JobOption {
+	return func(o *jobOptions) {
+		o.maxAttempts = maxAttempts
+	}
+}
+
+func newJobOptions(opts ...JobOption) *jobOptions {
+	options := &jobOptions{
+		runAt:       sql.NullTime{}, //nolint:exhaustruct // NULL value
+		priority:    0,
+		uniqueKey:   sql.NullString{}, //nolint:exhaustruct // NULL value
+		maxAttempts: DefaultMaxAttempts,
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options
+}
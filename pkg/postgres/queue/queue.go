@@ -0,0 +1,115 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+)
+
+var (
+	ErrEmptyQueueName = errors.New("queue name is empty")
+	ErrDuplicateJob   = errors.New("job with same unique key already enqueued")
+)
+
+// Queue writes jobs to queue table. If context contains contextual transaction job enqueued in it,
+// so job will be visible to workers only if caller's transaction committed...
+type Queue struct {
+	e errorFormatterService
+
+	conn dbConnection
+
+	notifyChannel string
+	insertQuery   string
+}
+
+// Enqueue stores job in queue table and notifies listening workers. Returns identifier of job.
+// If job with same unique key is pending or running ErrDuplicateJob returned...
+func (q *Queue) Enqueue(ctx context.Context, queueName string, payload []byte, opts ...JobOption) (int64, error) {
+	if queueName == "" {
+		return 0, q.e.ErrorOnly(ErrEmptyQueueName)
+	}
+
+	jobOpts := newJobOptions(opts...)
+
+	var jobID int64
+
+	err := q.conn.TryWithTransactionQuerier(ctx, func(stmt postgres.Querier) error {
+		insertErr := stmt.GetContext(ctx, &jobID, q.insertQuery, queueName, payload, jobOpts.priority,
+			jobOpts.uniqueKey, jobOpts.maxAttempts, jobOpts.runAt)
+		if errors.Is(insertErr, sql.ErrNoRows) {
+			return q.e.ErrorOnly(ErrDuplicateJob, jobOpts.uniqueKey.String)
+		}
+
+		if insertErr != nil {
+			return q.e.ErrorOnly(insertErr)
+		}
+
+		_, notifyErr := stmt.ExecContext(ctx, "SELECT pg_notify($1, $2)", q.notifyChannel, queueName)
+		if notifyErr != nil {
+			return q.e.ErrorOnly(notifyErr)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, q.e.ErrorNoWrap(err)
+	}
+
+	return jobID, nil
+}
+
+// NewQueue ....
+func NewQueue(errFormatterSvc errorFormatterService,
+	conn dbConnection,
+	tableName string,
+) *Queue {
+	if tableName == "" {
+		tableName = DefaultTableName
+	}
+
+	return &Queue{
+		e:             errFormatterSvc,
+		conn:          conn,
+		notifyChannel: tableName,
+		insertQuery: fmt.Sprintf(`INSERT INTO %s (queue, payload, priority, unique_key, max_attempts, run_at)
+			VALUES ($1, $2, $3, $4, $5, COALESCE($6, now()))
+			ON CONFLICT (queue, unique_key) WHERE unique_key IS NOT NULL AND state IN ('pending', 'running')
+			DO NOTHING
+			RETURNING id`, tableName),
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package queue
+
+import (
+	"fmt"
+)
+
+// DefaultTableName is the name of queue table, used if table name not set in config...
+const DefaultTableName = "queue_jobs"
+
+// SchemaSQL returns DDL statements for queue table with given name...
+func SchemaSQL(tableName string) string {
+	return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id           BIGSERIAL PRIMARY KEY,
+	queue        TEXT        NOT NULL,
+	payload      BYTEA       NOT NULL,
+	priority     SMALLINT    NOT NULL DEFAULT 0,
+	unique_key   TEXT        NULL,
+	state        TEXT        NOT NULL DEFAULT 'pending',
+	attempts     INTEGER     NOT NULL DEFAULT 0,
+	max_attempts INTEGER     NOT NULL DEFAULT %[2]d,
+	last_error   TEXT        NULL,
+	run_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+	locked_until TIMESTAMPTZ NULL,
+	created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+	finished_at  TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS %[1]s_claim_idx ON %[1]s (queue, priority DESC, run_at, id)
+	WHERE state IN ('pending', 'running');
+
+CREATE UNIQUE INDEX IF NOT EXISTS %[1]s_unique_key_idx ON %[1]s (queue, unique_key)
+	WHERE unique_key IS NOT NULL AND state IN ('pending', 'running');
+
+CREATE INDEX IF NOT EXISTS %[1]s_dead_idx ON %[1]s (finished_at)
+	WHERE state = 'dead';`, tableName, DefaultMaxAttempts)
+}
@@ -0,0 +1,481 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultConcurrency       = 10
+	defaultPollInterval      = time.Second
+	defaultVisibilityTimeout = time.Minute * 5
+	defaultBaseRetryDelay    = time.Second
+	defaultMaxRetryDelay     = time.Hour
+	defaultShutdownTimeout   = time.Second * 30
+
+	listenerMinReconnectInterval = time.Second
+	listenerMaxReconnectInterval = time.Minute
+
+	JobIDTag      = "queue_job_id"
+	JobQueueTag   = "queue_name"
+	JobAttemptTag = "queue_job_attempt"
+	ErrorTag      = "error"
+)
+
+var ErrJobPanic = errors.New("queue job handler panic")
+
+// WorkerPoolConfig ....
+type WorkerPoolConfig struct {
+	// TableName is the name of queue table. DefaultTableName used if empty
+	TableName string
+	// Queue is the name of queue processed by worker pool
+	Queue string
+	// Concurrency is the maximum count of jobs processed in parallel
+	Concurrency uint16
+	// PollInterval is the delay between claim attempts when queue is drained
+	PollInterval time.Duration
+	// VisibilityTimeout is the time of job lock. Job claimed again by any worker if lock expired,
+	// also handler context cancelled after this timeout
+	VisibilityTimeout time.Duration
+	// BaseRetryDelay is the delay before first retry, each next retry delay doubled
+	BaseRetryDelay time.Duration
+	// MaxRetryDelay is the upper bound of retry delay
+	MaxRetryDelay time.Duration
+	// ShutdownTimeout is the time of waiting in-flight jobs on stop, after it remaining jobs released
+	ShutdownTimeout time.Duration
+	// DeleteOnSuccess - delete finished jobs instead of marking them as done
+	DeleteOnSuccess bool
+	// ListenNotify - wake up workers by LISTEN/NOTIFY right after job enqueued, instead of waiting poll interval.
+	// Listener connects with lib/pq directly, see postgres.Connection NewListener function
+	ListenNotify bool
+}
+
+// WorkerPool claims jobs with FOR UPDATE SKIP LOCKED and processes them by handler...
+type WorkerPool struct {
+	l *slog.Logger
+	e errorFormatterService
+
+	conn    dbConnection
+	handler Handler
+
+	queueName         string
+	notifyChannel     string
+	pollInterval      time.Duration
+	visibilityTimeout time.Duration
+	baseRetryDelay    time.Duration
+	maxRetryDelay     time.Duration
+	shutdownTimeout   time.Duration
+	listenNotify      bool
+
+	claimQuery   string
+	expireQuery  string
+	doneQuery    string
+	failedQuery  string
+	releaseQuery string
+
+	inFlight     sync.WaitGroup
+	slots        chan struct{}
+	slotReleased chan struct{}
+	jobsCtx      context.Context //nolint:containedctx // parent context of in-flight jobs
+	cancelJobs   context.CancelFunc
+
+	stopOnce     sync.Once
+	stopCh       chan struct{}
+	forceOnce    sync.Once
+	forceCh      chan struct{}
+	doneCh       chan struct{}
+	runOnce      sync.Once
+	isRunning    bool
+	isRunningMu  sync.Mutex
+	isForcedStop bool
+	forcedStopMu sync.Mutex
+}
+
+// Run claims and processes jobs until context cancelled or Stop called. On stop in-flight jobs finished
+// or released after shutdown timeout...
+func (p *WorkerPool) Run(ctx context.Context) error {
+	started := false
+
+	p.runOnce.Do(func() {
+		p.isRunningMu.Lock()
+		p.isRunning, started = true, true
+		p.isRunningMu.Unlock()
+	})
+
+	if !started {
+		return nil
+	}
+
+	defer close(p.doneCh)
+
+	var wakeCh <-chan *pq.Notification
+
+	if p.listenNotify {
+		listener := p.conn.NewListener(listenerMinReconnectInterval, listenerMaxReconnectInterval,
+			func(_ pq.ListenerEventType, err error) {
+				if err != nil {
+					p.l.Warn("queue listener event", slog.Any(ErrorTag, err))
+				}
+			})
+
+		defer func() {
+			_ = listener.Close()
+		}()
+
+		err := listener.Listen(p.notifyChannel)
+		if err != nil {
+			p.l.Error("unable to listen queue notify channel, polling used", slog.Any(ErrorTag, err))
+		} else {
+			wakeCh = listener.NotificationChannel()
+		}
+	}
+
+	for {
+		free := cap(p.slots) - len(p.slots)
+		if free > 0 && ctx.Err() == nil && !p.isStopped() {
+			claimed, err := p.claimAndDispatch(ctx, free)
+			if err != nil {
+				p.l.Error("unable to claim queue jobs", slog.Any(ErrorTag, err))
+			}
+
+			if err == nil && claimed == free {
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return p.shutdown()
+		case <-p.stopCh:
+			return p.shutdown()
+		case <-wakeCh:
+		case <-p.slotReleased:
+		case <-time.After(p.pollInterval):
+		}
+	}
+}
+
+// Stop stops claiming of new jobs and waits in-flight jobs. If context done before all jobs finished,
+// handlers contexts cancelled and remaining jobs released...
+func (p *WorkerPool) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+
+	p.isRunningMu.Lock()
+	isRunning := p.isRunning
+	p.isRunningMu.Unlock()
+
+	if !isRunning {
+		return nil
+	}
+
+	select {
+	case <-p.doneCh:
+		return nil
+	case <-ctx.Done():
+		p.forceOnce.Do(func() {
+			close(p.forceCh)
+		})
+
+		<-p.doneCh
+
+		return nil
+	}
+}
+
+func (p *WorkerPool) isStopped() bool {
+	select {
+	case <-p.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *WorkerPool) shutdown() error {
+	waitCh := make(chan struct{})
+
+	go func() {
+		p.inFlight.Wait()
+		close(waitCh)
+	}()
+
+	select {
+	case <-waitCh:
+		return nil
+	case <-p.forceCh:
+	case <-time.After(p.shutdownTimeout):
+	}
+
+	p.forcedStopMu.Lock()
+	p.isForcedStop = true
+	p.forcedStopMu.Unlock()
+
+	p.cancelJobs()
+	<-waitCh
+
+	return nil
+}
+
+func (p *WorkerPool) claimAndDispatch(ctx context.Context, limit int) (int, error) {
+	var jobs []*Job
+
+	err := p.conn.BeginReadCommittedTxRollbackOnError(ctx, func(txStmtCtx context.Context) error {
+		return p.conn.MustWithTransactionQuerier(txStmtCtx, func(stmt postgres.Querier) error {
+			_, expireErr := stmt.ExecContext(txStmtCtx, p.expireQuery, p.queueName)
+			if expireErr != nil {
+				return p.e.ErrorOnly(expireErr)
+			}
+
+			selectErr := stmt.SelectContext(txStmtCtx, &jobs, p.claimQuery, p.queueName, limit,
+				p.visibilityTimeout.Seconds())
+			if selectErr != nil {
+				return p.e.ErrorOnly(selectErr)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, p.e.ErrorNoWrap(err)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Priority != jobs[j].Priority {
+			return jobs[i].Priority > jobs[j].Priority
+		}
+
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	for _, job := range jobs {
+		p.slots <- struct{}{}
+		p.inFlight.Add(1)
+
+		go p.process(job)
+	}
+
+	return len(jobs), nil
+}
+
+func (p *WorkerPool) process(job *Job) {
+	defer func() {
+		<-p.slots
+		p.inFlight.Done()
+
+		select {
+		case p.slotReleased <- struct{}{}:
+		default:
+		}
+	}()
+
+	handlerCtx, cancel := context.WithTimeout(p.jobsCtx, p.visibilityTimeout)
+	defer cancel()
+
+	handlerErr := p.runHandler(handlerCtx, job)
+
+	// job state updated even if jobs context cancelled on shutdown
+	ctx := context.WithoutCancel(handlerCtx)
+
+	p.forcedStopMu.Lock()
+	isForcedStop := p.isForcedStop
+	p.forcedStopMu.Unlock()
+
+	var err error
+
+	switch {
+	case handlerErr == nil:
+		err = p.exec(ctx, p.doneQuery, job.ID, job.Attempts)
+	case isForcedStop:
+		err = p.exec(ctx, p.releaseQuery, job.ID, job.Attempts)
+	default:
+		err = p.fail(ctx, job, handlerErr)
+	}
+
+	if err != nil {
+		p.l.Error("unable to update queue job state", slog.Int64(JobIDTag, job.ID),
+			slog.String(JobQueueTag, job.Queue), slog.Any(ErrorTag, err))
+	}
+}
+
+func (p *WorkerPool) runHandler(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			err = fmt.Errorf("%w: %v", ErrJobPanic, recovered)
+		}
+	}()
+
+	return p.handler(ctx, job)
+}
+
+func (p *WorkerPool) fail(ctx context.Context, job *Job, handlerErr error) error {
+	deadLetter := job.Attempts >= job.MaxAttempts
+	if deadLetter {
+		p.l.Warn("queue job moved to dead-letter state",
+			slog.Int64(JobIDTag, job.ID),
+			slog.String(JobQueueTag, job.Queue),
+			slog.Any(JobAttemptTag, job.Attempts),
+			slog.Any(ErrorTag, handlerErr))
+	}
+
+	return p.exec(ctx, p.failedQuery, job.ID, job.Attempts, handlerErr.Error(), deadLetter,
+		p.retryDelay(job.Attempts).Seconds())
+}
+
+func (p *WorkerPool) exec(ctx context.Context, query string, args ...interface{}) error {
+	return p.conn.TryWithTransactionQuerier(ctx, func(stmt postgres.Querier) error {
+		_, err := stmt.ExecContext(ctx, query, args...)
+		if err != nil {
+			return p.e.ErrorOnly(err)
+		}
+
+		return nil
+	})
+}
+
+func (p *WorkerPool) retryDelay(attempt uint32) time.Duration {
+	delay := p.baseRetryDelay
+
+	for i := uint32(1); i < attempt; i++ {
+		delay *= 2
+
+		if delay >= p.maxRetryDelay {
+			return p.maxRetryDelay
+		}
+	}
+
+	return delay
+}
+
+// NewWorkerPool ....
+func NewWorkerPool(logFactorySvc loggerService,
+	errFormatterSvc errorFormatterService,
+	conn dbConnection,
+	handler Handler,
+	cfg WorkerPoolConfig,
+) *WorkerPool {
+	tableName := cfg.TableName
+	if tableName == "" {
+		tableName = DefaultTableName
+	}
+
+	jobsCtx, cancelJobs := context.WithCancel(context.Background())
+	concurrency := valueOrDefault(cfg.Concurrency, defaultConcurrency)
+
+	pool := &WorkerPool{
+		l:                 logFactorySvc.NewSlogNamedLoggerEntry("lib-postgres-queue-worker"),
+		e:                 errFormatterSvc,
+		conn:              conn,
+		handler:           handler,
+		queueName:         cfg.Queue,
+		notifyChannel:     tableName,
+		pollInterval:      valueOrDefault(cfg.PollInterval, defaultPollInterval),
+		visibilityTimeout: valueOrDefault(cfg.VisibilityTimeout, defaultVisibilityTimeout),
+		baseRetryDelay:    valueOrDefault(cfg.BaseRetryDelay, defaultBaseRetryDelay),
+		maxRetryDelay:     valueOrDefault(cfg.MaxRetryDelay, defaultMaxRetryDelay),
+		shutdownTimeout:   valueOrDefault(cfg.ShutdownTimeout, defaultShutdownTimeout),
+		listenNotify:      cfg.ListenNotify,
+		claimQuery: fmt.Sprintf(`UPDATE %[1]s SET state = 'running',
+				attempts = attempts + 1,
+				locked_until = now() + make_interval(secs => $3)
+			WHERE id IN (
+				SELECT id FROM %[1]s
+				WHERE queue = $1 AND attempts < max_attempts
+					AND ((state = 'pending' AND run_at <= now()) OR (state = 'running' AND locked_until < now()))
+				ORDER BY priority DESC, run_at, id
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED)
+			RETURNING id, queue, payload, priority, unique_key, attempts, max_attempts, last_error,
+				run_at, created_at`, tableName),
+		expireQuery: fmt.Sprintf(`UPDATE %s SET state = 'dead',
+				locked_until = NULL,
+				finished_at = now(),
+				last_error = COALESCE(last_error, 'visibility timeout expired')
+			WHERE queue = $1 AND state = 'running' AND locked_until < now() AND attempts >= max_attempts`,
+			tableName),
+		doneQuery: fmt.Sprintf(`UPDATE %s SET state = 'done', locked_until = NULL, finished_at = now()
+			WHERE id = $1 AND attempts = $2 AND state = 'running'`, tableName),
+		failedQuery: fmt.Sprintf(`UPDATE %s SET state = CASE WHEN $4::BOOLEAN THEN 'dead' ELSE 'pending' END,
+				last_error = $3,
+				locked_until = NULL,
+				run_at = now() + make_interval(secs => $5),
+				finished_at = CASE WHEN $4::BOOLEAN THEN now() ELSE NULL END
+			WHERE id = $1 AND attempts = $2 AND state = 'running'`, tableName),
+		releaseQuery: fmt.Sprintf(`UPDATE %s SET state = 'pending', attempts = attempts - 1, locked_until = NULL
+			WHERE id = $1 AND attempts = $2 AND state = 'running'`, tableName),
+		inFlight:     sync.WaitGroup{},
+		slots:        make(chan struct{}, concurrency),
+		slotReleased: make(chan struct{}, 1),
+		jobsCtx:      jobsCtx,
+		cancelJobs:   cancelJobs,
+		stopOnce:     sync.Once{},
+		stopCh:       make(chan struct{}),
+		forceOnce:    sync.Once{},
+		forceCh:      make(chan struct{}),
+		doneCh:       make(chan struct{}),
+		runOnce:      sync.Once{},
+		isRunning:    false,
+		isRunningMu:  sync.Mutex{},
+		isForcedStop: false,
+		forcedStopMu: sync.Mutex{},
+	}
+
+	if cfg.DeleteOnSuccess {
+		pool.doneQuery = fmt.Sprintf(`DELETE FROM %s WHERE id = $1 AND attempts = $2 AND state = 'running'`,
+			tableName)
+	}
+
+	return pool
+}
+
+func valueOrDefault[T comparable](value, defaultValue T) T {
+	var zero T
+	if value == zero {
+		return defaultValue
+	}
+
+	return value
+}
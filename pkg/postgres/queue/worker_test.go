@@ -0,0 +1,580 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/crypto-bundle/bc-wallet-common-lib-postgres/pkg/postgres"
+)
+
+const testQueue = "withdrawals"
+
+var errHandlerFailed = errors.New("node unavailable")
+
+// driverSequence is used for generation of unique names of registered stub drivers...
+var driverSequence atomic.Uint64
+
+type testLoggerService struct{}
+
+func (testLoggerService) NewSlogNamedLoggerEntry(_ string, _ ...any) *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+type testErrorFormatter struct{}
+
+func (testErrorFormatter) ErrorOnly(err error, _ ...string) error {
+	return err
+}
+
+func (testErrorFormatter) ErrorNoWrap(err error) error {
+	return err
+}
+
+func (testErrorFormatter) Errorf(err error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", err, fmt.Sprintf(format, args...))
+}
+
+// stubStatement is the statement executed by worker pool through stub driver...
+type stubStatement struct {
+	query string
+	args  []driver.Value
+}
+
+// stubDatabase is the database/sql driver, which serves claimed job batches and records executed statements...
+type stubDatabase struct {
+	mu sync.Mutex
+
+	claimBatches [][][]driver.Value
+	statements   []stubStatement
+}
+
+func (d *stubDatabase) Open(_ string) (driver.Conn, error) {
+	return &stubConn{db: d}, nil
+}
+
+// find returns executed statements, which contain given query fragment...
+func (d *stubDatabase) find(fragment string) []stubStatement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var found []stubStatement
+
+	for _, statement := range d.statements {
+		if strings.Contains(statement.query, fragment) {
+			found = append(found, statement)
+		}
+	}
+
+	return found
+}
+
+// claimLimits returns limits of executed claim queries...
+func (d *stubDatabase) claimLimits() []driver.Value {
+	var limits []driver.Value
+
+	for _, claim := range d.find("FOR UPDATE SKIP LOCKED") {
+		limits = append(limits, claim.args[1])
+	}
+
+	return limits
+}
+
+type stubConn struct {
+	db *stubDatabase
+}
+
+func (c *stubConn) Prepare(_ string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements not supported by stub driver")
+}
+
+func (c *stubConn) Close() error {
+	return nil
+}
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	return stubTx{}, nil
+}
+
+func (c *stubConn) BeginTx(_ context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	return stubTx{}, nil
+}
+
+func (c *stubConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.statements = append(c.db.statements, stubStatement{query: query, args: plainValues(args)})
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *stubConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	c.db.statements = append(c.db.statements, stubStatement{query: query, args: plainValues(args)})
+
+	switch {
+	case query == "SELECT 1":
+		return &stubRows{columns: []string{"?column?"}, rows: [][]driver.Value{{int64(1)}}}, nil
+	case strings.Contains(query, "FOR UPDATE SKIP LOCKED"):
+		var rows [][]driver.Value
+
+		if len(c.db.claimBatches) > 0 {
+			rows = c.db.claimBatches[0]
+			c.db.claimBatches = c.db.claimBatches[1:]
+		}
+
+		return &stubRows{
+			columns: []string{"id", "queue", "payload", "priority", "unique_key", "attempts", "max_attempts",
+				"last_error", "run_at", "created_at"},
+			rows: rows,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unexpected query: %s", query)
+	}
+}
+
+type stubTx struct{}
+
+func (stubTx) Commit() error {
+	return nil
+}
+
+func (stubTx) Rollback() error {
+	return nil
+}
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string {
+	return r.columns
+}
+
+func (r *stubRows) Close() error {
+	return nil
+}
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func plainValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	return values
+}
+
+// jobRow returns claimed job row. Attempts already incremented by claim query...
+func jobRow(id int64, priority int64, attempts int64, maxAttempts int64) []driver.Value {
+	return []driver.Value{id, testQueue, []byte(`{}`), priority, nil, attempts, maxAttempts, nil,
+		time.Now(), time.Now()}
+}
+
+func newTestWorkerPool(t *testing.T, db *stubDatabase, handler Handler, cfg WorkerPoolConfig) *WorkerPool {
+	t.Helper()
+
+	driverName := fmt.Sprintf("queue-worker-%d", driverSequence.Add(1))
+	sql.Register(driverName, db)
+
+	conn, err := postgres.NewConnectionFromDSN("host=localhost dbname=queue", postgres.WithDriver(driverName),
+		postgres.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("unable to create connection: %v", err)
+	}
+
+	_, err = conn.Connect()
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	cfg.Queue = testQueue
+
+	return NewWorkerPool(testLoggerService{}, testErrorFormatter{}, conn, handler, cfg)
+}
+
+// waitFor polls condition until it satisfied or second passed...
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if condition() {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("condition not satisfied in time")
+}
+
+func TestWorkerPoolClaimQueries(t *testing.T) {
+	db := &stubDatabase{}
+	pool := newTestWorkerPool(t, db, func(context.Context, *Job) error {
+		return nil
+	}, WorkerPoolConfig{TableName: "wallet_jobs", VisibilityTimeout: time.Minute})
+
+	claimed, err := pool.claimAndDispatch(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("claimAndDispatch returned error: %v", err)
+	}
+
+	if claimed != 0 {
+		t.Fatalf("claimed = %d, want 0", claimed)
+	}
+
+	expires := db.find("visibility timeout expired")
+	if len(expires) != 1 {
+		t.Fatalf("expire query executed %d times, want 1", len(expires))
+	}
+
+	for _, fragment := range []string{"UPDATE wallet_jobs SET state = 'dead'", "state = 'running'",
+		"locked_until < now()", "attempts >= max_attempts"} {
+		if !strings.Contains(expires[0].query, fragment) {
+			t.Errorf("expire query does not contain %q: %s", fragment, expires[0].query)
+		}
+	}
+
+	if !reflect.DeepEqual(expires[0].args, []driver.Value{testQueue}) {
+		t.Errorf("expire query args = %v, want [%s]", expires[0].args, testQueue)
+	}
+
+	claims := db.find("FOR UPDATE SKIP LOCKED")
+	if len(claims) != 1 {
+		t.Fatalf("claim query executed %d times, want 1", len(claims))
+	}
+
+	for _, fragment := range []string{"SELECT id FROM wallet_jobs", "attempts = attempts + 1",
+		"attempts < max_attempts", "(state = 'running' AND locked_until < now())",
+		"ORDER BY priority DESC, run_at, id"} {
+		if !strings.Contains(claims[0].query, fragment) {
+			t.Errorf("claim query does not contain %q: %s", fragment, claims[0].query)
+		}
+	}
+
+	if !reflect.DeepEqual(claims[0].args, []driver.Value{testQueue, int64(7), float64(60)}) {
+		t.Errorf("claim query args = %v, want [%s 7 60]", claims[0].args, testQueue)
+	}
+}
+
+func TestWorkerPoolDispatchesByPriority(t *testing.T) {
+	db := &stubDatabase{claimBatches: [][][]driver.Value{{
+		jobRow(9, 0, 1, 5), jobRow(4, 5, 1, 5), jobRow(2, 5, 1, 5), jobRow(1, -1, 1, 5),
+	}}}
+
+	var (
+		mu        sync.Mutex
+		processed []int64
+	)
+
+	// single slot, so jobs processed one by one in dispatch order
+	pool := newTestWorkerPool(t, db, func(_ context.Context, job *Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		processed = append(processed, job.ID)
+
+		return nil
+	}, WorkerPoolConfig{Concurrency: 1})
+
+	_, err := pool.claimAndDispatch(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("claimAndDispatch returned error: %v", err)
+	}
+
+	pool.inFlight.Wait()
+
+	if !reflect.DeepEqual(processed, []int64{2, 4, 9, 1}) {
+		t.Errorf("processed jobs = %v, want [2 4 9 1]", processed)
+	}
+}
+
+func TestWorkerPoolJobStateUpdates(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         WorkerPoolConfig
+		row         []driver.Value
+		handlerErr  error
+		isPanic     bool
+		fragment    string
+		wantArgs    []driver.Value
+		wantLastErr string
+	}{
+		{
+			name:     "done",
+			cfg:      WorkerPoolConfig{},
+			row:      jobRow(1, 0, 1, 5),
+			fragment: "SET state = 'done'",
+			wantArgs: []driver.Value{int64(1), int64(1)},
+		},
+		{
+			name:     "deleted on success",
+			cfg:      WorkerPoolConfig{DeleteOnSuccess: true},
+			row:      jobRow(1, 0, 2, 5),
+			fragment: "DELETE FROM queue_jobs",
+			wantArgs: []driver.Value{int64(1), int64(2)},
+		},
+		{
+			name:       "retried with backoff",
+			cfg:        WorkerPoolConfig{BaseRetryDelay: time.Second, MaxRetryDelay: time.Minute},
+			row:        jobRow(1, 0, 3, 5),
+			handlerErr: errHandlerFailed,
+			fragment:   "CASE WHEN $4::BOOLEAN THEN 'dead'",
+			wantArgs:   []driver.Value{int64(1), int64(3), errHandlerFailed.Error(), false, float64(4)},
+		},
+		{
+			name:       "moved to dead-letter state",
+			cfg:        WorkerPoolConfig{BaseRetryDelay: time.Second, MaxRetryDelay: time.Minute},
+			row:        jobRow(1, 0, 5, 5),
+			handlerErr: errHandlerFailed,
+			fragment:   "CASE WHEN $4::BOOLEAN THEN 'dead'",
+			wantArgs:   []driver.Value{int64(1), int64(5), errHandlerFailed.Error(), true, float64(16)},
+		},
+		{
+			name:        "handler panic",
+			cfg:         WorkerPoolConfig{BaseRetryDelay: time.Second, MaxRetryDelay: time.Minute},
+			row:         jobRow(1, 0, 1, 5),
+			isPanic:     true,
+			fragment:    "CASE WHEN $4::BOOLEAN THEN 'dead'",
+			wantLastErr: ErrJobPanic.Error() + ": insufficient funds",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := &stubDatabase{claimBatches: [][][]driver.Value{{testCase.row}}}
+			pool := newTestWorkerPool(t, db, func(context.Context, *Job) error {
+				if testCase.isPanic {
+					panic("insufficient funds")
+				}
+
+				return testCase.handlerErr
+			}, testCase.cfg)
+
+			_, err := pool.claimAndDispatch(context.Background(), 1)
+			if err != nil {
+				t.Fatalf("claimAndDispatch returned error: %v", err)
+			}
+
+			pool.inFlight.Wait()
+
+			updates := db.find(testCase.fragment)
+			if len(updates) != 1 {
+				t.Fatalf("state update %q executed %d times, want 1", testCase.fragment, len(updates))
+			}
+
+			if !strings.Contains(updates[0].query, "AND attempts = $2 AND state = 'running'") {
+				t.Errorf("state update is not guarded by attempt: %s", updates[0].query)
+			}
+
+			if testCase.isPanic {
+				if updates[0].args[2] != testCase.wantLastErr {
+					t.Errorf("last error = %v, want %q", updates[0].args[2], testCase.wantLastErr)
+				}
+
+				return
+			}
+
+			if !reflect.DeepEqual(updates[0].args, testCase.wantArgs) {
+				t.Errorf("state update args = %v, want %v", updates[0].args, testCase.wantArgs)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolClaimsOnlyFreeSlots(t *testing.T) {
+	db := &stubDatabase{claimBatches: [][][]driver.Value{{jobRow(1, 0, 1, 5), jobRow(2, 0, 1, 5)}}}
+
+	started := make(chan int64, 2)
+	release := map[int64]chan struct{}{1: make(chan struct{}), 2: make(chan struct{})}
+
+	pool := newTestWorkerPool(t, db, func(_ context.Context, job *Job) error {
+		started <- job.ID
+		<-release[job.ID]
+
+		return nil
+	}, WorkerPoolConfig{Concurrency: 2, PollInterval: time.Hour})
+
+	runErr := make(chan error, 1)
+
+	go func() {
+		runErr <- pool.Run(context.Background())
+	}()
+
+	<-started
+	<-started
+
+	// all slots busy - no claims until job finished
+	time.Sleep(time.Millisecond * 20)
+
+	if got := db.claimLimits(); !reflect.DeepEqual(got, []driver.Value{int64(2)}) {
+		t.Fatalf("claim limits = %v, want [2]", got)
+	}
+
+	close(release[1])
+
+	waitFor(t, func() bool {
+		return len(db.claimLimits()) == 2
+	})
+
+	if got := db.claimLimits(); !reflect.DeepEqual(got, []driver.Value{int64(2), int64(1)}) {
+		t.Errorf("claim limits = %v, want [2 1]", got)
+	}
+
+	close(release[2])
+
+	err := pool.Stop(context.Background())
+	if err != nil {
+		t.Fatalf("Stop returned error: %v", err)
+	}
+
+	if err = <-runErr; err != nil {
+		t.Errorf("Run returned error: %v", err)
+	}
+
+	if done := db.find("SET state = 'done'"); len(done) != 2 {
+		t.Errorf("done update executed %d times, want 2", len(done))
+	}
+}
+
+func TestWorkerPoolForcedShutdownReleasesJobs(t *testing.T) {
+	testCases := []struct {
+		name            string
+		shutdownTimeout time.Duration
+		stopTimeout     time.Duration
+	}{
+		{name: "stop context done", shutdownTimeout: time.Hour, stopTimeout: time.Millisecond * 20},
+		{name: "shutdown timeout exceeded", shutdownTimeout: time.Millisecond * 20, stopTimeout: time.Hour},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := &stubDatabase{claimBatches: [][][]driver.Value{{jobRow(1, 0, 1, 5), jobRow(2, 0, 3, 5)}}}
+
+			started := make(chan struct{}, 2)
+			pool := newTestWorkerPool(t, db, func(ctx context.Context, _ *Job) error {
+				started <- struct{}{}
+				<-ctx.Done()
+
+				return ctx.Err()
+			}, WorkerPoolConfig{Concurrency: 2, PollInterval: time.Hour, ShutdownTimeout: testCase.shutdownTimeout})
+
+			go func() {
+				_ = pool.Run(context.Background())
+			}()
+
+			<-started
+			<-started
+
+			stopCtx, cancel := context.WithTimeout(context.Background(), testCase.stopTimeout)
+			defer cancel()
+
+			err := pool.Stop(stopCtx)
+			if err != nil {
+				t.Fatalf("Stop returned error: %v", err)
+			}
+
+			releases := db.find("SET state = 'pending', attempts = attempts - 1")
+			if len(releases) != 2 {
+				t.Fatalf("release query executed %d times, want 2", len(releases))
+			}
+
+			released := map[driver.Value]driver.Value{}
+			for _, release := range releases {
+				released[release.args[0]] = release.args[1]
+			}
+
+			want := map[driver.Value]driver.Value{int64(1): int64(1), int64(2): int64(3)}
+			if !reflect.DeepEqual(released, want) {
+				t.Errorf("released jobs = %v, want %v", released, want)
+			}
+
+			if failed := db.find("CASE WHEN $4::BOOLEAN THEN 'dead'"); len(failed) != 0 {
+				t.Errorf("released jobs marked as failed: %v", failed)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolRetryDelay(t *testing.T) {
+	pool := &WorkerPool{baseRetryDelay: time.Second, maxRetryDelay: time.Second * 10} //nolint:exhaustruct // only delays used
+
+	testCases := []struct {
+		attempt uint32
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: time.Second * 2},
+		{attempt: 4, want: time.Second * 8},
+		{attempt: 5, want: time.Second * 10},
+		{attempt: 30, want: time.Second * 10},
+	}
+
+	for _, testCase := range testCases {
+		got := pool.retryDelay(testCase.attempt)
+		if got != testCase.want {
+			t.Errorf("retryDelay(%d) = %s, want %s", testCase.attempt, got, testCase.want)
+		}
+	}
+}
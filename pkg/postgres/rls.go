@@ -0,0 +1,132 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrQueryOutsideTransaction = errors.New("query outside of transaction forbidden in row-level-security mode")
+	ErrTxWithoutContext        = errors.New("transaction without context forbidden in row-level-security mode")
+)
+
+// SessionVariableExtractor returns value of session variable from context.
+// If false returned, variable not set in transaction...
+type SessionVariableExtractor func(ctx context.Context) (string, bool)
+
+type sessionVariable struct {
+	name      string
+	extractor SessionVariableExtractor
+}
+
+// RegisterSessionVariable registers session variable, e.g. app.tenant_id, applied with set_config(name, value, true)
+// at the start of every transaction opened with context, so it can be used in RLS policies by current_setting function.
+// Must be called before connection usage...
+func (c *Connection) RegisterSessionVariable(name string, extractor SessionVariableExtractor) {
+	c.sessionVars = append(c.sessionVars, sessionVariable{
+		name:      name,
+		extractor: extractor,
+	})
+}
+
+// SetRowLevelSecurityMode enables or disables row-level-security mode. In this mode all queries outside of
+// transaction statement returns ErrQueryOutsideTransaction, because session variables applied only in transactions.
+// Must be called before connection usage...
+func (c *Connection) SetRowLevelSecurityMode(enabled bool) {
+	c.rlsMode = enabled
+
+	if enabled && c.outsideTxDbx == nil {
+		c.outsideTxDbx = sqlx.NewDb(sql.OpenDB(outsideTxConnector{}), "postgres")
+	}
+}
+
+// outsideTx returns database connection pool for queries outside of transaction.
+// In row-level-security mode pool always returns ErrQueryOutsideTransaction...
+func (c *Connection) outsideTx() *sqlx.DB {
+	if c.rlsMode {
+		return c.outsideTxDbx
+	}
+
+	return c.Dbx
+}
+
+// sessionVariablesStatement returns set_config statement for session variables present in context...
+func (c *Connection) sessionVariablesStatement(ctx context.Context) (string, []interface{}) {
+	if len(c.sessionVars) == 0 {
+		return "", nil
+	}
+
+	calls := make([]string, 0, len(c.sessionVars))
+	args := make([]interface{}, 0, len(c.sessionVars)*2) //nolint:mnd // name and value for each variable
+
+	for _, variable := range c.sessionVars {
+		value, isPresent := variable.extractor(ctx)
+		if !isPresent {
+			continue
+		}
+
+		calls = append(calls, "set_config($"+strconv.Itoa(len(args)+1)+", $"+strconv.Itoa(len(args)+2)+", true)")
+		args = append(args, variable.name, value)
+	}
+
+	if len(calls) == 0 {
+		return "", nil
+	}
+
+	return "SELECT " + strings.Join(calls, ", "), args
+}
+
+// outsideTxConnector is database/sql connector, which always returns ErrQueryOutsideTransaction...
+type outsideTxConnector struct{}
+
+func (outsideTxConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, ErrQueryOutsideTransaction
+}
+
+func (outsideTxConnector) Driver() driver.Driver {
+	return outsideTxDriver{}
+}
+
+type outsideTxDriver struct{}
+
+func (outsideTxDriver) Open(string) (driver.Conn, error) {
+	return nil, ErrQueryOutsideTransaction
+}
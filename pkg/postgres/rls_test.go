@@ -0,0 +1,180 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// newRowLevelSecurityConnection returns connection in row-level-security mode with tenant session variable...
+func newRowLevelSecurityConnection(t *testing.T, db *stubDatabase) *Connection {
+	t.Helper()
+
+	conn := newStubConnection(t, db)
+	conn.RegisterSessionVariable("app.tenant_id", func(ctx context.Context) (string, bool) {
+		tenantID, isPresent := ctx.Value(tenantIDCtxKey{}).(string)
+
+		return tenantID, isPresent
+	})
+	conn.SetRowLevelSecurityMode(true)
+
+	return conn
+}
+
+func TestRowLevelSecurityRejectsQueriesOutsideTransaction(t *testing.T) {
+	testCases := []struct {
+		name  string
+		query func(ctx context.Context, conn *Connection) error
+	}{
+		{name: "Q exec", query: func(ctx context.Context, conn *Connection) error {
+			_, err := conn.Q(ctx).ExecContext(ctx, "UPDATE wallets SET balance = 0")
+
+			return err
+		}},
+		{name: "Q select", query: func(ctx context.Context, conn *Connection) error {
+			var balances []string
+
+			return conn.Q(ctx).SelectContext(ctx, &balances, "SELECT balance FROM wallets")
+		}},
+		{name: "GetOne", query: func(ctx context.Context, conn *Connection) error {
+			_, err := GetOne[string](ctx, conn, "SELECT balance FROM wallets")
+
+			return err
+		}},
+		{name: "TryWithTransaction", query: func(ctx context.Context, conn *Connection) error {
+			return conn.TryWithTransaction(ctx, func(stmt sqlx.Ext) error {
+				_, err := stmt.Exec("UPDATE wallets SET balance = 0")
+
+				return err
+			})
+		}},
+		{name: "TryWithTransactionQuerier", query: func(ctx context.Context, conn *Connection) error {
+			return conn.TryWithTransactionQuerier(ctx, func(stmt Querier) error {
+				_, err := stmt.ExecContext(ctx, "UPDATE wallets SET balance = 0")
+
+				return err
+			})
+		}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := &stubDatabase{}
+			conn := newRowLevelSecurityConnection(t, db)
+			ctx := context.WithValue(context.Background(), tenantIDCtxKey{}, "tenant-1")
+
+			err := testCase.query(ctx, conn)
+			if !errors.Is(err, ErrQueryOutsideTransaction) {
+				t.Errorf("query error = %v, want %v", err, ErrQueryOutsideTransaction)
+			}
+
+			if queries := db.queries(); len(queries) != 0 {
+				t.Errorf("queries outside of transaction reached database: %q", queries)
+			}
+		})
+	}
+}
+
+func TestRowLevelSecurityAllowsQueriesInTransaction(t *testing.T) {
+	db := &stubDatabase{}
+	conn := newRowLevelSecurityConnection(t, db)
+	ctx := context.WithValue(context.Background(), tenantIDCtxKey{}, "tenant-1")
+
+	err := conn.BeginReadCommittedTxRollbackOnError(ctx, func(txStmtCtx context.Context) error {
+		return ExecExpectRows(txStmtCtx, conn, 1, "UPDATE wallets SET balance = 0")
+	})
+	if err != nil {
+		t.Fatalf("tx helper returned error: %v", err)
+	}
+
+	want := []string{"BEGIN", "SELECT set_config($1, $2, true)", "UPDATE wallets SET balance = 0", "COMMIT"}
+	if got := db.queries(); !reflect.DeepEqual(got, want) {
+		t.Errorf("queries = %q, want %q", got, want)
+	}
+
+	setConfig, _ := db.find("SELECT set_config")
+	if !reflect.DeepEqual(setConfig.args, []driver.Value{"app.tenant_id", "tenant-1"}) {
+		t.Errorf("set_config args = %v, want [app.tenant_id tenant-1]", setConfig.args)
+	}
+}
+
+func TestRowLevelSecurityDisabled(t *testing.T) {
+	db := &stubDatabase{}
+	conn := newRowLevelSecurityConnection(t, db)
+	conn.SetRowLevelSecurityMode(false)
+
+	err := ExecExpectRows(context.Background(), conn, 1, "UPDATE wallets SET balance = 0")
+	if err != nil {
+		t.Fatalf("query returned error: %v", err)
+	}
+
+	if got := db.queries(); !reflect.DeepEqual(got, []string{"UPDATE wallets SET balance = 0"}) {
+		t.Errorf("queries = %q, want query outside of transaction", got)
+	}
+}
+
+func TestBeginTxWithoutContext(t *testing.T) {
+	db := &stubDatabase{}
+	conn := newRowLevelSecurityConnection(t, db)
+
+	_, err := conn.BeginTx()
+	if !errors.Is(err, ErrTxWithoutContext) {
+		t.Errorf("BeginTx error = %v, want %v", err, ErrTxWithoutContext)
+	}
+
+	if queries := db.queries(); len(queries) != 0 {
+		t.Errorf("transaction without context started: %q", queries)
+	}
+
+	conn.SetRowLevelSecurityMode(false)
+
+	tx, err := conn.BeginTx()
+	if err != nil {
+		t.Fatalf("BeginTx returned error: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("Commit returned error: %v", err)
+	}
+
+	if got := db.queries(); !reflect.DeepEqual(got, []string{"BEGIN", "COMMIT"}) {
+		t.Errorf("queries = %q, want [BEGIN COMMIT]", got)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// savepointNestingCtxKey is the per-Connection key of savepoint nesting mode flag in context...
+type savepointNestingCtxKey struct {
+	txKey *transactionCtxKey
+}
+
+// savepointCtxKey is the per-Connection key of current savepoint name in context...
+type savepointCtxKey struct {
+	txKey *transactionCtxKey
+}
+
+// savepointSequence is used for generation of unique savepoint names...
+var savepointSequence atomic.Uint64
+
+// ContextWithSavepointNesting returns context in which tx-statement helpers of connection started inside
+// contextual transaction don't begin new transaction, but create savepoint in existing one. Released savepoint
+// used as commit, rollback to savepoint used as rollback. Transaction options not applied to savepoints,
+// registered session variables applied right after SAVEPOINT. Nesting mode of one connection does not affect
+// transactions of other connections. Used by postgrestest package for transaction-per-test isolation...
+func (c *Connection) ContextWithSavepointNesting(ctx context.Context) context.Context {
+	return context.WithValue(ctx, savepointNestingCtxKey{txKey: c.txKey}, true)
+}
+
+// savepointParentTx returns contextual transaction statement, if savepoint nesting mode enabled in context...
+func (c *Connection) savepointParentTx(ctx context.Context) (*sqlx.Tx, bool) {
+	isNesting, _ := ctx.Value(savepointNestingCtxKey{txKey: c.txKey}).(bool)
+	if !isNesting {
+		return nil, false
+	}
+
+	return c.TxFromContext(ctx)
+}
+
+// createSavepoint creates savepoint and applies registered session variables of context, same with beginTx...
+func (c *Connection) createSavepoint(ctx context.Context, txStmt *sqlx.Tx) (string, error) {
+	name := "lib_postgres_sp_" + strconv.FormatUint(savepointSequence.Add(1), 10)
+
+	_, err := txStmt.ExecContext(ctx, "SAVEPOINT "+name)
+	if err != nil {
+		return "", c.ClassifyError(err)
+	}
+
+	sessionVarsStmt, sessionVarsArgs := c.sessionVariablesStatement(ctx)
+	if sessionVarsStmt != "" {
+		_, err = txStmt.ExecContext(ctx, sessionVarsStmt, sessionVarsArgs...)
+		if err != nil {
+			_ = c.rollbackToSavepoint(ctx, txStmt, name)
+
+			return "", c.ClassifyError(err)
+		}
+	}
+
+	return name, nil
+}
+
+// contextWithSavepoint stores name of connection's savepoint in context...
+func (c *Connection) contextWithSavepoint(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, savepointCtxKey{txKey: c.txKey}, name)
+}
+
+func (c *Connection) releaseSavepoint(ctx context.Context, txStmt *sqlx.Tx, name string) error {
+	_, err := txStmt.ExecContext(ctx, "RELEASE SAVEPOINT "+name)
+	if err != nil {
+		return c.ClassifyError(err)
+	}
+
+	return nil
+}
+
+func (c *Connection) rollbackToSavepoint(ctx context.Context, txStmt *sqlx.Tx, name string) error {
+	_, err := txStmt.ExecContext(context.WithoutCancel(ctx), "ROLLBACK TO SAVEPOINT "+name)
+	if err != nil {
+		return c.ClassifyError(err)
+	}
+
+	return nil
+}
+
+// runSavepointRollbackOnError runs callback inside savepoint of parent transaction statement...
+func (c *Connection) runSavepointRollbackOnError(ctx context.Context,
+	txStmt *sqlx.Tx,
+	callback func(txStmtCtx context.Context) error,
+) error {
+	name, err := c.createSavepoint(ctx, txStmt)
+	if err != nil {
+		return c.e.ErrorNoWrap(err)
+	}
+
+	err = callback(c.contextWithSavepoint(ctx, name))
+	if err != nil {
+		rollbackErr := c.rollbackToSavepoint(ctx, txStmt, name)
+		if rollbackErr != nil {
+			return c.e.ErrorNoWrap(rollbackErr)
+		}
+
+		return c.ClassifyError(err)
+	}
+
+	err = c.releaseSavepoint(ctx, txStmt, name)
+	if err != nil {
+		return c.e.ErrorNoWrap(err)
+	}
+
+	return nil
+}
+
+// savepointFromContext returns name of connection's contextual savepoint...
+func (c *Connection) savepointFromContext(ctx context.Context) (string, bool) {
+	name, isSavepoint := ctx.Value(savepointCtxKey{txKey: c.txKey}).(string)
+
+	return name, isSavepoint
+}
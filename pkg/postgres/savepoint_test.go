@@ -0,0 +1,306 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type tenantIDCtxKey struct{}
+
+// savepointNames replaces generated savepoint names in queries by sp{N} placeholders in order of appearance...
+func savepointNames(queries []string) []string {
+	names := make(map[string]string)
+	replaced := make([]string, len(queries))
+
+	for i, query := range queries {
+		fields := strings.Fields(query)
+		for j, field := range fields {
+			if !strings.HasPrefix(field, "lib_postgres_sp_") {
+				continue
+			}
+
+			if _, isKnown := names[field]; !isKnown {
+				names[field] = "sp" + strconv.Itoa(len(names)+1)
+			}
+
+			fields[j] = names[field]
+		}
+
+		replaced[i] = strings.Join(fields, " ")
+	}
+
+	return replaced
+}
+
+func TestSavepointNestingRunTx(t *testing.T) {
+	errCallback := errors.New("callback failed")
+
+	testCases := []struct {
+		name        string
+		callbackErr error
+		want        []string
+	}{
+		{
+			name:        "released on success",
+			callbackErr: nil,
+			want:        []string{"BEGIN", "SAVEPOINT sp1", "INSERT", "RELEASE SAVEPOINT sp1"},
+		},
+		{
+			name:        "rolled back to savepoint on error",
+			callbackErr: errCallback,
+			want:        []string{"BEGIN", "SAVEPOINT sp1", "INSERT", "ROLLBACK TO SAVEPOINT sp1"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := &stubDatabase{}
+			conn := newStubConnection(t, db)
+
+			txCtx, err := conn.BeginContextualTxStatement(context.Background())
+			if err != nil {
+				t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+			}
+
+			rootTx, _ := conn.TxFromContext(txCtx)
+
+			err = conn.BeginTxWithRollbackOnError(conn.ContextWithSavepointNesting(txCtx),
+				func(txStmtCtx context.Context) error {
+					tx, _ := conn.TxFromContext(txStmtCtx)
+					if tx != rootTx {
+						t.Error("savepoint callback does not use root transaction")
+					}
+
+					_, execErr := conn.Q(txStmtCtx).ExecContext(txStmtCtx, "INSERT")
+					if execErr != nil {
+						return execErr
+					}
+
+					return testCase.callbackErr
+				})
+			if !errors.Is(err, testCase.callbackErr) {
+				t.Fatalf("BeginTxWithRollbackOnError error = %v, want %v", err, testCase.callbackErr)
+			}
+
+			got := savepointNames(db.queries())
+			if !reflect.DeepEqual(got, testCase.want) {
+				t.Errorf("queries = %q, want %q", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestSavepointNestingContextualTxStatement(t *testing.T) {
+	db := &stubDatabase{}
+	conn := newStubConnection(t, db)
+
+	txCtx, err := conn.BeginContextualTxStatement(context.Background())
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	nestingCtx := conn.ContextWithSavepointNesting(txCtx)
+
+	committedCtx, err := conn.BeginContextualTxStatement(nestingCtx)
+	if err != nil {
+		t.Fatalf("nested BeginContextualTxStatement returned error: %v", err)
+	}
+
+	err = conn.CommitContextualTxStatement(committedCtx)
+	if err != nil {
+		t.Fatalf("nested CommitContextualTxStatement returned error: %v", err)
+	}
+
+	rolledBackCtx, err := conn.BeginContextualTxStatement(nestingCtx)
+	if err != nil {
+		t.Fatalf("nested BeginContextualTxStatement returned error: %v", err)
+	}
+
+	err = conn.RollbackContextualTxStatement(rolledBackCtx)
+	if err != nil {
+		t.Fatalf("nested RollbackContextualTxStatement returned error: %v", err)
+	}
+
+	err = conn.RollbackContextualTxStatement(txCtx)
+	if err != nil {
+		t.Fatalf("RollbackContextualTxStatement returned error: %v", err)
+	}
+
+	want := []string{
+		"BEGIN",
+		"SAVEPOINT sp1", "RELEASE SAVEPOINT sp1",
+		"SAVEPOINT sp2", "ROLLBACK TO SAVEPOINT sp2",
+		"ROLLBACK",
+	}
+
+	got := savepointNames(db.queries())
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("queries = %q, want %q", got, want)
+	}
+}
+
+func TestSavepointNestingIsolatedBetweenConnections(t *testing.T) {
+	walletsDB := &stubDatabase{}
+	walletsConn := newStubConnection(t, walletsDB)
+	auditDB := &stubDatabase{}
+	auditConn := newStubConnection(t, auditDB)
+
+	txCtx, err := walletsConn.BeginContextualTxStatement(context.Background())
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	err = walletsConn.BeginTxWithRollbackOnError(walletsConn.ContextWithSavepointNesting(txCtx),
+		func(txStmtCtx context.Context) error {
+			// audit connection must begin and commit own transaction, not savepoint of wallets transaction
+			auditCtx, beginErr := auditConn.BeginContextualTxStatement(txStmtCtx)
+			if beginErr != nil {
+				return beginErr
+			}
+
+			_, execErr := auditConn.Q(auditCtx).ExecContext(auditCtx, "INSERT INTO audit")
+			if execErr != nil {
+				return execErr
+			}
+
+			commitErr := auditConn.CommitContextualTxStatement(auditCtx)
+			if commitErr != nil {
+				return commitErr
+			}
+
+			return auditConn.BeginTxWithRollbackOnError(txStmtCtx, func(context.Context) error {
+				return nil
+			})
+		})
+	if err != nil {
+		t.Fatalf("BeginTxWithRollbackOnError returned error: %v", err)
+	}
+
+	wantAudit := []string{"BEGIN", "INSERT INTO audit", "COMMIT", "BEGIN", "COMMIT"}
+	if got := auditDB.queries(); !reflect.DeepEqual(got, wantAudit) {
+		t.Errorf("audit queries = %q, want %q", got, wantAudit)
+	}
+
+	wantWallets := []string{"BEGIN", "SAVEPOINT sp1", "RELEASE SAVEPOINT sp1"}
+	if got := savepointNames(walletsDB.queries()); !reflect.DeepEqual(got, wantWallets) {
+		t.Errorf("wallets queries = %q, want %q", got, wantWallets)
+	}
+}
+
+func TestSavepointAppliesSessionVariables(t *testing.T) {
+	db := &stubDatabase{}
+	conn := newStubConnection(t, db)
+	conn.RegisterSessionVariable("app.tenant_id", func(ctx context.Context) (string, bool) {
+		tenantID, isPresent := ctx.Value(tenantIDCtxKey{}).(string)
+
+		return tenantID, isPresent
+	})
+
+	txCtx, err := conn.BeginContextualTxStatement(context.Background())
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	tenantCtx := context.WithValue(conn.ContextWithSavepointNesting(txCtx), tenantIDCtxKey{}, "tenant-1")
+
+	err = conn.BeginTxWithRollbackOnError(tenantCtx, func(context.Context) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("BeginTxWithRollbackOnError returned error: %v", err)
+	}
+
+	want := []string{
+		"BEGIN",
+		"SAVEPOINT sp1", "SELECT set_config($1, $2, true)", "RELEASE SAVEPOINT sp1",
+	}
+
+	if got := savepointNames(db.queries()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("queries = %q, want %q", got, want)
+	}
+
+	setConfig, _ := db.find("SELECT set_config")
+	if !reflect.DeepEqual(setConfig.args, []driver.Value{"app.tenant_id", "tenant-1"}) {
+		t.Errorf("set_config args = %v, want [app.tenant_id tenant-1]", setConfig.args)
+	}
+}
+
+func TestSavepointSessionVariablesFailure(t *testing.T) {
+	errSetConfig := errors.New("unrecognized configuration parameter")
+
+	db := &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+		if strings.HasPrefix(statement.query, "SELECT set_config") {
+			return stubResult{columns: nil, rows: nil, rowsAffected: 0, err: errSetConfig}, true
+		}
+
+		return stubResult{}, false //nolint:exhaustruct // result not scripted
+	}}
+	conn := newStubConnection(t, db)
+
+	txCtx, err := conn.BeginContextualTxStatement(context.Background())
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	// registered after BEGIN, so only savepoint applies session variables
+	conn.RegisterSessionVariable("app.tenant_id", func(context.Context) (string, bool) {
+		return "tenant-1", true
+	})
+
+	isCalled := false
+
+	err = conn.BeginTxWithRollbackOnError(conn.ContextWithSavepointNesting(txCtx), func(context.Context) error {
+		isCalled = true
+
+		return nil
+	})
+	if !errors.Is(err, errSetConfig) {
+		t.Fatalf("BeginTxWithRollbackOnError error = %v, want %v", err, errSetConfig)
+	}
+
+	if isCalled {
+		t.Error("callback called, but session variables not applied")
+	}
+
+	want := []string{"BEGIN", "SAVEPOINT sp1", "SELECT set_config($1, $2, true)", "ROLLBACK TO SAVEPOINT sp1"}
+	if got := savepointNames(db.queries()); !reflect.DeepEqual(got, want) {
+		t.Errorf("queries = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx/reflectx"
+)
+
+var ErrNotStructType = errors.New("type is not a struct")
+
+// structMapping is the list of top-level columns of struct, mapped by db tags same with sqlx...
+type structMapping struct {
+	columns []string
+	fields  []*reflectx.FieldInfo
+}
+
+func (m *structMapping) values(item reflect.Value) []interface{} {
+	item = reflect.Indirect(item)
+	values := make([]interface{}, len(m.fields))
+
+	for i, field := range m.fields {
+		values[i] = reflectx.FieldByIndexesReadOnly(item, field.Index).Interface()
+	}
+
+	return values
+}
+
+// field returns field info by column name...
+func (m *structMapping) field(column string) (*reflectx.FieldInfo, bool) {
+	for i, name := range m.columns {
+		if name == column {
+			return m.fields[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func newStructMapping(mapper *reflectx.Mapper, itemType reflect.Type) (*structMapping, error) {
+	itemType = reflectx.Deref(itemType)
+	if itemType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %s", ErrNotStructType, itemType.String())
+	}
+
+	typeMap := mapper.TypeMap(itemType)
+
+	mapping := &structMapping{
+		columns: make([]string, 0, len(typeMap.Index)),
+		fields:  make([]*reflectx.FieldInfo, 0, len(typeMap.Index)),
+	}
+
+	for _, field := range typeMap.Index {
+		if field.Embedded || strings.Contains(field.Path, ".") || typeMap.Paths[field.Path] != field {
+			continue
+		}
+
+		mapping.columns = append(mapping.columns, field.Path)
+		mapping.fields = append(mapping.fields, field)
+	}
+
+	return mapping, nil
+}
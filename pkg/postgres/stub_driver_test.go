@@ -0,0 +1,288 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// stubDriverSequence is used for generation of unique names of registered stub drivers...
+var stubDriverSequence atomic.Uint64
+
+// stubStatement is the statement executed through stub driver. Transaction boundaries
+// recorded as BEGIN, COMMIT and ROLLBACK statements...
+type stubStatement struct {
+	query string
+	args  []driver.Value
+}
+
+// stubResult is the scripted result of statement...
+type stubResult struct {
+	columns      []string
+	rows         [][]driver.Value
+	rowsAffected int64
+	err          error
+}
+
+// stubDatabase is the database/sql driver, which records executed statements and serves scripted results.
+// Statements without scripted result succeed with one affected row or empty result set...
+type stubDatabase struct {
+	mu sync.Mutex
+
+	statements []stubStatement
+	// respond returns scripted result of statement, second value is false if result not scripted
+	respond func(statement stubStatement) (stubResult, bool)
+}
+
+func (d *stubDatabase) Open(_ string) (driver.Conn, error) {
+	return &stubConn{db: d}, nil
+}
+
+// execute records statement and returns its result...
+func (d *stubDatabase) execute(query string, args []driver.Value) stubResult {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	statement := stubStatement{query: query, args: args}
+	d.statements = append(d.statements, statement)
+
+	if d.respond != nil {
+		result, isScripted := d.respond(statement)
+		if isScripted {
+			return result
+		}
+	}
+
+	if query == "SELECT 1" {
+		return stubResult{columns: []string{"?column?"}, rows: [][]driver.Value{{int64(1)}}, rowsAffected: 0, err: nil}
+	}
+
+	return stubResult{columns: nil, rows: nil, rowsAffected: 1, err: nil}
+}
+
+// queries returns executed statements, except connection check...
+func (d *stubDatabase) queries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queries := make([]string, 0, len(d.statements))
+
+	for _, statement := range d.statements {
+		if statement.query != "SELECT 1" {
+			queries = append(queries, statement.query)
+		}
+	}
+
+	return queries
+}
+
+// find returns last executed statement with given prefix...
+func (d *stubDatabase) find(prefix string) (stubStatement, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := len(d.statements) - 1; i >= 0; i-- {
+		if strings.HasPrefix(d.statements[i].query, prefix) {
+			return d.statements[i], true
+		}
+	}
+
+	return stubStatement{query: "", args: nil}, false
+}
+
+type stubConn struct {
+	db *stubDatabase
+}
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return &stubStmt{conn: c, query: query}, nil
+}
+
+func (c *stubConn) Close() error {
+	return nil
+}
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{Isolation: 0, ReadOnly: false})
+}
+
+func (c *stubConn) BeginTx(_ context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	result := c.db.execute("BEGIN", nil)
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	return &stubTx{conn: c}, nil
+}
+
+func (c *stubConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result := c.db.execute(query, stubValues(args))
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	return driver.RowsAffected(result.rowsAffected), nil
+}
+
+func (c *stubConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.execute(query, stubValues(args))
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	return &stubRows{columns: result.columns, rows: result.rows}, nil
+}
+
+type stubStmt struct {
+	conn  *stubConn
+	query string
+}
+
+func (s *stubStmt) Close() error {
+	return nil
+}
+
+func (s *stubStmt) NumInput() int {
+	return -1
+}
+
+func (s *stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	result := s.conn.db.execute(s.query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	return driver.RowsAffected(result.rowsAffected), nil
+}
+
+func (s *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	result := s.conn.db.execute(s.query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	return &stubRows{columns: result.columns, rows: result.rows}, nil
+}
+
+type stubTx struct {
+	conn *stubConn
+}
+
+func (t *stubTx) Commit() error {
+	return t.conn.db.execute("COMMIT", nil).err
+}
+
+func (t *stubTx) Rollback() error {
+	return t.conn.db.execute("ROLLBACK", nil).err
+}
+
+type stubRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string {
+	return r.columns
+}
+
+func (r *stubRows) Close() error {
+	return nil
+}
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+
+	return nil
+}
+
+func stubValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+
+	return values
+}
+
+// newStubConnection registers stub driver and returns connected connection, which uses it...
+func newStubConnection(t *testing.T, db *stubDatabase, opts ...Option) *Connection {
+	t.Helper()
+
+	return newDriverConnection(t, func(driverName string) {
+		sql.Register(driverName, db)
+	}, opts...)
+}
+
+// newDriverConnection registers driver by given function and returns connected connection, which uses it...
+func newDriverConnection(t *testing.T, register func(driverName string), opts ...Option) *Connection {
+	t.Helper()
+
+	driverName := fmt.Sprintf("postgres-stub-%d", stubDriverSequence.Add(1))
+	register(driverName)
+
+	opts = append([]Option{
+		WithDriver(driverName),
+		WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	}, opts...)
+
+	conn, err := NewConnectionFromDSN("host=localhost user=wallet dbname=wallets", opts...)
+	if err != nil {
+		t.Fatalf("unable to create connection: %v", err)
+	}
+
+	_, err = conn.Connect()
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn
+}
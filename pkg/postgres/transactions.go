@@ -36,6 +36,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"log/slog"
 
 	"github.com/jmoiron/sqlx"
 )
@@ -45,16 +46,16 @@ var (
 	ErrNotInContextualTxStatement      = errors.New("unable to commit transaction statement - not in tx statement")
 )
 
-type transactionCtxKey string
-
-//nolint:gochecknoglobals // it's ok
-var transactionKey = transactionCtxKey("transaction")
-
-// BeginTx ....
+// BeginTx starts transaction without context. Registered session variables applied with background context,
+// in row-level-security mode ErrTxWithoutContext returned...
 func (c *Connection) BeginTx() (*sqlx.Tx, error) {
-	tx, err := c.Dbx.Beginx()
+	if c.rlsMode {
+		return nil, c.e.ErrorOnly(ErrTxWithoutContext)
+	}
+
+	tx, err := c.beginTx(context.Background(), nil, newTxOptions())
 	if err != nil {
-		return nil, c.e.ErrorOnly(err)
+		return nil, c.e.ErrorNoWrap(err)
 	}
 
 	return tx, nil
@@ -63,8 +64,9 @@ func (c *Connection) BeginTx() (*sqlx.Tx, error) {
 // BeginTxWithRollbackOnError ....
 func (c *Connection) BeginTxWithRollbackOnError(ctx context.Context,
 	callback func(txStmtCtx context.Context) error,
+	opts ...TxOption,
 ) error {
-	err := c.BeginReadCommittedTxRollbackOnError(ctx, callback)
+	err := c.BeginReadCommittedTxRollbackOnError(ctx, callback, opts...)
 	if err != nil {
 		return c.e.ErrorNoWrap(err)
 	}
@@ -74,98 +76,202 @@ func (c *Connection) BeginTxWithRollbackOnError(ctx context.Context,
 
 func (c *Connection) BeginReadCommittedTxRollbackOnError(ctx context.Context,
 	callback func(txStmtCtx context.Context) error,
+	opts ...TxOption,
+) error {
+	return c.runTxRollbackOnError(ctx, nil, callback, opts...)
+}
+
+func (c *Connection) BeginReadUncommittedTxRollbackOnError(ctx context.Context,
+	callback func(txStmtCtx context.Context) error,
+	opts ...TxOption,
+) error {
+	return c.runTxRollbackOnError(ctx, &sql.TxOptions{
+		Isolation: sql.LevelReadUncommitted,
+		ReadOnly:  false,
+	}, callback, opts...)
+}
+
+func (c *Connection) runTxRollbackOnError(ctx context.Context,
+	isolation *sql.TxOptions,
+	callback func(txStmtCtx context.Context) error,
+	opts ...TxOption,
 ) error {
-	txStmt, err := c.Dbx.Beginx()
+	parentTx, isNested := c.savepointParentTx(ctx)
+	if isNested {
+		return c.runSavepointRollbackOnError(ctx, parentTx, callback)
+	}
+
+	txOpts := newTxOptions(opts...)
+
+	txStmt, err := c.beginTx(ctx, isolation, txOpts)
 	if err != nil {
-		return c.e.ErrorOnly(err)
+		return c.e.ErrorNoWrap(err)
 	}
 
-	newCtx := context.WithValue(ctx, transactionKey, txStmt)
+	newCtx := c.contextWithTxOptions(ctx, txStmt, txOpts)
 
 	err = callback(newCtx)
 	if err != nil {
 		rollbackErr := txStmt.Rollback()
 		if rollbackErr != nil {
+			c.l.Warn("unable to rollback transaction, probably tx in pending status",
+				slog.Any(ErrorTag, rollbackErr))
+
 			return c.e.ErrorOnly(rollbackErr)
 		}
 
-		return c.e.ErrorOnly(err)
+		return c.ClassifyErrorContext(newCtx, err)
 	}
 
-	err = txStmt.Commit()
+	err = c.commitTx(ctx, txStmt, txOpts)
 	if err != nil {
-		return c.e.ErrorOnly(err)
+		return c.e.ErrorNoWrap(err)
 	}
 
 	return nil
 }
 
-func (c *Connection) BeginReadUncommittedTxRollbackOnError(ctx context.Context,
-	callback func(txStmtCtx context.Context) error,
-) error {
-	txStmt, err := c.Dbx.BeginTx(ctx, &sql.TxOptions{
-		Isolation: sql.LevelReadUncommitted,
-		ReadOnly:  false,
-	})
+// beginTx starts transaction and applies timeouts from transaction options and registered session variables
+// right after BEGIN...
+func (c *Connection) beginTx(ctx context.Context,
+	isolation *sql.TxOptions,
+	txOpts *txOptions,
+) (*sqlx.Tx, error) {
+	setLocalStmt, err := txOpts.setLocalStatement(ctx)
 	if err != nil {
-		return c.e.ErrorOnly(err)
+		return nil, c.e.ErrorOnly(err)
 	}
 
-	newCtx := context.WithValue(ctx, transactionKey, txStmt)
-
-	err = callback(newCtx)
+	txStmt, err := c.Dbx.BeginTxx(ctx, isolation)
 	if err != nil {
-		rollbackErr := txStmt.Rollback()
-		if rollbackErr != nil {
-			c.l.Warn("unable to rollback transaction, probably tx in pending status", rollbackErr)
+		return nil, c.ClassifyError(err)
+	}
 
-			return c.e.ErrorOnly(rollbackErr)
-		}
+	if setLocalStmt != "" {
+		_, err = txStmt.ExecContext(ctx, setLocalStmt)
+		if err != nil {
+			_ = txStmt.Rollback()
 
-		return c.e.ErrorOnly(err)
+			return nil, c.ClassifyError(err)
+		}
 	}
 
-	err = txStmt.Commit()
-	if err != nil {
-		return c.e.ErrorOnly(err)
+	sessionVarsStmt, sessionVarsArgs := c.sessionVariablesStatement(ctx)
+	if sessionVarsStmt != "" {
+		_, err = txStmt.ExecContext(ctx, sessionVarsStmt, sessionVarsArgs...)
+		if err != nil {
+			_ = txStmt.Rollback()
+
+			return nil, c.ClassifyError(err)
+		}
 	}
 
-	return nil
+	return txStmt, nil
 }
 
 // BeginContextualTxStatement ....
-func (c *Connection) BeginContextualTxStatement(ctx context.Context) (context.Context, error) {
-	txStmt, err := c.Dbx.Beginx()
+func (c *Connection) BeginContextualTxStatement(ctx context.Context, opts ...TxOption) (context.Context, error) {
+	parentTx, isNested := c.savepointParentTx(ctx)
+	if isNested {
+		name, err := c.createSavepoint(ctx, parentTx)
+		if err != nil {
+			return nil, c.e.ErrorNoWrap(err)
+		}
+
+		return c.contextWithSavepoint(ctx, name), nil
+	}
+
+	txOpts := newTxOptions(opts...)
+
+	txStmt, err := c.beginTx(ctx, nil, txOpts)
 	if err != nil {
-		return nil, c.e.ErrorOnly(err)
+		return nil, c.e.ErrorNoWrap(err)
 	}
 
-	return context.WithValue(ctx, transactionKey, txStmt), nil
+	return c.contextWithTxOptions(ctx, txStmt, txOpts), nil
 }
 
-// CommitContextualTxStatement ....
-func (c *Connection) CommitContextualTxStatement(ctx context.Context) error {
-	tx, inTransaction := ctx.Value(transactionKey).(*sqlx.Tx)
-	if !inTransaction {
-		return c.e.ErrorOnly(ErrNotInContextualTxStatement)
+// contextWithTxOptions stores transaction statement in context and marks it, if it was started
+// with statement timeout...
+func (c *Connection) contextWithTxOptions(ctx context.Context, txStmt *sqlx.Tx, txOpts *txOptions) context.Context {
+	txCtx := c.contextWithTx(ctx, txStmt)
+	if txOpts.isStatementTimeoutSet(ctx) {
+		txCtx = c.contextWithStatementTimeout(txCtx, txStmt)
 	}
 
-	err := tx.Commit()
+	return txCtx
+}
+
+// CommitContextualTxStatement ....
+func (c *Connection) CommitContextualTxStatement(ctx context.Context, opts ...TxOption) error {
+	tx, err := c.mustTxFromContext(ctx, ErrNotInContextualTxStatement)
 	if err != nil {
 		return c.e.ErrorOnly(err)
 	}
 
+	savepoint, isSavepoint := c.savepointFromContext(ctx)
+	if isSavepoint {
+		return c.releaseSavepoint(ctx, tx, savepoint)
+	}
+
+	err = c.commitTx(ctx, tx, newTxOptions(opts...))
+	if err != nil {
+		return c.e.ErrorNoWrap(err)
+	}
+
+	return nil
+}
+
+// commitTx commits transaction statement. If connection was lost while COMMIT was in flight
+// transaction outcome is unknown - commit verifier is used to re-check state on fresh connection...
+func (c *Connection) commitTx(ctx context.Context, txStmt *sqlx.Tx, txOpts *txOptions) error {
+	commitErr := txStmt.Commit()
+	if commitErr == nil {
+		return nil
+	}
+
+	if !IsConnectionError(commitErr) {
+		return c.ClassifyError(commitErr)
+	}
+
+	if txOpts.commitVerifier == nil {
+		return c.e.ErrorOnly(&CommitOutcomeUnknownError{
+			Err:             commitErr,
+			VerificationErr: nil,
+		})
+	}
+
+	committed, verifyErr := txOpts.commitVerifier(ctx, c.Dbx)
+	if verifyErr != nil {
+		return c.e.ErrorOnly(&CommitOutcomeUnknownError{
+			Err:             commitErr,
+			VerificationErr: verifyErr,
+		})
+	}
+
+	if !committed {
+		return c.ClassifyError(commitErr)
+	}
+
+	c.l.Warn("connection lost during commit, but commit verifier confirmed that transaction was committed",
+		slog.Any(ErrorTag, commitErr))
+
 	return nil
 }
 
 // RollbackContextualTxStatement ....
 func (c *Connection) RollbackContextualTxStatement(ctx context.Context) error {
-	tx, inTransaction := ctx.Value(transactionKey).(*sqlx.Tx)
-	if !inTransaction {
-		return c.e.ErrorOnly(ErrNotInContextualTxStatement)
+	tx, err := c.mustTxFromContext(ctx, ErrNotInContextualTxStatement)
+	if err != nil {
+		return c.e.ErrorOnly(err)
 	}
 
-	err := tx.Rollback()
+	savepoint, isSavepoint := c.savepointFromContext(ctx)
+	if isSavepoint {
+		return c.rollbackToSavepoint(ctx, tx, savepoint)
+	}
+
+	err = tx.Rollback()
 	if err != nil {
 		return c.e.ErrorOnly(err)
 	}
@@ -174,9 +280,9 @@ func (c *Connection) RollbackContextualTxStatement(ctx context.Context) error {
 }
 
 func (c *Connection) TryWithTransaction(ctx context.Context, sqlExecutionFunc func(stmt sqlx.Ext) error) error {
-	stmt := sqlx.Ext(c.Dbx)
+	stmt := sqlx.Ext(c.outsideTx())
 
-	tx, inTransaction := ctx.Value(transactionKey).(*sqlx.Tx)
+	tx, inTransaction := c.TxFromContext(ctx)
 	if inTransaction {
 		stmt = tx
 	}
@@ -185,10 +291,40 @@ func (c *Connection) TryWithTransaction(ctx context.Context, sqlExecutionFunc fu
 }
 
 func (c *Connection) MustWithTransaction(ctx context.Context, sqlInTxExecutionFunc func(stmt *sqlx.Tx) error) error {
-	tx, inTransaction := ctx.Value(transactionKey).(*sqlx.Tx)
+	tx, err := c.mustTxFromContext(ctx, ErrUnableGetTransactionFromContext)
+	if err != nil {
+		return c.e.ErrorOnly(err)
+	}
+
+	return sqlInTxExecutionFunc(tx)
+}
+
+// Q returns transaction statement from context if present, otherwise database connection pool.
+// In row-level-security mode all queries of returned pool fails with ErrQueryOutsideTransaction...
+func (c *Connection) Q(ctx context.Context) Querier {
+	tx, inTransaction := c.TxFromContext(ctx)
 	if inTransaction {
-		return sqlInTxExecutionFunc(tx)
+		return tx
+	}
+
+	return c.outsideTx()
+}
+
+// TryWithTransactionQuerier same with TryWithTransaction, but callback receives context-aware Querier...
+func (c *Connection) TryWithTransactionQuerier(ctx context.Context,
+	sqlExecutionFunc func(stmt Querier) error,
+) error {
+	return sqlExecutionFunc(c.Q(ctx))
+}
+
+// MustWithTransactionQuerier same with MustWithTransaction, but callback receives context-aware Querier...
+func (c *Connection) MustWithTransactionQuerier(ctx context.Context,
+	sqlInTxExecutionFunc func(stmt Querier) error,
+) error {
+	tx, err := c.mustTxFromContext(ctx, ErrUnableGetTransactionFromContext)
+	if err != nil {
+		return c.e.ErrorOnly(err)
 	}
 
-	return c.e.ErrorOnly(ErrUnableGetTransactionFromContext)
+	return sqlInTxExecutionFunc(tx)
 }
@@ -0,0 +1,122 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var ErrForeignTransaction = errors.New("transaction in context belongs to another database connection")
+
+// transactionCtxKey is unique per Connection key of transaction statement in context...
+type transactionCtxKey struct {
+	name string
+}
+
+type transactionOwnerCtxKey struct{}
+
+// statementTimeoutCtxKey is the key of transaction statement, which was started with statement timeout...
+type statementTimeoutCtxKey struct {
+	txKey *transactionCtxKey
+}
+
+// newTransactionCtxKey ....
+func newTransactionCtxKey() *transactionCtxKey {
+	return &transactionCtxKey{
+		name: "transaction",
+	}
+}
+
+// contextWithTx stores transaction statement in context under connection's own key.
+// Also connection stored as owner of last started transaction, for detection of foreign transactions...
+func (c *Connection) contextWithTx(ctx context.Context, txStmt *sqlx.Tx) context.Context {
+	txCtx := context.WithValue(ctx, c.txKey, txStmt)
+
+	return context.WithValue(txCtx, transactionOwnerCtxKey{}, c)
+}
+
+// contextWithStatementTimeout marks transaction statement in context as started with statement timeout...
+func (c *Connection) contextWithStatementTimeout(ctx context.Context, txStmt *sqlx.Tx) context.Context {
+	return context.WithValue(ctx, statementTimeoutCtxKey{txKey: c.txKey}, txStmt)
+}
+
+// isStatementTimeoutSet reports whether contextual transaction was started with statement timeout
+// and context was not cancelled. Context deadline is not treated as cancellation, because statement timeout
+// can be derived from it...
+func (c *Connection) isStatementTimeoutSet(ctx context.Context) bool {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return false
+	}
+
+	tx, inTransaction := c.TxFromContext(ctx)
+	if !inTransaction {
+		return false
+	}
+
+	timeoutTx, isSet := ctx.Value(statementTimeoutCtxKey{txKey: c.txKey}).(*sqlx.Tx)
+
+	return isSet && timeoutTx == tx
+}
+
+// TxFromContext returns transaction statement of current connection from context...
+func (c *Connection) TxFromContext(ctx context.Context) (*sqlx.Tx, bool) {
+	tx, inTransaction := ctx.Value(c.txKey).(*sqlx.Tx)
+
+	return tx, inTransaction
+}
+
+// InTransaction reports whether context contains transaction statement of current connection...
+func (c *Connection) InTransaction(ctx context.Context) bool {
+	_, inTransaction := c.TxFromContext(ctx)
+
+	return inTransaction
+}
+
+// mustTxFromContext returns transaction statement of current connection from context.
+// If context contains only transaction of another connection ErrForeignTransaction returned...
+func (c *Connection) mustTxFromContext(ctx context.Context, notInTxErr error) (*sqlx.Tx, error) {
+	tx, inTransaction := c.TxFromContext(ctx)
+	if inTransaction {
+		return tx, nil
+	}
+
+	owner, hasOwner := ctx.Value(transactionOwnerCtxKey{}).(*Connection)
+	if hasOwner && owner != c {
+		return nil, ErrForeignTransaction
+	}
+
+	return nil, notInTxErr
+}
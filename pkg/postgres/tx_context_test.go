@@ -0,0 +1,104 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestContextualTxIsolatedBetweenConnections(t *testing.T) {
+	walletsDB := &stubDatabase{}
+	walletsConn := newStubConnection(t, walletsDB)
+	auditDB := &stubDatabase{}
+	auditConn := newStubConnection(t, auditDB)
+
+	auditCtx, err := auditConn.BeginContextualTxStatement(context.Background())
+	if err != nil {
+		t.Fatalf("BeginContextualTxStatement returned error: %v", err)
+	}
+
+	if !auditConn.InTransaction(auditCtx) || walletsConn.InTransaction(auditCtx) {
+		t.Fatal("transaction of audit connection visible to wallets connection")
+	}
+
+	// wallets query uses own connection pool, not audit transaction
+	err = ExecExpectRows(auditCtx, walletsConn, 1, "UPDATE wallets SET balance = 0")
+	if err != nil {
+		t.Fatalf("wallets query returned error: %v", err)
+	}
+
+	for name, stmtErr := range map[string]error{
+		"commit":   walletsConn.CommitContextualTxStatement(auditCtx),
+		"rollback": walletsConn.RollbackContextualTxStatement(auditCtx),
+		"must with transaction": walletsConn.MustWithTransactionQuerier(auditCtx, func(Querier) error {
+			return nil
+		}),
+	} {
+		if !errors.Is(stmtErr, ErrForeignTransaction) {
+			t.Errorf("wallets %s error = %v, want %v", name, stmtErr, ErrForeignTransaction)
+		}
+	}
+
+	err = walletsConn.BeginTxWithRollbackOnError(auditCtx, func(walletsCtx context.Context) error {
+		walletsTx, _ := walletsConn.TxFromContext(walletsCtx)
+		auditTx, _ := auditConn.TxFromContext(walletsCtx)
+
+		outerAuditTx, _ := auditConn.TxFromContext(auditCtx)
+		if walletsTx == nil || auditTx != outerAuditTx {
+			t.Error("nested wallets transaction replaced audit transaction in context")
+		}
+
+		return ExecExpectRows(walletsCtx, auditConn, 1, "INSERT INTO audit")
+	})
+	if err != nil {
+		t.Fatalf("wallets transaction returned error: %v", err)
+	}
+
+	err = auditConn.CommitContextualTxStatement(auditCtx)
+	if err != nil {
+		t.Fatalf("CommitContextualTxStatement returned error: %v", err)
+	}
+
+	wantWallets := []string{"UPDATE wallets SET balance = 0", "BEGIN", "COMMIT"}
+	if got := walletsDB.queries(); !reflect.DeepEqual(got, wantWallets) {
+		t.Errorf("wallets queries = %q, want %q", got, wantWallets)
+	}
+
+	wantAudit := []string{"BEGIN", "INSERT INTO audit", "COMMIT"}
+	if got := auditDB.queries(); !reflect.DeepEqual(got, wantAudit) {
+		t.Errorf("audit queries = %q, want %q", got, wantAudit)
+	}
+}
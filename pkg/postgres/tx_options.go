@@ -0,0 +1,169 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrContextDeadlineExceeded = errors.New("unable to begin transaction - context deadline already exceeded")
+
+// CommitVerifier re-checks on fresh connection whether transaction was committed.
+// Called only if connection was lost while COMMIT was in flight...
+type CommitVerifier func(ctx context.Context, stmt Querier) (bool, error)
+
+// TxOption is optional setting of transaction statement...
+type TxOption func(opts *txOptions)
+
+type txOptions struct {
+	statementTimeout         time.Duration
+	lockTimeout              time.Duration
+	idleInTransactionTimeout time.Duration
+
+	statementTimeoutFromDeadline bool
+
+	commitVerifier CommitVerifier
+}
+
+// WithStatementTimeout sets statement_timeout for all statements of transaction...
+func WithStatementTimeout(timeout time.Duration) TxOption {
+	return func(opts *txOptions) {
+		opts.statementTimeout = timeout
+	}
+}
+
+// WithLockTimeout sets lock_timeout for all statements of transaction. Lock timeout errors
+// can be matched with ErrLockNotAvailable, same with failed NOWAIT lock requests...
+func WithLockTimeout(timeout time.Duration) TxOption {
+	return func(opts *txOptions) {
+		opts.lockTimeout = timeout
+	}
+}
+
+// WithIdleInTransactionTimeout sets idle_in_transaction_session_timeout for transaction...
+func WithIdleInTransactionTimeout(timeout time.Duration) TxOption {
+	return func(opts *txOptions) {
+		opts.idleInTransactionTimeout = timeout
+	}
+}
+
+// WithStatementTimeoutFromContext derives statement_timeout from context deadline.
+// If WithStatementTimeout also passed - the smallest timeout will be used...
+func WithStatementTimeoutFromContext() TxOption {
+	return func(opts *txOptions) {
+		opts.statementTimeoutFromDeadline = true
+	}
+}
+
+// WithCommitVerifier sets callback for verification of transaction state in case of unknown commit outcome.
+// Without verifier CommitOutcomeUnknownError returned if connection was lost during commit...
+func WithCommitVerifier(verifier CommitVerifier) TxOption {
+	return func(opts *txOptions) {
+		opts.commitVerifier = verifier
+	}
+}
+
+func newTxOptions(opts ...TxOption) *txOptions {
+	txOpts := &txOptions{
+		statementTimeout:             0,
+		lockTimeout:                  0,
+		idleInTransactionTimeout:     0,
+		statementTimeoutFromDeadline: false,
+		commitVerifier:               nil,
+	}
+
+	for _, opt := range opts {
+		opt(txOpts)
+	}
+
+	return txOpts
+}
+
+// isStatementTimeoutSet reports whether statement_timeout applied to transaction...
+func (o *txOptions) isStatementTimeoutSet(ctx context.Context) bool {
+	if o.statementTimeout > 0 {
+		return true
+	}
+
+	_, hasDeadline := ctx.Deadline()
+
+	return o.statementTimeoutFromDeadline && hasDeadline
+}
+
+// setLocalStatement returns SET LOCAL statements for transaction timeouts, or empty string if nothing to set...
+func (o *txOptions) setLocalStatement(ctx context.Context) (string, error) {
+	statementTimeout := o.statementTimeout
+
+	if o.statementTimeoutFromDeadline {
+		deadline, hasDeadline := ctx.Deadline()
+		if hasDeadline {
+			untilDeadline := time.Until(deadline)
+			if untilDeadline <= 0 {
+				return "", ErrContextDeadlineExceeded
+			}
+
+			if statementTimeout == 0 || untilDeadline < statementTimeout {
+				statementTimeout = untilDeadline
+			}
+		}
+	}
+
+	statements := make([]string, 0, 3) //nolint:mnd // count of timeout settings
+
+	if statementTimeout > 0 {
+		statements = append(statements, formatSetLocal("statement_timeout", statementTimeout))
+	}
+
+	if o.lockTimeout > 0 {
+		statements = append(statements, formatSetLocal("lock_timeout", o.lockTimeout))
+	}
+
+	if o.idleInTransactionTimeout > 0 {
+		statements = append(statements, formatSetLocal("idle_in_transaction_session_timeout",
+			o.idleInTransactionTimeout))
+	}
+
+	return strings.Join(statements, "; "), nil
+}
+
+// formatSetLocal formats SET LOCAL statement. Timeout rounded up to millisecond,
+// because zero value disables timeout in PostgreSQL...
+func formatSetLocal(setting string, timeout time.Duration) string {
+	milliseconds := (timeout + time.Millisecond - 1) / time.Millisecond
+
+	return fmt.Sprintf("SET LOCAL %s = %d", setting, milliseconds)
+}
@@ -31,3 +31,56 @@
  */
 
 package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	_ Querier = (*sqlx.DB)(nil)
+	_ Querier = (*sqlx.Tx)(nil)
+
+	_ TxManager     = (*Connection)(nil)
+	_ HealthChecker = (*Connection)(nil)
+)
+
+// Querier is context-aware common interface of *sqlx.DB and *sqlx.Tx...
+type Querier interface {
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+	QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row
+	PrepareNamedContext(ctx context.Context, query string) (*sqlx.NamedStmt, error)
+	Rebind(query string) string
+}
+
+// TxManager is the interface of transaction statement functions of Connection.
+// Can be replaced by fake implementation in unit tests, e.g. postgrestest.FakeTxManager...
+type TxManager interface {
+	BeginTxWithRollbackOnError(ctx context.Context,
+		callback func(txStmtCtx context.Context) error,
+		opts ...TxOption,
+	) error
+	BeginReadCommittedTxRollbackOnError(ctx context.Context,
+		callback func(txStmtCtx context.Context) error,
+		opts ...TxOption,
+	) error
+	BeginReadUncommittedTxRollbackOnError(ctx context.Context,
+		callback func(txStmtCtx context.Context) error,
+		opts ...TxOption,
+	) error
+	BeginContextualTxStatement(ctx context.Context, opts ...TxOption) (context.Context, error)
+	CommitContextualTxStatement(ctx context.Context, opts ...TxOption) error
+	RollbackContextualTxStatement(ctx context.Context) error
+	InTransaction(ctx context.Context) bool
+}
+
+// HealthChecker is the interface of connection health check...
+type HealthChecker interface {
+	IsHealed(ctx context.Context) bool
+}
@@ -0,0 +1,474 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx/reflectx"
+	"github.com/lib/pq"
+)
+
+// MaxQueryParameters is the maximum count of bind parameters in one statement, limited by PostgreSQL protocol...
+const MaxQueryParameters = 65535
+
+var (
+	ErrUpsertConflictTargetRequired = errors.New("conflict target required for ON CONFLICT DO UPDATE clause")
+	ErrUpsertUnknownColumn          = errors.New("column not found in struct db tags")
+	ErrUpsertEmptyUpdateColumns     = errors.New("empty list of columns for ON CONFLICT DO UPDATE clause")
+	ErrUpsertNoColumns              = errors.New("empty list of inserted columns - struct has no db columns")
+)
+
+type upsertPolicy uint8
+
+const (
+	upsertPolicyUpdateAll upsertPolicy = iota
+	upsertPolicyUpdateColumns
+	upsertPolicyDoNothing
+	upsertPolicyUpdateSet
+)
+
+// UpsertBuilder builds batched INSERT ... ON CONFLICT statements for slice of structs.
+// Columns derived from db tags, same with sqlx mapping...
+type UpsertBuilder[T any] struct {
+	table string
+
+	columns            []string
+	conflictColumns    []string
+	conflictConstraint string
+
+	policy        upsertPolicy
+	updateColumns []string
+	updateSet     string
+
+	returning []string
+
+	isBatchCommit bool
+}
+
+// NewUpsert ....
+func NewUpsert[T any](table string) *UpsertBuilder[T] {
+	return &UpsertBuilder[T]{
+		table:              table,
+		columns:            nil,
+		conflictColumns:    nil,
+		conflictConstraint: "",
+		policy:             upsertPolicyUpdateAll,
+		updateColumns:      nil,
+		updateSet:          "",
+		returning:          nil,
+		isBatchCommit:      false,
+	}
+}
+
+// Columns limits list of inserted columns. By default all columns of struct inserted...
+func (b *UpsertBuilder[T]) Columns(columns ...string) *UpsertBuilder[T] {
+	b.columns = columns
+
+	return b
+}
+
+// OnConflictColumns sets conflict target by list of columns...
+func (b *UpsertBuilder[T]) OnConflictColumns(columns ...string) *UpsertBuilder[T] {
+	b.conflictColumns = columns
+	b.conflictConstraint = ""
+
+	return b
+}
+
+// OnConflictConstraint sets conflict target by constraint name...
+func (b *UpsertBuilder[T]) OnConflictConstraint(constraint string) *UpsertBuilder[T] {
+	b.conflictConstraint = constraint
+	b.conflictColumns = nil
+
+	return b
+}
+
+// DoUpdateAll updates all inserted columns, except conflict target columns. Default policy...
+func (b *UpsertBuilder[T]) DoUpdateAll() *UpsertBuilder[T] {
+	b.policy = upsertPolicyUpdateAll
+
+	return b
+}
+
+// DoUpdateColumns updates only listed columns by EXCLUDED values...
+func (b *UpsertBuilder[T]) DoUpdateColumns(columns ...string) *UpsertBuilder[T] {
+	b.policy = upsertPolicyUpdateColumns
+	b.updateColumns = columns
+
+	return b
+}
+
+// DoNothing skips conflicting rows. Skipped rows not returned by RETURNING clause...
+func (b *UpsertBuilder[T]) DoNothing() *UpsertBuilder[T] {
+	b.policy = upsertPolicyDoNothing
+
+	return b
+}
+
+// DoUpdateSet sets custom SET expression of DO UPDATE clause,
+// for example - "balance = wallets.balance + EXCLUDED.balance"...
+func (b *UpsertBuilder[T]) DoUpdateSet(expression string) *UpsertBuilder[T] {
+	b.policy = upsertPolicyUpdateSet
+	b.updateSet = expression
+
+	return b
+}
+
+// Returning sets list of columns for RETURNING clause, used by ExecReturning...
+func (b *UpsertBuilder[T]) Returning(columns ...string) *UpsertBuilder[T] {
+	b.returning = columns
+
+	return b
+}
+
+// CommitEachBatch executes each batch as separate statement of connection pool, if upsert executed outside of
+// contextual transaction. Rows of previous batches stay in table, if one of next batches fails.
+// Ignored in contextual transaction...
+func (b *UpsertBuilder[T]) CommitEachBatch() *UpsertBuilder[T] {
+	b.isBatchCommit = true
+
+	return b
+}
+
+// Exec executes upsert statements in contextual transaction if present. Otherwise, all batches executed
+// in one own transaction, or with connection pool if items fit in one batch or CommitEachBatch set.
+// Items split into batches by MaxQueryParameters limit. Returns count of affected rows,
+// in case of error - count of rows of committed batches...
+func (b *UpsertBuilder[T]) Exec(ctx context.Context, conn *Connection, items []T) (int64, error) {
+	var affected int64
+
+	err := b.execBatches(ctx, conn, items, false, func(stmt Querier, query string, args []interface{}) error {
+		result, err := stmt.ExecContext(ctx, query, args...)
+		if err != nil {
+			return conn.ClassifyErrorContext(ctx, err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return conn.e.ErrorOnly(err)
+		}
+
+		affected += rowsAffected
+
+		return nil
+	})
+	if err != nil {
+		// batches before failed one rolled back, if they were not committed separately
+		if !b.isBatchCommit {
+			affected = 0
+		}
+
+		return affected, conn.e.ErrorNoWrap(err)
+	}
+
+	return affected, nil
+}
+
+// ExecReturning same with Exec, but scans rows of RETURNING clause into structs.
+// All struct columns returned if Returning columns not set...
+func (b *UpsertBuilder[T]) ExecReturning(ctx context.Context, conn *Connection, items []T) ([]T, error) {
+	result := make([]T, 0, len(items))
+
+	err := b.execBatches(ctx, conn, items, true, func(stmt Querier, query string, args []interface{}) error {
+		rows, err := stmt.QueryxContext(ctx, query, args...)
+		if err != nil {
+			return conn.ClassifyErrorContext(ctx, err)
+		}
+
+		defer func() {
+			_ = rows.Close()
+		}()
+
+		for rows.Next() {
+			var item T
+
+			err = rows.StructScan(&item)
+			if err != nil {
+				return conn.e.ErrorOnly(err)
+			}
+
+			result = append(result, item)
+		}
+
+		err = rows.Err()
+		if err != nil {
+			return conn.ClassifyErrorContext(ctx, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, conn.e.ErrorNoWrap(err)
+	}
+
+	return result, nil
+}
+
+func (b *UpsertBuilder[T]) execBatches(ctx context.Context,
+	conn *Connection,
+	items []T,
+	withReturning bool,
+	execFunc func(stmt Querier, query string, args []interface{}) error,
+) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	mapping, columns, err := b.resolveColumns(conn)
+	if err != nil {
+		return conn.e.ErrorOnly(err)
+	}
+
+	suffix, err := b.conflictClause(columns)
+	if err != nil {
+		return conn.e.ErrorOnly(err)
+	}
+
+	if withReturning {
+		suffix += b.returningClause(mapping.columns)
+	}
+
+	batchSize := MaxQueryParameters / len(columns)
+
+	_, inTransaction := conn.TxFromContext(ctx)
+	if !inTransaction && !b.isBatchCommit && len(items) > batchSize {
+		return conn.BeginReadCommittedTxRollbackOnError(ctx, func(txStmtCtx context.Context) error {
+			return b.execBatchQueries(txStmtCtx, conn, mapping, columns, items, suffix, batchSize, execFunc)
+		})
+	}
+
+	return b.execBatchQueries(ctx, conn, mapping, columns, items, suffix, batchSize, execFunc)
+}
+
+func (b *UpsertBuilder[T]) execBatchQueries(ctx context.Context,
+	conn *Connection,
+	mapping *structMapping,
+	columns []string,
+	items []T,
+	suffix string,
+	batchSize int,
+	execFunc func(stmt Querier, query string, args []interface{}) error,
+) error {
+	return conn.TryWithTransactionQuerier(ctx, func(stmt Querier) error {
+		for start := 0; start < len(items); start += batchSize {
+			end := min(start+batchSize, len(items))
+
+			query, args := b.batchQuery(mapping, columns, items[start:end], suffix)
+
+			execErr := execFunc(stmt, query, args)
+			if execErr != nil {
+				return execErr
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *UpsertBuilder[T]) resolveColumns(conn *Connection) (*structMapping, []string, error) {
+	mapping, err := newStructMapping(conn.Dbx.Mapper, reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := mapping.columns
+	if len(b.columns) != 0 {
+		columns = b.columns
+	}
+
+	if len(columns) == 0 {
+		return nil, nil, ErrUpsertNoColumns
+	}
+
+	for _, column := range columns {
+		_, isFound := mapping.field(column)
+		if !isFound {
+			return nil, nil, fmt.Errorf("%w: %s", ErrUpsertUnknownColumn, column)
+		}
+	}
+
+	return mapping, columns, nil
+}
+
+func (b *UpsertBuilder[T]) conflictClause(columns []string) (string, error) {
+	var clause strings.Builder
+
+	conflictTarget := ""
+
+	switch {
+	case b.conflictConstraint != "":
+		conflictTarget = " ON CONSTRAINT " + pq.QuoteIdentifier(b.conflictConstraint)
+	case len(b.conflictColumns) != 0:
+		conflictTarget = " (" + quoteIdentifiers(b.conflictColumns) + ")"
+	}
+
+	var updateColumns []string
+
+	//nolint:exhaustive // DoNothing policy has no update columns
+	switch b.policy {
+	case upsertPolicyDoNothing:
+		clause.WriteString(" ON CONFLICT" + conflictTarget + " DO NOTHING")
+
+		return clause.String(), nil
+	case upsertPolicyUpdateAll:
+		updateColumns = excludeColumns(columns, b.conflictColumns)
+	case upsertPolicyUpdateColumns:
+		updateColumns = b.updateColumns
+	}
+
+	if conflictTarget == "" {
+		return "", ErrUpsertConflictTargetRequired
+	}
+
+	clause.WriteString(" ON CONFLICT" + conflictTarget + " DO UPDATE SET ")
+
+	if b.policy == upsertPolicyUpdateSet {
+		clause.WriteString(b.updateSet)
+
+		return clause.String(), nil
+	}
+
+	if len(updateColumns) == 0 {
+		return "", ErrUpsertEmptyUpdateColumns
+	}
+
+	for i, column := range updateColumns {
+		if i != 0 {
+			clause.WriteString(", ")
+		}
+
+		quoted := pq.QuoteIdentifier(column)
+		clause.WriteString(quoted + " = EXCLUDED." + quoted)
+	}
+
+	return clause.String(), nil
+}
+
+// returningClause returns all struct columns by default - RETURNING * fails struct scan,
+// if table has columns without struct fields...
+func (b *UpsertBuilder[T]) returningClause(structColumns []string) string {
+	if len(b.returning) == 0 {
+		return " RETURNING " + quoteIdentifiers(structColumns)
+	}
+
+	return " RETURNING " + quoteIdentifiers(b.returning)
+}
+
+func (b *UpsertBuilder[T]) batchQuery(mapping *structMapping,
+	columns []string,
+	items []T,
+	suffix string,
+) (string, []interface{}) {
+	var query strings.Builder
+
+	args := make([]interface{}, 0, len(items)*len(columns))
+
+	query.WriteString("INSERT INTO " + quoteQualifiedIdentifier(b.table) +
+		" (" + quoteIdentifiers(columns) + ") VALUES ")
+
+	for i, item := range items {
+		if i != 0 {
+			query.WriteString(", ")
+		}
+
+		query.WriteString("(")
+
+		itemValue := reflect.Indirect(reflect.ValueOf(item))
+
+		for j, column := range columns {
+			if j != 0 {
+				query.WriteString(", ")
+			}
+
+			field, _ := mapping.field(column)
+			args = append(args, reflectx.FieldByIndexesReadOnly(itemValue, field.Index).Interface())
+
+			query.WriteString("$" + strconv.Itoa(len(args)))
+		}
+
+		query.WriteString(")")
+	}
+
+	query.WriteString(suffix)
+
+	return query.String(), args
+}
+
+func excludeColumns(columns, excluded []string) []string {
+	result := make([]string, 0, len(columns))
+
+	for _, column := range columns {
+		isExcluded := false
+
+		for _, excludedColumn := range excluded {
+			if column == excludedColumn {
+				isExcluded = true
+
+				break
+			}
+		}
+
+		if !isExcluded {
+			result = append(result, column)
+		}
+	}
+
+	return result
+}
+
+func quoteIdentifiers(identifiers []string) string {
+	quoted := make([]string, len(identifiers))
+
+	for i, identifier := range identifiers {
+		quoted[i] = pq.QuoteIdentifier(identifier)
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+// quoteQualifiedIdentifier quotes identifier, which can be qualified by schema name...
+func quoteQualifiedIdentifier(identifier string) string {
+	parts := strings.Split(identifier, ".")
+
+	for i, part := range parts {
+		parts[i] = pq.QuoteIdentifier(part)
+	}
+
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,336 @@
+/*
+ *
+ *
+ * MIT NON-AI License
+ *
+ * Copyright (c) 2022-2024 Aleksei Kotelnikov(gudron2s@gmail.com)
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy of the software and associated documentation files (the "Software"),
+ * to deal in the Software without restriction, including without limitation the rights to use, copy, modify, merge, publish, distribute, sublicense,
+ * and/or sell copies of the Software, and to permit persons to whom the Software is furnished to do so, subject to the following conditions.
+ *
+ * The above copyright notice and this permission notice shall be included in all copies or substantial portions of the Software.
+ *
+ * In addition, the following restrictions apply:
+ *
+ * 1. The Software and any modifications made to it may not be used for the purpose of training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining. This condition applies to any derivatives,
+ * modifications, or updates based on the Software code. Any usage of the Software in an AI-training dataset is considered a breach of this License.
+ *
+ * 2. The Software may not be included in any dataset used for training or improving machine learning algorithms,
+ * including but not limited to artificial intelligence, natural language processing, or data mining.
+ *
+ * 3. Any person or organization found to be in violation of these restrictions will be subject to legal action and may be held liable
+ * for any damages resulting from such use.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM,
+ * DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE
+ * OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ *
+ */
+
+package postgres
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type upsertTestWallet struct {
+	ID      int64  `db:"id"`
+	Name    string `db:"name"`
+	Balance string `db:"balance"`
+}
+
+func upsertTestWallets(count int) []upsertTestWallet {
+	wallets := make([]upsertTestWallet, count)
+
+	for i := range wallets {
+		wallets[i] = upsertTestWallet{ID: int64(i + 1), Name: "wallet", Balance: "0"}
+	}
+
+	return wallets
+}
+
+func TestUpsertQuery(t *testing.T) {
+	const insertQuery = `INSERT INTO "public"."wallets" ("id", "name", "balance") VALUES ($1, $2, $3), ($4, $5, $6)`
+
+	testCases := []struct {
+		name    string
+		builder *UpsertBuilder[upsertTestWallet]
+		query   string
+	}{
+		{
+			name:    "update all",
+			builder: NewUpsert[upsertTestWallet]("public.wallets").OnConflictColumns("id"),
+			query:   insertQuery + ` ON CONFLICT ("id") DO UPDATE SET "name" = EXCLUDED."name", "balance" = EXCLUDED."balance"`,
+		},
+		{
+			name: "update columns by constraint",
+			builder: NewUpsert[upsertTestWallet]("public.wallets").
+				OnConflictConstraint("wallets_pkey").DoUpdateColumns("balance"),
+			query: insertQuery + ` ON CONFLICT ON CONSTRAINT "wallets_pkey" DO UPDATE SET "balance" = EXCLUDED."balance"`,
+		},
+		{
+			name:    "do nothing without conflict target",
+			builder: NewUpsert[upsertTestWallet]("public.wallets").DoNothing(),
+			query:   insertQuery + ` ON CONFLICT DO NOTHING`,
+		},
+		{
+			name: "custom set expression",
+			builder: NewUpsert[upsertTestWallet]("public.wallets").OnConflictColumns("id").
+				DoUpdateSet("balance = wallets.balance + EXCLUDED.balance"),
+			query: insertQuery + ` ON CONFLICT ("id") DO UPDATE SET balance = wallets.balance + EXCLUDED.balance`,
+		},
+		{
+			name:    "listed columns",
+			builder: NewUpsert[upsertTestWallet]("public.wallets").Columns("id", "balance").OnConflictColumns("id"),
+			query: `INSERT INTO "public"."wallets" ("id", "balance") VALUES ($1, $2), ($3, $4)` +
+				` ON CONFLICT ("id") DO UPDATE SET "balance" = EXCLUDED."balance"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := &stubDatabase{}
+			conn := newStubConnection(t, db)
+
+			_, err := testCase.builder.Exec(context.Background(), conn, upsertTestWallets(2))
+			if err != nil {
+				t.Fatalf("Exec returned error: %v", err)
+			}
+
+			statement, isFound := db.find("INSERT")
+			if !isFound || statement.query != testCase.query {
+				t.Errorf("query = %s\nwant %s", statement.query, testCase.query)
+			}
+
+			if len(statement.args) != strings.Count(statement.query, "$") {
+				t.Errorf("args count = %d, want count of bind parameters", len(statement.args))
+			}
+		})
+	}
+}
+
+func TestUpsertInvalidBuilder(t *testing.T) {
+	type noColumns struct{}
+
+	testCases := []struct {
+		name string
+		exec func(conn *Connection) error
+		err  error
+	}{
+		{
+			name: "update without conflict target",
+			exec: func(conn *Connection) error {
+				_, err := NewUpsert[upsertTestWallet]("wallets").Exec(context.Background(), conn, upsertTestWallets(1))
+
+				return err
+			},
+			err: ErrUpsertConflictTargetRequired,
+		},
+		{
+			name: "unknown column",
+			exec: func(conn *Connection) error {
+				_, err := NewUpsert[upsertTestWallet]("wallets").Columns("id", "owner").DoNothing().
+					Exec(context.Background(), conn, upsertTestWallets(1))
+
+				return err
+			},
+			err: ErrUpsertUnknownColumn,
+		},
+		{
+			name: "empty update columns",
+			exec: func(conn *Connection) error {
+				_, err := NewUpsert[upsertTestWallet]("wallets").OnConflictColumns("id").DoUpdateColumns().
+					Exec(context.Background(), conn, upsertTestWallets(1))
+
+				return err
+			},
+			err: ErrUpsertEmptyUpdateColumns,
+		},
+		{
+			name: "struct without columns",
+			exec: func(conn *Connection) error {
+				_, err := NewUpsert[noColumns]("wallets").DoNothing().Exec(context.Background(), conn, []noColumns{{}})
+
+				return err
+			},
+			err: ErrUpsertNoColumns,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			db := &stubDatabase{}
+			conn := newStubConnection(t, db)
+
+			err := testCase.exec(conn)
+			if !errors.Is(err, testCase.err) {
+				t.Errorf("error = %v, want %v", err, testCase.err)
+			}
+
+			if _, isFound := db.find("INSERT"); isFound {
+				t.Error("invalid upsert statement executed")
+			}
+		})
+	}
+}
+
+func TestUpsertBatches(t *testing.T) {
+	errInsert := errors.New("insert failed")
+
+	// 3 columns, so 21845 rows in one batch
+	const batchSize = MaxQueryParameters / 3
+
+	testCases := []struct {
+		name          string
+		builder       *UpsertBuilder[upsertTestWallet]
+		itemsCount    int
+		failedBatch   int
+		affected      int64
+		insertCount   int
+		beginCount    int
+		rollbackCount int
+	}{
+		{
+			name: "one batch without transaction", builder: NewUpsert[upsertTestWallet]("wallets").DoNothing(),
+			itemsCount: batchSize, failedBatch: 0, affected: 1, insertCount: 1, beginCount: 0, rollbackCount: 0,
+		},
+		{
+			name: "batches in one transaction", builder: NewUpsert[upsertTestWallet]("wallets").DoNothing(),
+			itemsCount: batchSize + 1, failedBatch: 0, affected: 2, insertCount: 2, beginCount: 1, rollbackCount: 0,
+		},
+		{
+			name: "failed batch rolls back transaction", builder: NewUpsert[upsertTestWallet]("wallets").DoNothing(),
+			itemsCount: batchSize + 1, failedBatch: 2, affected: 0, insertCount: 2, beginCount: 1, rollbackCount: 1,
+		},
+		{
+			name:       "commit each batch",
+			builder:    NewUpsert[upsertTestWallet]("wallets").DoNothing().CommitEachBatch(),
+			itemsCount: 2*batchSize + 1, failedBatch: 0, affected: 3, insertCount: 3, beginCount: 0, rollbackCount: 0,
+		},
+		{
+			name:       "commit each batch failed",
+			builder:    NewUpsert[upsertTestWallet]("wallets").DoNothing().CommitEachBatch(),
+			itemsCount: 2*batchSize + 1, failedBatch: 2, affected: 1, insertCount: 2, beginCount: 0, rollbackCount: 0,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			inserts := 0
+			db := &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+				if !strings.HasPrefix(statement.query, "INSERT") {
+					return stubResult{}, false //nolint:exhaustruct // result not scripted
+				}
+
+				inserts++
+
+				if inserts != testCase.failedBatch {
+					return stubResult{}, false //nolint:exhaustruct // result not scripted
+				}
+
+				return stubResult{columns: nil, rows: nil, rowsAffected: 0, err: errInsert}, true
+			}}
+			conn := newStubConnection(t, db)
+
+			affected, err := testCase.builder.Exec(context.Background(), conn, upsertTestWallets(testCase.itemsCount))
+			if testCase.failedBatch == 0 && err != nil {
+				t.Fatalf("Exec returned error: %v", err)
+			}
+
+			if testCase.failedBatch != 0 && !errors.Is(err, errInsert) {
+				t.Fatalf("Exec error = %v, want %v", err, errInsert)
+			}
+
+			if affected != testCase.affected {
+				t.Errorf("affected = %d, want %d", affected, testCase.affected)
+			}
+
+			if inserts != testCase.insertCount || countStubQueries(db, "BEGIN") != testCase.beginCount ||
+				countStubQueries(db, "ROLLBACK") != testCase.rollbackCount {
+				t.Errorf("executed %d inserts, %d BEGIN and %d ROLLBACK statements", inserts,
+					countStubQueries(db, "BEGIN"), countStubQueries(db, "ROLLBACK"))
+			}
+
+			// last batch contains rest of items
+			statement, _ := db.find("INSERT")
+			restCount := testCase.itemsCount % batchSize
+			if testCase.failedBatch == 0 && restCount != 0 && len(statement.args) != restCount*3 {
+				t.Errorf("last batch args count = %d, want %d", len(statement.args), restCount*3)
+			}
+		})
+	}
+}
+
+func TestUpsertContextualTxIgnoresBatchCommit(t *testing.T) {
+	db := &stubDatabase{}
+	conn := newStubConnection(t, db)
+
+	err := conn.BeginTxWithRollbackOnError(context.Background(), func(txStmtCtx context.Context) error {
+		_, execErr := NewUpsert[upsertTestWallet]("wallets").DoNothing().
+			Exec(txStmtCtx, conn, upsertTestWallets(MaxQueryParameters/3+1))
+
+		return execErr
+	})
+	if err != nil {
+		t.Fatalf("transaction returned error: %v", err)
+	}
+
+	if countStubQueries(db, "BEGIN") != 1 || countStubQueries(db, "COMMIT") != 1 {
+		t.Errorf("batches not executed in caller's transaction")
+	}
+}
+
+func TestUpsertExecReturning(t *testing.T) {
+	const query = `INSERT INTO "wallets" ("id", "name", "balance") VALUES ($1, $2, $3)` +
+		` ON CONFLICT ("id") DO UPDATE SET "balance" = wallets.balance + EXCLUDED.balance`
+
+	db := &stubDatabase{respond: func(statement stubStatement) (stubResult, bool) {
+		if !strings.HasPrefix(statement.query, "INSERT") {
+			return stubResult{}, false //nolint:exhaustruct // result not scripted
+		}
+
+		return stubResult{
+			columns:      []string{"id", "name", "balance"},
+			rows:         [][]driver.Value{{int64(1), "wallet", "15"}},
+			rowsAffected: 0,
+			err:          nil,
+		}, true
+	}}
+	conn := newStubConnection(t, db)
+
+	builder := NewUpsert[upsertTestWallet]("wallets").OnConflictColumns("id").
+		DoUpdateSet(`"balance" = wallets.balance + EXCLUDED.balance`)
+
+	wallets, err := builder.ExecReturning(context.Background(), conn, upsertTestWallets(1))
+	if err != nil {
+		t.Fatalf("ExecReturning returned error: %v", err)
+	}
+
+	want := []upsertTestWallet{{ID: 1, Name: "wallet", Balance: "15"}}
+	if !reflect.DeepEqual(wallets, want) {
+		t.Errorf("wallets = %+v, want %+v", wallets, want)
+	}
+
+	statement, _ := db.find("INSERT")
+	if statement.query != query+` RETURNING "id", "name", "balance"` {
+		t.Errorf("query = %s", statement.query)
+	}
+
+	_, err = builder.Returning("id").ExecReturning(context.Background(), conn, upsertTestWallets(1))
+	if err != nil {
+		t.Fatalf("ExecReturning returned error: %v", err)
+	}
+
+	statement, _ = db.find("INSERT")
+	if statement.query != query+` RETURNING "id"` {
+		t.Errorf("query = %s", statement.query)
+	}
+}
@@ -38,8 +38,12 @@ import (
 	"fmt"
 )
 
+// EmptyOrError returns nil if err is nil or sql.ErrNoRows, otherwise wraps err with errorMessage...
+//
+// Deprecated: EmptyOrError loses distinction between "not found" and success.
+// Use GetOne, GetOptional, SelectAll, Exists and ExecExpectRows helpers instead.
 func EmptyOrError(err error, errorMessage string) error {
-	if errors.Is(err, sql.ErrNoRows) {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
 		return nil
 	}
 